@@ -1,12 +1,16 @@
 package admin
 
 import (
+	"encoding/json"
+	"io"
 	"log"
-	"net"
 	"net/http"
 	httpprof "net/http/pprof"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/buaazp/uq/metrics"
 	"github.com/buaazp/uq/queue"
 	"github.com/buaazp/uq/utils"
 )
@@ -14,10 +18,16 @@ import (
 const (
 	queuePrefixV1      = "/v1/queues"
 	adminPrefixV1      = "/v1/admin"
+	metricsPrefix      = "/metrics"
 	pprofPrefixCmd     = "/debug/pprof/cmdline"
 	pprofPrefixProfile = "/debug/pprof/profile"
 	pprofPrefixSymbol  = "/debug/pprof/symbol"
 	pprofPrefixIndex   = "/debug/pprof"
+
+	headerContentType = "X-UQ-Content-Type"
+	headerTimestamp   = "X-UQ-Timestamp"
+	headerGroup       = "X-UQ-Group"
+	headerPrefix      = "X-UQ-Header-"
 )
 
 // UnitedAdmin is the HTTP admin server of uq
@@ -26,7 +36,7 @@ type UnitedAdmin struct {
 	port         int
 	adminMux     map[string]func(http.ResponseWriter, *http.Request, string)
 	server       *http.Server
-	stopListener *utils.StopListener
+	stopGuard    utils.StopGuard
 	messageQueue queue.MessageQueue
 }
 
@@ -35,9 +45,16 @@ func NewUnitedAdmin(host string, port int, messageQueue queue.MessageQueue) (*Un
 	s := new(UnitedAdmin)
 
 	s.adminMux = map[string]func(http.ResponseWriter, *http.Request, string){
-		"/stat":  s.statHandler,
-		"/empty": s.emptyHandler,
-		"/rm":    s.rmHandler,
+		"/stat":    s.statHandler,
+		"/peek":    s.peekHandler,
+		"/topics":  s.topicsHandler,
+		"/empty":   s.emptyHandler,
+		"/seek":    s.seekHandler,
+		"/rm":      s.rmHandler,
+		"/backup":  s.backupHandler,
+		"/restore": s.restoreHandler,
+		"/export":  s.exportHandler,
+		"/import":  s.importHandler,
 	}
 
 	addr := utils.Addrcat(host, port)
@@ -54,7 +71,7 @@ func NewUnitedAdmin(host string, port int, messageQueue queue.MessageQueue) (*Un
 }
 
 func (s *UnitedAdmin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if !utils.AllowMethod(w, req.Method, "HEAD", "GET", "POST", "PUT", "DELETE") {
+	if !utils.AllowMethod(w, req.Method, "HEAD", "GET", "POST", "PUT", "DELETE", "PATCH") {
 		return
 	}
 
@@ -66,6 +83,9 @@ func (s *UnitedAdmin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		key := req.URL.Path[len(adminPrefixV1):]
 		s.adminHandler(w, req, key)
 		return
+	} else if req.URL.Path == metricsPrefix {
+		s.metricsHandler(w, req)
+		return
 	} else if strings.HasPrefix(req.URL.Path, pprofPrefixCmd) {
 		httpprof.Cmdline(w, req)
 		return
@@ -94,6 +114,8 @@ func (s *UnitedAdmin) queueHandler(w http.ResponseWriter, req *http.Request, key
 		s.popHandler(w, req, key)
 	case "DELETE":
 		s.delHandler(w, req, key)
+	case "PATCH":
+		s.patchHandler(w, req, key)
 	default:
 		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
 	}
@@ -113,10 +135,50 @@ func (s *UnitedAdmin) adminHandler(w http.ResponseWriter, req *http.Request, key
 	return
 }
 
+// readMessageHTTP reads a message with its content type and headers from
+// a push request
+func readMessageHTTP(req *http.Request) *queue.Message {
+	msg := new(queue.Message)
+	msg.Body = []byte(req.FormValue("value"))
+	msg.ContentType = req.Header.Get(headerContentType)
+	msg.Group = req.Header.Get(headerGroup)
+	prefix := http.CanonicalHeaderKey(headerPrefix)
+	for k, vs := range req.Header {
+		if strings.HasPrefix(k, prefix) && len(vs) > 0 {
+			msg.SetHeader(k[len(prefix):], vs[0])
+		}
+	}
+	return msg
+}
+
+// writeMessageHTTP writes a popped message and its metadata to response
+func writeMessageHTTP(w http.ResponseWriter, id string, msg *queue.Message) {
+	contentType := msg.ContentType
+	if contentType == "" {
+		contentType = "text/plain"
+	} else {
+		w.Header().Set(headerContentType, msg.ContentType)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-UQ-ID", id)
+	if msg.Timestamp > 0 {
+		w.Header().Set(headerTimestamp, strconv.FormatInt(msg.Timestamp, 10))
+	}
+	if msg.Group != "" {
+		w.Header().Set(headerGroup, msg.Group)
+	}
+	for _, h := range msg.Headers {
+		w.Header().Set(headerPrefix+h.Key, h.Value)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(msg.Body)
+}
+
 func writeErrorHTTP(w http.ResponseWriter, err error) {
 	if err == nil {
 		return
 	}
+	metrics.Error(err)
 	switch e := err.(type) {
 	case *utils.Error:
 		e.WriteTo(w)
@@ -140,6 +202,20 @@ func (s *UnitedAdmin) addHandler(w http.ResponseWriter, req *http.Request, key s
 	lineName := req.FormValue("line")
 	key = topicName + "/" + lineName
 	recycle := req.FormValue("recycle")
+	if attempts := req.FormValue("attempts"); attempts != "" {
+		recycle += ",attempts=" + attempts
+	}
+	if dead := req.FormValue("dead"); dead != "" {
+		recycle += ",dead=" + dead
+	}
+	if filter := req.FormValue("filter"); filter != "" {
+		recycle += ",filter=" + filter
+	}
+	for _, opt := range []string{"age", "count", "bytes", "dedup", "priorities"} {
+		if value := req.FormValue(opt); value != "" {
+			recycle += "," + opt + "=" + value
+		}
+	}
 
 	// log.Printf("creating... %s %s", key, recycle)
 	err = s.messageQueue.Create(key, recycle)
@@ -160,26 +236,50 @@ func (s *UnitedAdmin) pushHandler(w http.ResponseWriter, req *http.Request, key
 		return
 	}
 
-	data := []byte(req.FormValue("value"))
-	err = s.messageQueue.Push(key, data)
+	opts := new(queue.PushOptions)
+	opts.Dedup = req.FormValue("dedup")
+	if delayValue := req.FormValue("delay"); delayValue != "" {
+		opts.Delay, err = utils.ParseTimeout(delayValue)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+	}
+
+	msg := readMessageHTTP(req)
+	id, err := s.messageQueue.PushMessage(key, msg, opts)
 	if err != nil {
 		writeErrorHTTP(w, err)
 		return
 	}
+	w.Header().Set("X-UQ-ID", id)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (s *UnitedAdmin) popHandler(w http.ResponseWriter, req *http.Request, key string) {
-	id, data, err := s.messageQueue.Pop(key)
+	var timeout time.Duration
+	var err error
+	if wait := req.FormValue("wait"); wait != "" {
+		timeout, err = utils.ParseTimeout(wait)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+	}
+
+	id, msg, err := s.messageQueue.PopMessage(key, timeout)
 	if err != nil {
 		writeErrorHTTP(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("X-UQ-ID", id)
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+	writeMessageHTTP(w, id, msg)
 }
 
 func (s *UnitedAdmin) delHandler(w http.ResponseWriter, req *http.Request, key string) {
@@ -191,6 +291,38 @@ func (s *UnitedAdmin) delHandler(w http.ResponseWriter, req *http.Request, key s
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (s *UnitedAdmin) patchHandler(w http.ResponseWriter, req *http.Request, key string) {
+	err := req.ParseForm()
+	if err != nil {
+		writeErrorHTTP(w, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		))
+		return
+	}
+
+	touch := req.FormValue("touch")
+	if touch == "" {
+		err = s.messageQueue.Nack(key)
+	} else {
+		var timeout time.Duration
+		timeout, err = utils.ParseTimeout(touch)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+		err = s.messageQueue.Touch(key, timeout)
+	}
+	if err != nil {
+		writeErrorHTTP(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (s *UnitedAdmin) statHandler(w http.ResponseWriter, req *http.Request, key string) {
 	if req.Method != "GET" {
 		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
@@ -218,6 +350,136 @@ func (s *UnitedAdmin) statHandler(w http.ResponseWriter, req *http.Request, key
 	w.Write(data)
 }
 
+func (s *UnitedAdmin) topicsHandler(w http.ResponseWriter, req *http.Request, key string) {
+	if req.Method != "GET" {
+		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+
+	qss, err := s.messageQueue.List()
+	if err != nil {
+		writeErrorHTTP(w, err)
+		return
+	}
+
+	data, err := json.Marshal(qss)
+	if err != nil {
+		writeErrorHTTP(w, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (s *UnitedAdmin) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+
+	qss, err := s.messageQueue.List()
+	if err != nil {
+		writeErrorHTTP(w, err)
+		return
+	}
+
+	var topicHead, topicTail, topicCount []metrics.Sample
+	var lineHead, lineIHead, lineTail, lineCount, lineInflight []metrics.Sample
+	for _, qs := range qss {
+		labels := []string{"topic", qs.Name}
+		topicHead = append(topicHead, metrics.Sample{Labels: labels, Value: float64(qs.Head)})
+		topicTail = append(topicTail, metrics.Sample{Labels: labels, Value: float64(qs.Tail)})
+		topicCount = append(topicCount, metrics.Sample{Labels: labels, Value: float64(qs.Count)})
+		for _, ls := range qs.Lines {
+			lineName := strings.TrimPrefix(ls.Name, qs.Name+"/")
+			labels := []string{"topic", qs.Name, "line", lineName}
+			lineHead = append(lineHead, metrics.Sample{Labels: labels, Value: float64(ls.Head)})
+			lineIHead = append(lineIHead, metrics.Sample{Labels: labels, Value: float64(ls.IHead)})
+			lineTail = append(lineTail, metrics.Sample{Labels: labels, Value: float64(ls.Tail)})
+			lineCount = append(lineCount, metrics.Sample{Labels: labels, Value: float64(ls.Count)})
+			lineInflight = append(lineInflight, metrics.Sample{Labels: labels, Value: float64(ls.Inflight)})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	metrics.WriteMetric(w, "uq_topic_head", "gauge", "Head offset of topic.", topicHead)
+	metrics.WriteMetric(w, "uq_topic_tail", "gauge", "Tail offset of topic.", topicTail)
+	metrics.WriteMetric(w, "uq_topic_count", "gauge", "Number of messages in topic.", topicCount)
+	metrics.WriteMetric(w, "uq_line_head", "gauge", "Head offset of line.", lineHead)
+	metrics.WriteMetric(w, "uq_line_ihead", "gauge", "Inflight head offset of line.", lineIHead)
+	metrics.WriteMetric(w, "uq_line_tail", "gauge", "Tail offset of line.", lineTail)
+	metrics.WriteMetric(w, "uq_line_count", "gauge", "Number of messages not consumed by line.", lineCount)
+	metrics.WriteMetric(w, "uq_line_inflight", "gauge", "Number of inflight messages of line.", lineInflight)
+	metrics.WriteTo(w)
+}
+
+// peekMessage is a peeked message in the reply of peek api
+type peekMessage struct {
+	ID   string `json:"id"`
+	Body []byte `json:"body"`
+}
+
+func (s *UnitedAdmin) peekHandler(w http.ResponseWriter, req *http.Request, key string) {
+	if req.Method != "GET" {
+		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var offset uint64
+	var err error
+	if from := req.FormValue("from"); from != "" {
+		offset, err = strconv.ParseUint(from, 10, 0)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+	}
+	n := 1
+	if nv := req.FormValue("n"); nv != "" {
+		n, err = strconv.Atoi(nv)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+	}
+
+	ids, datas, err := s.messageQueue.Peek(key, offset, n)
+	if err != nil {
+		writeErrorHTTP(w, err)
+		return
+	}
+
+	msgs := make([]peekMessage, len(ids))
+	for i, id := range ids {
+		msgs[i].ID = id
+		msgs[i].Body = datas[i]
+	}
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		writeErrorHTTP(w, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
 func (s *UnitedAdmin) emptyHandler(w http.ResponseWriter, req *http.Request, key string) {
 	if req.Method != "DELETE" {
 		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
@@ -232,6 +494,65 @@ func (s *UnitedAdmin) emptyHandler(w http.ResponseWriter, req *http.Request, key
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (s *UnitedAdmin) seekHandler(w http.ResponseWriter, req *http.Request, key string) {
+	if req.Method != "PUT" {
+		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := req.ParseForm()
+	if err != nil {
+		writeErrorHTTP(w, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		))
+		return
+	}
+
+	if offset := req.FormValue("offset"); offset != "" {
+		var id uint64
+		id, err = strconv.ParseUint(offset, 10, 0)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+		err = s.messageQueue.Seek(key, id)
+	} else if tv := req.FormValue("time"); tv != "" {
+		var ts time.Time
+		ts, err = parseTime(tv)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+		err = s.messageQueue.SeekTime(key, ts)
+	} else {
+		err = utils.NewError(
+			utils.ErrBadRequest,
+			`seek need offset or time`,
+		)
+	}
+	if err != nil {
+		writeErrorHTTP(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTime parses a time in RFC3339 or unix nanoseconds like X-UQ-Timestamp
+func parseTime(str string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(str, 10, 64)
+	if err == nil {
+		return time.Unix(0, nanos), nil
+	}
+	return time.Parse(time.RFC3339Nano, str)
+}
+
 func (s *UnitedAdmin) rmHandler(w http.ResponseWriter, req *http.Request, key string) {
 	if req.Method != "DELETE" {
 		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
@@ -246,26 +567,102 @@ func (s *UnitedAdmin) rmHandler(w http.ResponseWriter, req *http.Request, key st
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// ListenAndServe implements the ListenAndServe interface
-func (s *UnitedAdmin) ListenAndServe() error {
-	addr := utils.Addrcat(s.host, s.port)
-	l, err := net.Listen("tcp", addr)
+// archiveWriter writes an archive to response, the headers are written
+// with the first bytes so that an error before them can still be replied
+type archiveWriter struct {
+	w        http.ResponseWriter
+	filename string
+	written  bool
+}
+
+func (aw *archiveWriter) Write(p []byte) (int, error) {
+	if !aw.written {
+		aw.w.Header().Set("Content-Type", "application/octet-stream")
+		aw.w.Header().Set("Content-Disposition", `attachment; filename="`+aw.filename+`"`)
+		aw.w.WriteHeader(http.StatusOK)
+		aw.written = true
+	}
+	return aw.w.Write(p)
+}
+
+// writeArchiveHTTP writes the archive made by the write func to response
+func writeArchiveHTTP(w http.ResponseWriter, filename string, write func(io.Writer) error) {
+	aw := &archiveWriter{w: w, filename: filename}
+	err := write(aw)
 	if err != nil {
-		return err
+		if !aw.written {
+			writeErrorHTTP(w, err)
+			return
+		}
+		// the archive is broken without its end, the client finds it
+		metrics.Error(err)
+		log.Printf("archive %s error: %s", filename, err)
 	}
+}
 
-	stopListener, err := utils.NewStopListener(l)
+func (s *UnitedAdmin) backupHandler(w http.ResponseWriter, req *http.Request, key string) {
+	if req.Method != "POST" {
+		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeArchiveHTTP(w, "uq.backup", s.messageQueue.Backup)
+}
+
+func (s *UnitedAdmin) restoreHandler(w http.ResponseWriter, req *http.Request, key string) {
+	if req.Method != "POST" {
+		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := s.messageQueue.Restore(req.Body)
+	if err != nil {
+		writeErrorHTTP(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *UnitedAdmin) exportHandler(w http.ResponseWriter, req *http.Request, key string) {
+	if req.Method != "POST" {
+		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.Trim(key, "/")
+	writeArchiveHTTP(w, name+".topic", func(aw io.Writer) error {
+		return s.messageQueue.ExportTopic(name, aw)
+	})
+}
+
+func (s *UnitedAdmin) importHandler(w http.ResponseWriter, req *http.Request, key string) {
+	if req.Method != "POST" {
+		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := s.messageQueue.ImportTopic(req.Body)
+	if err != nil {
+		writeErrorHTTP(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// ListenAndServe implements the ListenAndServe interface
+func (s *UnitedAdmin) ListenAndServe() error {
+	addr := utils.Addrcat(s.host, s.port)
+	stopListener, err := s.stopGuard.Listen(addr)
 	if err != nil {
 		return err
 	}
-	s.stopListener = stopListener
 
 	log.Printf("admin server serving at %s...", addr)
-	return s.server.Serve(s.stopListener)
+	return s.server.Serve(stopListener)
 }
 
 // Stop implements the Stop interface
 func (s *UnitedAdmin) Stop() {
 	log.Printf("admin server stoping...")
-	s.stopListener.Stop()
+	s.stopGuard.Stop()
 }
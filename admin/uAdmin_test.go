@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/buaazp/uq/queue"
 	"github.com/buaazp/uq/store"
@@ -40,6 +42,18 @@ func TestNewAdmin(t *testing.T) {
 		go func() {
 			adminServer.ListenAndServe()
 		}()
+
+		// the admin is ready once its port is dialed
+		var conn net.Conn
+		for i := 0; i < 100; i++ {
+			conn, err = net.Dial("tcp", "127.0.0.1:8800")
+			if err == nil {
+				conn.Close()
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(err, ShouldBeNil)
 	})
 }
 
@@ -90,6 +104,44 @@ func TestAdminPush(t *testing.T) {
 		resp, err := client.Do(req)
 		So(err, ShouldBeNil)
 		So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+		So(resp.Header.Get("X-UQ-ID"), ShouldEqual, "foo/0")
+	})
+}
+
+func TestAdminPeek(t *testing.T) {
+	Convey("Test Admin Peek Api", t, func() {
+		req, err := http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8800/v1/admin/peek/foo/x?from=0&n=10",
+			nil,
+		)
+		So(err, ShouldBeNil)
+
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		var msgs []struct {
+			ID   string `json:"id"`
+			Body []byte `json:"body"`
+		}
+		err = json.Unmarshal(body, &msgs)
+		So(err, ShouldBeNil)
+		So(len(msgs), ShouldEqual, 1)
+		So(msgs[0].ID, ShouldEqual, "foo/x/0")
+		So(string(msgs[0].Body), ShouldEqual, "1")
+
+		req, err = http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8800/v1/admin/peek/foo/x?from=0&n=1025",
+			nil,
+		)
+		So(err, ShouldBeNil)
+		resp, err = client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
 	})
 }
 
@@ -151,6 +203,153 @@ func TestAdminStat(t *testing.T) {
 	})
 }
 
+func TestAdminSeek(t *testing.T) {
+	Convey("Test Admin Seek Api", t, func() {
+		req, err := http.NewRequest(
+			"PUT",
+			"http://127.0.0.1:8800/v1/admin/seek/foo/x?offset=0",
+			nil,
+		)
+		So(err, ShouldBeNil)
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+
+		req, err = http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8800/v1/queues/foo/x",
+			nil,
+		)
+		So(err, ShouldBeNil)
+		resp, err = client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		So(resp.Header.Get("X-UQ-ID"), ShouldEqual, "foo/x/0")
+
+		req, err = http.NewRequest(
+			"PUT",
+			"http://127.0.0.1:8800/v1/admin/seek/foo/x?offset=100",
+			nil,
+		)
+		So(err, ShouldBeNil)
+		resp, err = client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+}
+
+func TestAdminTopics(t *testing.T) {
+	Convey("Test Admin Topics Api", t, func() {
+		req, err := http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8800/v1/admin/topics",
+			nil,
+		)
+		So(err, ShouldBeNil)
+
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		var qss []queue.Stat
+		err = json.Unmarshal(body, &qss)
+		So(err, ShouldBeNil)
+		So(len(qss), ShouldEqual, 1)
+		So(qss[0].Name, ShouldEqual, "foo")
+		So(qss[0].Lines[0].Name, ShouldEqual, "foo/x")
+	})
+}
+
+func TestAdminMetrics(t *testing.T) {
+	Convey("Test Admin Metrics Api", t, func() {
+		req, err := http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8800/metrics",
+			nil,
+		)
+		So(err, ShouldBeNil)
+
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(body, []byte(`uq_topic_tail{topic="foo"}`)), ShouldBeTrue)
+		So(bytes.Contains(body, []byte(`uq_line_inflight{topic="foo",line="x"}`)), ShouldBeTrue)
+		So(bytes.Contains(body, []byte("uq_pushes_total")), ShouldBeTrue)
+	})
+}
+
+func TestAdminBackup(t *testing.T) {
+	Convey("Test Admin Backup Api", t, func() {
+		resp, err := client.Get("http://127.0.0.1:8800/v1/admin/backup")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+
+		resp, err = client.Post("http://127.0.0.1:8800/v1/admin/backup", "", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		So(resp.Header.Get("Content-Type"), ShouldEqual, "application/octet-stream")
+		archive, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		So(err, ShouldBeNil)
+		So(bytes.HasPrefix(archive, []byte("UQBK")), ShouldBeTrue)
+
+		// the queue is not empty
+		resp, err = client.Post(
+			"http://127.0.0.1:8800/v1/admin/restore",
+			"application/octet-stream",
+			bytes.NewReader(archive),
+		)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+		archive[len(archive)-1]++
+		resp, err = client.Post(
+			"http://127.0.0.1:8800/v1/admin/restore",
+			"application/octet-stream",
+			bytes.NewReader(archive),
+		)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, "crc mismatch")
+	})
+}
+
+func TestAdminExport(t *testing.T) {
+	Convey("Test Admin Export Api", t, func() {
+		resp, err := client.Post("http://127.0.0.1:8800/v1/admin/export/none", "", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+
+		resp, err = client.Post("http://127.0.0.1:8800/v1/admin/export/foo", "", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		So(resp.Header.Get("Content-Disposition"), ShouldContainSubstring, "foo.topic")
+		archive, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		So(err, ShouldBeNil)
+		So(bytes.HasPrefix(archive, []byte("UQBK")), ShouldBeTrue)
+
+		// the topic is existed
+		resp, err = client.Post(
+			"http://127.0.0.1:8800/v1/admin/import",
+			"application/octet-stream",
+			bytes.NewReader(archive),
+		)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, "Topic Has Existed")
+	})
+}
+
 func TestAdminEmpty(t *testing.T) {
 	Convey("Test Admin Empty Api", t, func() {
 		req, err := http.NewRequest(
@@ -0,0 +1,163 @@
+// Package conf reads the config file of uq. The file is in a flat subset of
+// TOML: `key = value` pairs grouped by `[section]` headers, where a value is
+// a quoted string, an integer or a boolean, and `#` starts a comment.
+package conf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buaazp/uq/utils"
+)
+
+// Config is the pairs in a config file. The key in a section is prefixed
+// with the section name and a dot, like "queue.clean_interval".
+type Config map[string]string
+
+// Load reads and parses a config file
+func Load(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Parse parses the content of a config file
+func Parse(r io.Reader) (Config, error) {
+	c := make(Config)
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: bad section %s", n, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("line %d: empty section name", n)
+			}
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.TrimSpace(line[:i])
+		value, err := parseValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		if _, ok := c[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicated key %s", n, key)
+		}
+		c[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// stripComment removes the comment out of quoted strings in a line
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0 && ch == '\\' && quote == '"':
+			i++
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		case quote == 0 && ch == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseValue(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("empty value")
+	}
+	switch value[0] {
+	case '"':
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", value)
+		}
+		return s, nil
+	case '\'':
+		if len(value) < 2 || value[len(value)-1] != '\'' {
+			return "", fmt.Errorf("bad string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	}
+	if value == "true" || value == "false" {
+		return value, nil
+	}
+	if _, err := strconv.ParseInt(strings.Replace(value, "_", "", -1), 10, 64); err != nil {
+		return "", fmt.Errorf("bad value %s", value)
+	}
+	return strings.Replace(value, "_", "", -1), nil
+}
+
+// Keys returns the sorted keys in the config
+func (c Config) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Int returns the integer value of key, or def if the key is not set
+func (c Config) Int(key string, def int) (int, error) {
+	value, ok := c[key]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s should be an integer", key)
+	}
+	return i, nil
+}
+
+// Duration returns the duration value of key, or def if the key is not set.
+// A plain number is in seconds, like the timeouts in uq's protocols.
+func (c Config) Duration(key string, def time.Duration) (time.Duration, error) {
+	value, ok := c[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := utils.ParseTimeout(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s should be a duration", key)
+	}
+	return d, nil
+}
@@ -0,0 +1,74 @@
+package conf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testConfig = `
+# uq config
+port = 8808
+protocol = "redis,http:8080" # frontends
+dir = './data # not a comment'
+
+[queue]
+clean_interval = "1m"
+clean_timeout = 3
+backup_interval = 1_000
+`
+
+func TestParse(t *testing.T) {
+	Convey("Test Parse Config", t, func() {
+		c, err := Parse(strings.NewReader(testConfig))
+		So(err, ShouldBeNil)
+		So(c.Keys(), ShouldResemble, []string{
+			"dir",
+			"port",
+			"protocol",
+			"queue.backup_interval",
+			"queue.clean_interval",
+			"queue.clean_timeout",
+		})
+		So(c["protocol"], ShouldEqual, "redis,http:8080")
+		So(c["dir"], ShouldEqual, "./data # not a comment")
+
+		port, err := c.Int("port", 0)
+		So(err, ShouldBeNil)
+		So(port, ShouldEqual, 8808)
+		port, err = c.Int("admin-port", 8809)
+		So(err, ShouldBeNil)
+		So(port, ShouldEqual, 8809)
+		_, err = c.Int("dir", 0)
+		So(err, ShouldNotBeNil)
+
+		d, err := c.Duration("queue.clean_interval", 0)
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, time.Minute)
+		d, err = c.Duration("queue.clean_timeout", 0)
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, 3*time.Second)
+		d, err = c.Duration("queue.backup_interval", 0)
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, 1000*time.Second)
+	})
+}
+
+func TestParseError(t *testing.T) {
+	Convey("Test Parse Bad Config", t, func() {
+		for _, content := range []string{
+			"port",
+			"port = ",
+			"port = abc",
+			"dir = \"./data",
+			"[queue",
+			"[]",
+			"port = 1\nport = 2",
+		} {
+			_, err := Parse(strings.NewReader(content))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/buaazp/uq/conf"
+	"github.com/buaazp/uq/entry"
+	"github.com/buaazp/uq/queue"
+)
+
+var (
+	// configFile is the path of config file, which is read again on SIGHUP
+	configFile string
+	// loadedConfig is the config file loaded when uq starts
+	loadedConfig conf.Config
+)
+
+// tunableKeys are the keys in config sections, which can be reloaded
+var tunableKeys = []string{
+	"queue.backup_interval",
+	"queue.clean_interval",
+	"queue.clean_timeout",
+	"queue.etcd_ttl",
+	"entry.max_key_length",
+	"entry.max_body_length",
+}
+
+// checkConfig checks that all the keys in config are known
+func checkConfig(c conf.Config) error {
+	for _, key := range c.Keys() {
+		if belong(key, tunableKeys) || key == "config" {
+			continue
+		}
+		if flag.Lookup(key) == nil {
+			return fmt.Errorf("unknown config key %s", key)
+		}
+	}
+	if _, ok := c["config"]; ok {
+		return fmt.Errorf("config can not be set in config file")
+	}
+	return nil
+}
+
+// applyFlags sets the flags with the values in config, the flags given in
+// command line take precedence over the config file
+func applyFlags(c conf.Config) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for _, key := range c.Keys() {
+		if belong(key, tunableKeys) || given[key] {
+			continue
+		}
+		err := flag.Set(key, c[key])
+		if err != nil {
+			return fmt.Errorf("bad value of %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// applyTunables applies the tunables in config, the unset ones are reset to
+// their defaults
+func applyTunables(c conf.Config) error {
+	var err error
+	def := queue.DefaultTunables()
+	t := def
+	if t.BackupInterval, err = c.Duration("queue.backup_interval", def.BackupInterval); err != nil {
+		return err
+	}
+	if t.CleanInterval, err = c.Duration("queue.clean_interval", def.CleanInterval); err != nil {
+		return err
+	}
+	if t.CleanTimeout, err = c.Duration("queue.clean_timeout", def.CleanTimeout); err != nil {
+		return err
+	}
+	if t.EtcdTTL, err = c.Duration("queue.etcd_ttl", def.EtcdTTL); err != nil {
+		return err
+	}
+	keyLength, err := c.Int("entry.max_key_length", entry.MaxKeyLength)
+	if err != nil {
+		return err
+	}
+	bodyLength, err := c.Int("entry.max_body_length", entry.MaxBodyLength)
+	if err != nil {
+		return err
+	}
+
+	if keyLength <= 0 || bodyLength <= 0 {
+		return fmt.Errorf("max lengths of entry should be positive")
+	}
+
+	err = queue.SetTunables(t)
+	if err != nil {
+		return err
+	}
+	return entry.SetLimits(keyLength, bodyLength)
+}
+
+// loadConfig loads the config file before uq starts
+func loadConfig() error {
+	if configFile == "" {
+		return nil
+	}
+	c, err := conf.Load(configFile)
+	if err != nil {
+		return err
+	}
+	err = checkConfig(c)
+	if err != nil {
+		return err
+	}
+	err = applyFlags(c)
+	if err != nil {
+		return err
+	}
+	err = applyTunables(c)
+	if err != nil {
+		return err
+	}
+	loadedConfig = c
+	return nil
+}
+
+// reloadConfig reloads the config file when uq is running. Only the
+// tunables are applied, the changed flags need a restart of uq.
+func reloadConfig() error {
+	if configFile == "" {
+		return fmt.Errorf("no config file to reload")
+	}
+	c, err := conf.Load(configFile)
+	if err != nil {
+		return err
+	}
+	err = checkConfig(c)
+	if err != nil {
+		return err
+	}
+	err = applyTunables(c)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range flagKeys(c, loadedConfig) {
+		if c[key] != loadedConfig[key] {
+			log.Printf("config %s is changed, restart uq to apply it", key)
+		}
+	}
+	return nil
+}
+
+// flagKeys returns the keys of flags in the configs
+func flagKeys(configs ...conf.Config) []string {
+	var keys []string
+	for _, c := range configs {
+		for _, key := range c.Keys() {
+			if !belong(key, tunableKeys) && !belong(key, keys) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
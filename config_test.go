@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/buaazp/uq/queue"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfig(t *testing.T) {
+	Convey("Test Load And Reload Config", t, func() {
+		dir, err := ioutil.TempDir("", "uqconfig")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		configFile = path.Join(dir, "uq.toml")
+		defer func() {
+			configFile = ""
+			queue.SetTunables(queue.DefaultTunables())
+		}()
+
+		err = ioutil.WriteFile(configFile, []byte(`
+admin-port = 9909
+cluster = "test"
+[queue]
+clean_interval = "1m"
+`), 0644)
+		So(err, ShouldBeNil)
+		err = loadConfig()
+		So(err, ShouldBeNil)
+		So(adminPort, ShouldEqual, 9909)
+		So(cluster, ShouldEqual, "test")
+		So(queue.GetTunables().CleanInterval, ShouldEqual, time.Minute)
+
+		err = ioutil.WriteFile(configFile, []byte(`
+admin-port = 9910
+cluster = "test"
+[queue]
+clean_timeout = "1s"
+`), 0644)
+		So(err, ShouldBeNil)
+		err = reloadConfig()
+		So(err, ShouldBeNil)
+		So(adminPort, ShouldEqual, 9909)
+		So(queue.GetTunables().CleanInterval, ShouldEqual, queue.DefaultTunables().CleanInterval)
+		So(queue.GetTunables().CleanTimeout, ShouldEqual, time.Second)
+
+		err = ioutil.WriteFile(configFile, []byte("unknown = 1\n"), 0644)
+		So(err, ShouldBeNil)
+		So(reloadConfig(), ShouldNotBeNil)
+		err = ioutil.WriteFile(configFile, []byte("[queue]\nclean_timeout = \"-1s\"\n"), 0644)
+		So(err, ShouldBeNil)
+		So(reloadConfig(), ShouldNotBeNil)
+		So(queue.GetTunables().CleanTimeout, ShouldEqual, time.Second)
+
+		adminPort = 8809
+		cluster = "uq"
+	})
+}
@@ -1,13 +1,46 @@
 package entry
 
+import (
+	"sync/atomic"
+
+	"github.com/buaazp/uq/utils"
+)
+
 const (
-	// MaxKeyLength is the max length of a key
+	// MaxKeyLength is the default max length of a key
 	MaxKeyLength int = 512
-	// MaxBodyLength is the max body length of a request
+	// MaxBodyLength is the default max body length of a request
 	MaxBodyLength int = 10 * 1024 * 1024
 )
 
-// Entrance is the interface of uq's entrance
+var (
+	maxKeyLength  = int64(MaxKeyLength)
+	maxBodyLength = int64(MaxBodyLength)
+)
+
+// SetLimits changes the max length of keys and request bodies at runtime
+func SetLimits(keyLength, bodyLength int) error {
+	if keyLength <= 0 || bodyLength <= 0 {
+		return utils.NewError(
+			utils.ErrBadRequest,
+			`limits should be positive`,
+		)
+	}
+	atomic.StoreInt64(&maxKeyLength, int64(keyLength))
+	atomic.StoreInt64(&maxBodyLength, int64(bodyLength))
+	return nil
+}
+
+func keyLengthLimit() int {
+	return int(atomic.LoadInt64(&maxKeyLength))
+}
+
+func bodyLengthLimit() int {
+	return int(atomic.LoadInt64(&maxBodyLength))
+}
+
+// Entrance is the interface of uq's entrance. Many entrances may serve the
+// same message queue, so Stop does not close the message queue.
 type Entrance interface {
 	ListenAndServe() error
 	Stop()
@@ -0,0 +1,81 @@
+package entry
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/buaazp/uq/queue"
+	"github.com/buaazp/uq/store"
+	"github.com/garyburd/redigo/redis"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSharedEntries(t *testing.T) {
+	Convey("Test Entries Sharing A Queue", t, func() {
+		storage, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		messageQueue, err := queue.NewUnitedQueue(storage, "127.0.0.1", 8804, nil, "uq")
+		So(err, ShouldBeNil)
+
+		redisEntrance, err := NewRedisEntry("0.0.0.0", 8804, messageQueue)
+		So(err, ShouldBeNil)
+		httpEntrance, err := NewHTTPEntry("0.0.0.0", 8805, messageQueue)
+		So(err, ShouldBeNil)
+		go func() {
+			redisEntrance.ListenAndServe()
+		}()
+		go func() {
+			httpEntrance.ListenAndServe()
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		rc, err := redis.DialTimeout("tcp", "127.0.0.1:8804", 0, 1*time.Second, 1*time.Second)
+		So(err, ShouldBeNil)
+		_, err = rc.Do("QADD", "shared")
+		So(err, ShouldBeNil)
+		_, err = rc.Do("QADD", "shared/x")
+		So(err, ShouldBeNil)
+		_, err = rc.Do("QPUSH", "shared", "hello")
+		So(err, ShouldBeNil)
+		rc.Close()
+
+		resp, err := client.Get("http://127.0.0.1:8805/v1/queues/shared/x")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		data, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "hello")
+
+		redisEntrance.Stop()
+		httpEntrance.Stop()
+		messageQueue.Close()
+	})
+}
+
+func TestStopUnstartedEntries(t *testing.T) {
+	Convey("Test Stop Entries Which Do Not Listen", t, func() {
+		messageQueue := new(queue.FakeQueue)
+		// the port is in use, so the second entrance fails to listen
+		first, err := NewMcEntry("127.0.0.1", 8806, messageQueue)
+		So(err, ShouldBeNil)
+		second, err := NewRedisEntry("127.0.0.1", 8806, messageQueue)
+		So(err, ShouldBeNil)
+		unstarted, err := NewHTTPEntry("127.0.0.1", 8807, messageQueue)
+		So(err, ShouldBeNil)
+
+		errs := make(chan error, 2)
+		go func() {
+			errs <- first.ListenAndServe()
+		}()
+		time.Sleep(100 * time.Millisecond)
+		So(second.ListenAndServe(), ShouldNotBeNil)
+
+		first.Stop()
+		second.Stop()
+		unstarted.Stop()
+		So(<-errs, ShouldNotBeNil)
+		So(unstarted.ListenAndServe(), ShouldNotBeNil)
+	})
+}
@@ -2,16 +2,23 @@ package entry
 
 import (
 	"log"
-	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/buaazp/uq/metrics"
 	"github.com/buaazp/uq/queue"
 	"github.com/buaazp/uq/utils"
 )
 
 const (
 	queuePrefixV1 = "/v1/queues"
+
+	headerContentType = "X-UQ-Content-Type"
+	headerTimestamp   = "X-UQ-Timestamp"
+	headerGroup       = "X-UQ-Group"
+	headerPrefix      = "X-UQ-Header-"
 )
 
 // HTTPEntry is the HTTP entrance of uq
@@ -19,7 +26,7 @@ type HTTPEntry struct {
 	host         string
 	port         int
 	server       *http.Server
-	stopListener *utils.StopListener
+	stopGuard    utils.StopGuard
 	messageQueue queue.MessageQueue
 }
 
@@ -42,7 +49,7 @@ func NewHTTPEntry(host string, port int, messageQueue queue.MessageQueue) (*HTTP
 
 // ServeHTTP implements the ServeHTTP interface of HTTPEntry
 func (h *HTTPEntry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if !utils.AllowMethod(w, req.Method, "HEAD", "GET", "POST", "PUT", "DELETE") {
+	if !utils.AllowMethod(w, req.Method, "HEAD", "GET", "POST", "PUT", "DELETE", "PATCH") {
 		return
 	}
 
@@ -66,16 +73,58 @@ func (h *HTTPEntry) queueHandler(w http.ResponseWriter, req *http.Request, key s
 		h.popHandler(w, req, key)
 	case "DELETE":
 		h.delHandler(w, req, key)
+	case "PATCH":
+		h.patchHandler(w, req, key)
 	default:
 		http.Error(w, "405 Method Not Allowed!", http.StatusMethodNotAllowed)
 	}
 	return
 }
 
+// readMessageHTTP reads a message with its content type and headers from
+// a push request
+func readMessageHTTP(req *http.Request) *queue.Message {
+	msg := new(queue.Message)
+	msg.Body = []byte(req.FormValue("value"))
+	msg.ContentType = req.Header.Get(headerContentType)
+	msg.Group = req.Header.Get(headerGroup)
+	prefix := http.CanonicalHeaderKey(headerPrefix)
+	for k, vs := range req.Header {
+		if strings.HasPrefix(k, prefix) && len(vs) > 0 {
+			msg.SetHeader(k[len(prefix):], vs[0])
+		}
+	}
+	return msg
+}
+
+// writeMessageHTTP writes a popped message and its metadata to response
+func writeMessageHTTP(w http.ResponseWriter, id string, msg *queue.Message) {
+	contentType := msg.ContentType
+	if contentType == "" {
+		contentType = "text/plain"
+	} else {
+		w.Header().Set(headerContentType, msg.ContentType)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-UQ-ID", id)
+	if msg.Timestamp > 0 {
+		w.Header().Set(headerTimestamp, strconv.FormatInt(msg.Timestamp, 10))
+	}
+	if msg.Group != "" {
+		w.Header().Set(headerGroup, msg.Group)
+	}
+	for _, h := range msg.Headers {
+		w.Header().Set(headerPrefix+h.Key, h.Value)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(msg.Body)
+}
+
 func writeErrorHTTP(w http.ResponseWriter, err error) {
 	if err == nil {
 		return
 	}
+	metrics.Error(err)
 	switch e := err.(type) {
 	case *utils.Error:
 		e.WriteTo(w)
@@ -99,6 +148,20 @@ func (h *HTTPEntry) addHandler(w http.ResponseWriter, req *http.Request, key str
 	lineName := req.FormValue("line")
 	key = topicName + "/" + lineName
 	recycle := req.FormValue("recycle")
+	if attempts := req.FormValue("attempts"); attempts != "" {
+		recycle += ",attempts=" + attempts
+	}
+	if dead := req.FormValue("dead"); dead != "" {
+		recycle += ",dead=" + dead
+	}
+	if filter := req.FormValue("filter"); filter != "" {
+		recycle += ",filter=" + filter
+	}
+	for _, opt := range []string{"age", "count", "bytes", "dedup", "priorities"} {
+		if value := req.FormValue(opt); value != "" {
+			recycle += "," + opt + "=" + value
+		}
+	}
 
 	// log.Printf("creating... %s %s", key, recycle)
 	err = h.messageQueue.Create(key, recycle)
@@ -119,26 +182,50 @@ func (h *HTTPEntry) pushHandler(w http.ResponseWriter, req *http.Request, key st
 		return
 	}
 
-	data := []byte(req.FormValue("value"))
-	err = h.messageQueue.Push(key, data)
+	opts := new(queue.PushOptions)
+	opts.Dedup = req.FormValue("dedup")
+	if delayValue := req.FormValue("delay"); delayValue != "" {
+		opts.Delay, err = utils.ParseTimeout(delayValue)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+	}
+
+	msg := readMessageHTTP(req)
+	id, err := h.messageQueue.PushMessage(key, msg, opts)
 	if err != nil {
 		writeErrorHTTP(w, err)
 		return
 	}
+	w.Header().Set("X-UQ-ID", id)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *HTTPEntry) popHandler(w http.ResponseWriter, req *http.Request, key string) {
-	id, data, err := h.messageQueue.Pop(key)
+	var timeout time.Duration
+	var err error
+	if wait := req.FormValue("wait"); wait != "" {
+		timeout, err = utils.ParseTimeout(wait)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+	}
+
+	id, msg, err := h.messageQueue.PopMessage(key, timeout)
 	if err != nil {
 		writeErrorHTTP(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("X-UQ-ID", id)
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+	writeMessageHTTP(w, id, msg)
 }
 
 func (h *HTTPEntry) delHandler(w http.ResponseWriter, req *http.Request, key string) {
@@ -150,27 +237,52 @@ func (h *HTTPEntry) delHandler(w http.ResponseWriter, req *http.Request, key str
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// ListenAndServe implements the ListenAndServe interface
-func (h *HTTPEntry) ListenAndServe() error {
-	addr := utils.Addrcat(h.host, h.port)
-	l, err := net.Listen("tcp", addr)
+func (h *HTTPEntry) patchHandler(w http.ResponseWriter, req *http.Request, key string) {
+	err := req.ParseForm()
 	if err != nil {
-		return err
+		writeErrorHTTP(w, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		))
+		return
 	}
 
-	stopListener, err := utils.NewStopListener(l)
+	touch := req.FormValue("touch")
+	if touch == "" {
+		err = h.messageQueue.Nack(key)
+	} else {
+		var timeout time.Duration
+		timeout, err = utils.ParseTimeout(touch)
+		if err != nil {
+			writeErrorHTTP(w, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			return
+		}
+		err = h.messageQueue.Touch(key, timeout)
+	}
+	if err != nil {
+		writeErrorHTTP(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListenAndServe implements the ListenAndServe interface
+func (h *HTTPEntry) ListenAndServe() error {
+	addr := utils.Addrcat(h.host, h.port)
+	stopListener, err := h.stopGuard.Listen(addr)
 	if err != nil {
 		return err
 	}
-	h.stopListener = stopListener
 
 	log.Printf("http entrance serving at %s...", addr)
-	return h.server.Serve(h.stopListener)
+	return h.server.Serve(stopListener)
 }
 
 // Stop implements the Stop interface
 func (h *HTTPEntry) Stop() {
 	log.Printf("http entry stoping...")
-	h.stopListener.Stop()
-	h.messageQueue.Close()
+	h.stopGuard.Stop()
 }
@@ -91,6 +91,38 @@ func TestHttpPush(t *testing.T) {
 		resp, err := client.Do(req)
 		So(err, ShouldBeNil)
 		So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+		So(resp.Header.Get("X-UQ-ID"), ShouldEqual, "foo/0")
+	})
+}
+
+func TestHttpPushDedup(t *testing.T) {
+	Convey("Test Http Push Api with Dedup Key", t, func() {
+		bf := bytes.NewBufferString("topic=dd&dedup=1m")
+		req, err := http.NewRequest(
+			"PUT",
+			"http://127.0.0.1:8801/v1/queues",
+			ioutil.NopCloser(bf),
+		)
+		So(err, ShouldBeNil)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+
+		for i := 0; i < 2; i++ {
+			bf = bytes.NewBufferString("value=1&dedup=k1")
+			req, err = http.NewRequest(
+				"POST",
+				"http://127.0.0.1:8801/v1/queues/dd",
+				ioutil.NopCloser(bf),
+			)
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp, err = client.Do(req)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+			So(resp.Header.Get("X-UQ-ID"), ShouldEqual, "dd/0")
+		}
 	})
 }
 
@@ -130,9 +162,110 @@ func TestHttpConfirm(t *testing.T) {
 	})
 }
 
+func TestHttpBlockPop(t *testing.T) {
+	Convey("Test Http Block Pop Api", t, func() {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			messageQueue.Push("foo", []byte("2"))
+		}()
+		req, err := http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8801/v1/queues/foo/x?wait=1s",
+			nil,
+		)
+		So(err, ShouldBeNil)
+
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		id := resp.Header.Get("X-UQ-ID")
+		So(id, ShouldEqual, "foo/x/1")
+		msg := string(body)
+		So(msg, ShouldEqual, "2")
+	})
+}
+
+func TestHttpNackTouch(t *testing.T) {
+	Convey("Test Http Nack and Touch Api", t, func() {
+		bf := bytes.NewBufferString("touch=30s")
+		body := ioutil.NopCloser(bf)
+		req, err := http.NewRequest(
+			"PATCH",
+			"http://127.0.0.1:8801/v1/queues/foo/x/1",
+			body,
+		)
+		So(err, ShouldBeNil)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+
+		req, err = http.NewRequest(
+			"PATCH",
+			"http://127.0.0.1:8801/v1/queues/foo/x/1",
+			nil,
+		)
+		So(err, ShouldBeNil)
+		resp, err = client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+
+		req, err = http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8801/v1/queues/foo/x",
+			nil,
+		)
+		So(err, ShouldBeNil)
+		resp, err = client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		So(resp.Header.Get("X-UQ-ID"), ShouldEqual, "foo/x/1")
+	})
+}
+
+func TestHttpMessageMeta(t *testing.T) {
+	Convey("Test Http Push and Pop Message with Metadata", t, func() {
+		bf := bytes.NewBufferString("value=a,b")
+		body := ioutil.NopCloser(bf)
+		req, err := http.NewRequest(
+			"POST",
+			"http://127.0.0.1:8801/v1/queues/foo",
+			body,
+		)
+		So(err, ShouldBeNil)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-UQ-Content-Type", "text/csv")
+		req.Header.Set("X-UQ-Header-Producer", "tester")
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+
+		req, err = http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8801/v1/queues/foo/x",
+			nil,
+		)
+		So(err, ShouldBeNil)
+		resp, err = client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		So(resp.Header.Get("Content-Type"), ShouldEqual, "text/csv")
+		So(resp.Header.Get("X-UQ-Header-Producer"), ShouldEqual, "tester")
+		So(resp.Header.Get("X-UQ-Timestamp"), ShouldNotEqual, "")
+
+		data, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "a,b")
+	})
+}
+
 func TestCloseHTTPEntry(t *testing.T) {
 	Convey("Test Close Http Entry", t, func() {
 		entrance.Stop()
+		messageQueue.Close()
 		messageQueue = nil
 		storage = nil
 	})
@@ -7,7 +7,9 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/buaazp/uq/metrics"
 	"github.com/buaazp/uq/queue"
 	"github.com/buaazp/uq/utils"
 )
@@ -16,7 +18,7 @@ import (
 type McEntry struct {
 	host         string
 	port         int
-	stopListener *utils.StopListener
+	stopGuard    utils.StopGuard
 	messageQueue queue.MessageQueue
 }
 
@@ -68,7 +70,10 @@ func (m *McEntry) read(b *bufio.Reader) (*request, error) {
 	req := new(request)
 	req.cmd = parts[0]
 	switch req.cmd {
-	case "get", "gets", "stats":
+	case "stats":
+		req.keys = parts[1:]
+
+	case "get", "gets":
 		if len(parts) < 2 {
 			return nil, utils.NewError(
 				utils.ErrBadRequest,
@@ -107,7 +112,7 @@ func (m *McEntry) read(b *bufio.Reader) (*request, error) {
 				`length atoi failed: `+err.Error(),
 			)
 		}
-		if length > MaxBodyLength {
+		if length > bodyLengthLimit() {
 			return nil, utils.NewError(
 				utils.ErrBadRequest,
 				`bad data length`,
@@ -154,6 +159,26 @@ func (m *McEntry) read(b *bufio.Reader) (*request, error) {
 		req.keys = parts[1:2]
 		req.noReply = len(parts) > 2 && parts[len(parts)-1] == "noreply"
 
+	case "nack":
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				`cmd parts error: < 2 or > 3`,
+			)
+		}
+		req.keys = parts[1:2]
+		req.noReply = len(parts) > 2 && parts[2] == "noreply"
+
+	case "touch":
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				`cmd parts error: < 3 or > 4`,
+			)
+		}
+		req.keys = parts[1:3]
+		req.noReply = len(parts) > 3 && parts[3] == "noreply"
+
 	case "quit", "version", "flush_all":
 	case "replace", "cas", "append", "prepend":
 	case "incr", "decr":
@@ -168,10 +193,26 @@ func (m *McEntry) read(b *bufio.Reader) (*request, error) {
 	return req, nil
 }
 
+// splitWaitKey splits the timeout from a get key like foo/x:5s, which
+// waits 5 seconds at most for a message. Only a suffix with a unit of time
+// is a timeout, so the other keys with ":" are popped as they are.
+func splitWaitKey(key string) (string, time.Duration, bool) {
+	i := strings.LastIndex(key, ":")
+	if i < 0 {
+		return key, 0, false
+	}
+	timeout, err := time.ParseDuration(key[i+1:])
+	if err != nil || timeout < 0 {
+		return key, 0, false
+	}
+	return key[:i], timeout, true
+}
+
 func writeErrorMc(resp *response, err error) {
 	if err == nil {
 		return
 	}
+	metrics.Error(err)
 	switch e := err.(type) {
 	case *utils.Error:
 		if e.ErrorCode >= 500 {
@@ -196,7 +237,7 @@ func (m *McEntry) process(req *request) (resp *response, quit bool) {
 	switch req.cmd {
 	case "get", "gets":
 		for _, k := range req.keys {
-			if len(k) > MaxKeyLength {
+			if len(k) > keyLengthLimit() {
 				writeErrorMc(resp, utils.NewError(
 					utils.ErrBadKey,
 					`key is too long`,
@@ -207,7 +248,13 @@ func (m *McEntry) process(req *request) (resp *response, quit bool) {
 
 		key := req.keys[0]
 		resp.status = "VALUE"
-		id, data, err := m.messageQueue.Pop(key)
+		var id string
+		var data []byte
+		if name, timeout, ok := splitWaitKey(key); ok {
+			id, data, err = m.messageQueue.PopWait(name, timeout)
+		} else {
+			id, data, err = m.messageQueue.Pop(key)
+		}
 		if err != nil {
 			writeErrorMc(resp, err)
 			return
@@ -228,8 +275,22 @@ func (m *McEntry) process(req *request) (resp *response, quit bool) {
 		resp.items = items
 
 	case "stats":
-		key := req.keys[0]
 		resp.status = "STAT"
+		if len(req.keys) == 0 {
+			qss, err := m.messageQueue.List()
+			if err != nil {
+				writeErrorMc(resp, err)
+				return
+			}
+			strs := make([]string, len(qss))
+			for i, qs := range qss {
+				strs[i] = qs.ToMcString()
+			}
+			resp.msg = strings.Join(strs, "\r\n\r\n")
+			return
+		}
+
+		key := req.keys[0]
 		qs, err := m.messageQueue.Stat(key)
 		if err != nil {
 			writeErrorMc(resp, err)
@@ -263,13 +324,22 @@ func (m *McEntry) process(req *request) (resp *response, quit bool) {
 		resp.status = "STORED"
 
 	case "set":
+		// set foo:id replies the id of message after STORED, which is
+		// not understood by the common memcached clients, a plain set
+		// replies STORED only
 		key := req.keys[0]
-		err = m.messageQueue.Push(key, req.item.body)
+		withID := strings.HasSuffix(key, ":id")
+		key = strings.TrimSuffix(key, ":id")
+		var id string
+		id, err = m.messageQueue.Push(key, req.item.body)
 		if err != nil {
 			writeErrorMc(resp, err)
 			return
 		}
 		resp.status = "STORED"
+		if withID {
+			resp.msg = id
+		}
 
 	case "delete":
 		key := req.keys[0]
@@ -281,6 +351,35 @@ func (m *McEntry) process(req *request) (resp *response, quit bool) {
 		}
 		resp.status = "DELETED"
 
+	case "nack":
+		key := req.keys[0]
+
+		err = m.messageQueue.Nack(key)
+		if err != nil {
+			writeErrorMc(resp, err)
+			break
+		}
+		resp.status = "NACKED"
+
+	case "touch":
+		key := req.keys[0]
+		var timeout time.Duration
+		timeout, err = utils.ParseTimeout(req.keys[1])
+		if err != nil {
+			writeErrorMc(resp, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+			break
+		}
+
+		err = m.messageQueue.Touch(key, timeout)
+		if err != nil {
+			writeErrorMc(resp, err)
+			break
+		}
+		resp.status = "TOUCHED"
+
 	case "quit":
 		resp = nil
 		quit = true
@@ -342,20 +441,14 @@ func (m *McEntry) handlerConn(conn net.Conn) {
 // ListenAndServe implements the ListenAndServe interface
 func (m *McEntry) ListenAndServe() error {
 	addr := utils.Addrcat(m.host, m.port)
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
-	}
-
-	stopListener, err := utils.NewStopListener(l)
+	stopListener, err := m.stopGuard.Listen(addr)
 	if err != nil {
 		return err
 	}
-	m.stopListener = stopListener
 
 	log.Printf("mc entrance serving at %s...", addr)
 	for {
-		conn, e := m.stopListener.Accept()
+		conn, e := stopListener.Accept()
 		if e != nil {
 			// log.Printf("Accept failed: %s\n", e)
 			return e
@@ -368,7 +461,6 @@ func (m *McEntry) ListenAndServe() error {
 // Stop implements the Stop interface
 func (m *McEntry) Stop() {
 	log.Printf("mc entry stoping...")
-	m.stopListener.Stop()
-	m.messageQueue.Close()
+	m.stopGuard.Stop()
 	log.Printf("mc entry stoped.")
 }
@@ -1,6 +1,8 @@
 package entry
 
 import (
+	"bufio"
+	"net"
 	"testing"
 	"time"
 
@@ -79,9 +81,87 @@ func TestMcConfirm(t *testing.T) {
 	})
 }
 
+func TestMcBlockPop(t *testing.T) {
+	Convey("Test Mc Block Pop Api", t, func() {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			messageQueue.Push("foo", []byte("2"))
+		}()
+		it, err := mc.Get("foo/x:1s")
+		So(err, ShouldBeNil)
+		v := string(it.Value)
+		So(v, ShouldEqual, "2")
+	})
+}
+
+func TestSplitWaitKey(t *testing.T) {
+	Convey("Test Split Wait Key of Mc Get", t, func() {
+		for _, c := range []struct {
+			key, name string
+			timeout   time.Duration
+			wait      bool
+		}{
+			{"foo/x", "foo/x", 0, false},
+			{"foo/x:5s", "foo/x", 5 * time.Second, true},
+			{"foo/x:0", "foo/x", 0, true},
+			{"a:b/x:100ms", "a:b/x", 100 * time.Millisecond, true},
+			{"foo/x:1", "foo/x:1", 0, false},
+			{"a:b/x", "a:b/x", 0, false},
+			{"foo/x:-1s", "foo/x:-1s", 0, false},
+		} {
+			name, timeout, wait := splitWaitKey(c.key)
+			So(name, ShouldEqual, c.name)
+			So(timeout, ShouldEqual, c.timeout)
+			So(wait, ShouldEqual, c.wait)
+		}
+	})
+}
+
+func TestMcNackTouch(t *testing.T) {
+	Convey("Test Mc Nack and Touch Api", t, func() {
+		err := mc.Touch("foo/x/1", 30)
+		So(err, ShouldBeNil)
+
+		conn, err := net.Dial("tcp", "localhost:8802")
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		_, err = conn.Write([]byte("nack foo/x/1\r\n"))
+		So(err, ShouldBeNil)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		So(err, ShouldBeNil)
+		So(line, ShouldEqual, "NACKED\r\n")
+
+		it, err := mc.Get("foo/x")
+		So(err, ShouldBeNil)
+		v := string(it.Value)
+		So(v, ShouldEqual, "2")
+	})
+}
+
+func TestMcPushReply(t *testing.T) {
+	Convey("Test Mc Push Reply With And Without ID", t, func() {
+		conn, err := net.Dial("tcp", "localhost:8802")
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		_, err = conn.Write([]byte("set foo 0 0 1\r\n3\r\n"))
+		So(err, ShouldBeNil)
+		reader := bufio.NewReader(conn)
+		line, err := reader.ReadString('\n')
+		So(err, ShouldBeNil)
+		So(line, ShouldEqual, "STORED\r\n")
+
+		_, err = conn.Write([]byte("set foo:id 0 0 1\r\n4\r\n"))
+		So(err, ShouldBeNil)
+		line, err = reader.ReadString('\n')
+		So(err, ShouldBeNil)
+		So(line, ShouldEqual, "STORED foo/3\r\n")
+	})
+}
+
 func TestCloseMcEntry(t *testing.T) {
 	Convey("Test Close Mc Entry", t, func() {
 		entrance.Stop()
+		messageQueue.Close()
 		messageQueue = nil
 		storage = nil
 	})
@@ -2,9 +2,9 @@ package entry
 
 import (
 	"log"
-	"net"
 	"time"
 
+	"github.com/buaazp/uq/metrics"
 	"github.com/buaazp/uq/queue"
 	"github.com/buaazp/uq/utils"
 )
@@ -24,7 +24,7 @@ const (
 type RedisEntry struct {
 	host         string
 	port         int
-	stopListener *utils.StopListener
+	stopGuard    utils.StopGuard
 	messageQueue queue.MessageQueue
 }
 
@@ -51,20 +51,38 @@ func (r *RedisEntry) commandHandler(ss *session, cmd *command) (rep *reply) {
 		rep = r.onQadd(cmd)
 	} else if cmdName == "SET" || cmdName == "QPUSH" {
 		rep = r.onQpush(cmd)
+	} else if cmdName == "QPUSHDELAY" {
+		rep = r.onQpushDelay(cmd)
+	} else if cmdName == "QPUSHMETA" {
+		rep = r.onQpushMeta(cmd)
+	} else if cmdName == "QPUSHDEDUP" {
+		rep = r.onQpushDedup(cmd)
+	} else if cmdName == "QPUSHGROUP" {
+		rep = r.onQpushGroup(cmd)
 	} else if cmdName == "MSET" || cmdName == "QMPUSH" {
 		rep = r.onQmpush(cmd)
 	} else if cmdName == "GET" || cmdName == "QPOP" {
 		rep = r.onQpop(cmd)
+	} else if cmdName == "BQPOP" {
+		rep = r.onQbpop(cmd)
 	} else if cmdName == "MGET" || cmdName == "QMPOP" {
 		rep = r.onQmpop(cmd)
+	} else if cmdName == "QPEEK" {
+		rep = r.onQpeek(cmd)
 	} else if cmdName == "DEL" || cmdName == "QDEL" {
 		rep = r.onQdel(cmd)
 	} else if cmdName == "MDEL" || cmdName == "QMDEL" {
 		rep = r.onQmdel(cmd)
+	} else if cmdName == "QNACK" {
+		rep = r.onQnack(cmd)
+	} else if cmdName == "QTOUCH" {
+		rep = r.onQtouch(cmd)
 	} else if cmdName == "EMPTY" || cmdName == "QEMPTY" {
 		rep = r.onQempty(cmd)
 	} else if cmdName == "INFO" || cmdName == "QINFO" {
 		rep = r.onInfo(cmd)
+	} else if cmdName == "QLIST" {
+		rep = r.onQlist(cmd)
 	} else {
 		rep = r.onUndefined(ss, cmd)
 	}
@@ -91,10 +109,21 @@ func (r *RedisEntry) process(ss *session, cmd *command) (rep *reply) {
 
 	elapsed := time.Now().Sub(begin)
 	cmd.setAttribute(cElapsed, elapsed)
+	metrics.ObserveCommand(commandLabel(cmd), elapsed)
 
 	return
 }
 
+// commandLabel returns the name of command to be observed, the names of
+// unknown commands are not kept so that the metrics are bounded
+func commandLabel(cmd *command) string {
+	name := cmd.name()
+	if _, ok := cmdrules[name]; !ok {
+		return "unknown"
+	}
+	return name
+}
+
 func (r *RedisEntry) handlerConn(ss *session) {
 	// addr := ss.RemoteAddr().String()
 	// log.Printf("handleClient: %s", addr)
@@ -129,20 +158,14 @@ func (r *RedisEntry) handlerConn(ss *session) {
 // ListenAndServe implements the ListenAndServe interface
 func (r *RedisEntry) ListenAndServe() error {
 	addr := utils.Addrcat(r.host, r.port)
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
-	}
-
-	stopListener, err := utils.NewStopListener(l)
+	stopListener, err := r.stopGuard.Listen(addr)
 	if err != nil {
 		return err
 	}
-	r.stopListener = stopListener
 
 	log.Printf("redis entrance serving at %s...", addr)
 	for {
-		conn, err := r.stopListener.Accept()
+		conn, err := stopListener.Accept()
 		if err != nil {
 			// log.Printf("Accept failed: %s\n", err)
 			return err
@@ -154,6 +177,5 @@ func (r *RedisEntry) ListenAndServe() error {
 // Stop implements the Stop interface
 func (r *RedisEntry) Stop() {
 	log.Printf("redis entry stoping...")
-	r.stopListener.Stop()
-	r.messageQueue.Close()
+	r.stopGuard.Stop()
 }
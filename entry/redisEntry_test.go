@@ -1,6 +1,7 @@
 package entry
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -48,8 +49,19 @@ func TestRedisAdd(t *testing.T) {
 
 func TestRedisPush(t *testing.T) {
 	Convey("Test Redis Push Api", t, func() {
-		_, err := conn.Do("QPUSH", "foo", "1")
+		id, err := redis.String(conn.Do("QPUSH", "foo", "1"))
 		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "foo/0")
+	})
+}
+
+func TestRedisMultiPush(t *testing.T) {
+	Convey("Test Redis Multi Push Api", t, func() {
+		_, err := conn.Do("QADD", "ids")
+		So(err, ShouldBeNil)
+		ids, err := redis.Strings(conn.Do("QMPUSH", "ids", "a", "b"))
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []string{"ids/0", "ids/1"})
 	})
 }
 
@@ -66,6 +78,31 @@ func TestRedisPop(t *testing.T) {
 	})
 }
 
+func TestRedisPeek(t *testing.T) {
+	Convey("Test Redis Peek Api", t, func() {
+		rpl, err := redis.Values(conn.Do("QPEEK", "foo", "0", "1"))
+		So(err, ShouldBeNil)
+		So(len(rpl), ShouldEqual, 2)
+		v, err := redis.String(rpl[0], err)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "1")
+		id, err := redis.String(rpl[1], err)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "foo/0")
+
+		_, err = conn.Do("QPEEK", "foo", "0", "1025")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRedisList(t *testing.T) {
+	Convey("Test Redis List Api", t, func() {
+		rpl, err := redis.Strings(conn.Do("QLIST"))
+		So(err, ShouldBeNil)
+		So(rpl[0], ShouldEqual, "name:foo")
+	})
+}
+
 func TestRedisConfirm(t *testing.T) {
 	Convey("Test Redis Confirm Api", t, func() {
 		_, err := conn.Do("QDEL", "foo/x/0")
@@ -73,9 +110,143 @@ func TestRedisConfirm(t *testing.T) {
 	})
 }
 
+func TestRedisBlockPop(t *testing.T) {
+	Convey("Test Redis Block Pop Api", t, func() {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			messageQueue.Push("foo", []byte("2"))
+		}()
+		rpl, err := redis.Values(conn.Do("BQPOP", "foo/x", "1s"))
+		So(err, ShouldBeNil)
+		v, err := redis.String(rpl[0], err)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "2")
+		id, err := redis.String(rpl[1], err)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "foo/x/1")
+	})
+}
+
+func TestRedisPushDelay(t *testing.T) {
+	Convey("Test Redis Push Delay Api", t, func() {
+		id, err := redis.String(conn.Do("QPUSHDELAY", "foo", "10ms", "3"))
+		So(err, ShouldBeNil)
+		So(id, ShouldStartWith, "foo/")
+
+		_, err = conn.Do("QPOP", "foo/x")
+		So(err, ShouldNotBeNil)
+
+		time.Sleep(20 * time.Millisecond)
+		rpl, err := redis.Values(conn.Do("QPOP", "foo/x"))
+		So(err, ShouldBeNil)
+		v, err := redis.String(rpl[0], err)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "3")
+	})
+}
+
+func TestRedisNackTouch(t *testing.T) {
+	Convey("Test Redis Nack and Touch Api", t, func() {
+		_, err := conn.Do("QTOUCH", "foo/x/1", "30s")
+		So(err, ShouldBeNil)
+
+		_, err = conn.Do("QNACK", "foo/x/1")
+		So(err, ShouldBeNil)
+
+		rpl, err := redis.Values(conn.Do("QPOP", "foo/x"))
+		So(err, ShouldBeNil)
+		v, err := redis.String(rpl[0], err)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "2")
+		id, err := redis.String(rpl[1], err)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "foo/x/1")
+	})
+}
+
+func TestRedisPushMeta(t *testing.T) {
+	Convey("Test Redis Push and Pop Message with Metadata", t, func() {
+		id, err := redis.String(conn.Do("QPUSHMETA", "foo", "text/csv", "a,b", "producer", "tester"))
+		So(err, ShouldBeNil)
+		So(id, ShouldStartWith, "foo/")
+
+		rpl, err := redis.Values(conn.Do("QPOP", "foo/x", "META"))
+		So(err, ShouldBeNil)
+		So(len(rpl), ShouldEqual, 6)
+		v, err := redis.String(rpl[0], err)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "a,b")
+		ct, err := redis.String(rpl[3], err)
+		So(err, ShouldBeNil)
+		So(ct, ShouldEqual, "text/csv")
+		hv, err := redis.String(rpl[5], err)
+		So(err, ShouldBeNil)
+		So(hv, ShouldEqual, "tester")
+	})
+}
+
+func TestRedisPushDedup(t *testing.T) {
+	Convey("Test Redis Push with Dedup Key", t, func() {
+		_, err := conn.Do("QADD", "dd", "dedup=1m")
+		So(err, ShouldBeNil)
+		id, err := redis.String(conn.Do("QPUSHDEDUP", "dd", "k1", "a"))
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/0")
+		id, err = redis.String(conn.Do("QPUSHDEDUP", "dd", "k1", "a"))
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/0")
+		id, err = redis.String(conn.Do("QPUSHDEDUP", "dd", "k2", "b"))
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/1")
+
+		// a topic without dedup window refuses dedup keys
+		_, err = conn.Do("QPUSHDEDUP", "foo", "k1", "a")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRedisPushGroup(t *testing.T) {
+	Convey("Test Redis Push with Group", t, func() {
+		_, err := conn.Do("QADD", "gp")
+		So(err, ShouldBeNil)
+		_, err = conn.Do("QADD", "gp/x", "10s")
+		So(err, ShouldBeNil)
+		for i, v := range []string{"a", "b"} {
+			id, err := redis.String(conn.Do("QPUSHGROUP", "gp", "g1", v))
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "gp/"+strconv.Itoa(i))
+		}
+
+		// the second message waits for the first one
+		rpl, err := redis.Values(conn.Do("QPOP", "gp/x"))
+		So(err, ShouldBeNil)
+		id, err := redis.String(rpl[1], err)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "gp/x/0")
+		_, err = conn.Do("QPOP", "gp/x")
+		So(err, ShouldNotBeNil)
+		_, err = conn.Do("QDEL", "gp/x/0")
+		So(err, ShouldBeNil)
+		rpl, err = redis.Values(conn.Do("QPOP", "gp/x"))
+		So(err, ShouldBeNil)
+		v, err := redis.String(rpl[0], err)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "b")
+	})
+}
+
+func TestRedisCommandLabel(t *testing.T) {
+	Convey("Test Redis Command Label Of Metrics", t, func() {
+		So(commandLabel(newCommand([]byte("qpush"), []byte("foo"))), ShouldEqual, "QPUSH")
+		So(commandLabel(newCommand([]byte("nosuch1"))), ShouldEqual, "unknown")
+		So(commandLabel(newCommand([]byte("nosuch2"))), ShouldEqual, "unknown")
+	})
+}
+
 func TestCloseRedisEntry(t *testing.T) {
 	Convey("Test Close Redis Entry", t, func() {
 		entrance.Stop()
+		messageQueue.Close()
 		messageQueue = nil
 		storage = nil
 	})
@@ -1,9 +1,43 @@
 package entry
 
 import (
+	"strconv"
+	"strings"
+
+	"github.com/buaazp/uq/queue"
 	"github.com/buaazp/uq/utils"
 )
 
+// messageBulks returns the multi bulks of a popped message, the metadata of
+// message is appended if meta is required
+func messageBulks(id string, msg *queue.Message, meta bool) []interface{} {
+	vals := make([]interface{}, 2, 4+len(msg.Headers)*2)
+	vals[0] = msg.Body
+	vals[1] = id
+	if meta {
+		vals = append(vals, strconv.FormatInt(msg.Timestamp, 10))
+		vals = append(vals, msg.ContentType)
+		for _, h := range msg.Headers {
+			vals = append(vals, h.Key, h.Value)
+		}
+	}
+	return vals
+}
+
+// metaAtIndex returns whether the optional META argument is given
+func metaAtIndex(cmd *command, index int) (bool, error) {
+	if cmd.length() <= index {
+		return false, nil
+	}
+	if strings.ToUpper(cmd.stringAtIndex(index)) != "META" {
+		return false, utils.NewError(
+			utils.ErrBadRequest,
+			`unknown argument: `+cmd.stringAtIndex(index),
+		)
+	}
+	return true, nil
+}
+
 func (r *RedisEntry) onQadd(cmd *command) *reply {
 	key := cmd.stringAtIndex(1)
 	recycle := cmd.stringAtIndex(2)
@@ -26,38 +60,164 @@ func (r *RedisEntry) onQpush(cmd *command) *reply {
 		))
 	}
 
-	err = r.messageQueue.Push(key, val)
+	id, err := r.messageQueue.Push(key, val)
 	if err != nil {
 		return errorReply(err)
 	}
-	return statusReply("OK")
+	return bulkReply(id)
+}
+
+func (r *RedisEntry) onQpushDelay(cmd *command) *reply {
+	key := cmd.stringAtIndex(1)
+	delay, err := utils.ParseTimeout(cmd.stringAtIndex(2))
+	if err != nil {
+		return errorReply(utils.NewError(
+			utils.ErrBadRequest,
+			err.Error(),
+		))
+	}
+	val, err := cmd.argAtIndex(3)
+	if err != nil {
+		return errorReply(utils.NewError(
+			utils.ErrBadRequest,
+			err.Error(),
+		))
+	}
+
+	msg := new(queue.Message)
+	msg.Body = val
+	opts := new(queue.PushOptions)
+	opts.Delay = delay
+	id, err := r.messageQueue.PushMessage(key, msg, opts)
+	if err != nil {
+		return errorReply(err)
+	}
+	return bulkReply(id)
+}
+
+func (r *RedisEntry) onQpushMeta(cmd *command) *reply {
+	if cmd.length()%2 != 0 {
+		return errorReply(utils.NewError(
+			utils.ErrBadRequest,
+			`message headers should be pairs`,
+		))
+	}
+
+	key := cmd.stringAtIndex(1)
+	msg := new(queue.Message)
+	msg.ContentType = cmd.stringAtIndex(2)
+	val, err := cmd.argAtIndex(3)
+	if err != nil {
+		return errorReply(utils.NewError(
+			utils.ErrBadRequest,
+			err.Error(),
+		))
+	}
+	msg.Body = val
+	for i := 4; i < cmd.length(); i += 2 {
+		msg.SetHeader(cmd.stringAtIndex(i), cmd.stringAtIndex(i+1))
+	}
+
+	id, err := r.messageQueue.PushMessage(key, msg, nil)
+	if err != nil {
+		return errorReply(err)
+	}
+	return bulkReply(id)
+}
+
+func (r *RedisEntry) onQpushDedup(cmd *command) *reply {
+	key := cmd.stringAtIndex(1)
+	dedup := cmd.stringAtIndex(2)
+	val, err := cmd.argAtIndex(3)
+	if err != nil {
+		return errorReply(utils.NewError(
+			utils.ErrBadRequest,
+			err.Error(),
+		))
+	}
+	msg := new(queue.Message)
+	msg.Body = val
+
+	opts := new(queue.PushOptions)
+	opts.Dedup = dedup
+	id, err := r.messageQueue.PushMessage(key, msg, opts)
+	if err != nil {
+		return errorReply(err)
+	}
+	return bulkReply(id)
+}
+
+func (r *RedisEntry) onQpushGroup(cmd *command) *reply {
+	key := cmd.stringAtIndex(1)
+	val, err := cmd.argAtIndex(3)
+	if err != nil {
+		return errorReply(utils.NewError(
+			utils.ErrBadRequest,
+			err.Error(),
+		))
+	}
+	msg := new(queue.Message)
+	msg.Group = cmd.stringAtIndex(2)
+	msg.Body = val
+
+	id, err := r.messageQueue.PushMessage(key, msg, nil)
+	if err != nil {
+		return errorReply(err)
+	}
+	return bulkReply(id)
 }
 
 func (r *RedisEntry) onQmpush(cmd *command) *reply {
 	key := cmd.stringAtIndex(1)
 	vals := cmd.args[2:]
 
-	err := r.messageQueue.MultiPush(key, vals)
+	ids, err := r.messageQueue.MultiPush(key, vals)
 	if err != nil {
 		return errorReply(err)
 	}
 
-	return statusReply("OK")
+	vs := make([]interface{}, len(ids))
+	for i, id := range ids {
+		vs[i] = id
+	}
+	return multiBulksReply(vs)
 }
 
 func (r *RedisEntry) onQpop(cmd *command) *reply {
 	key := cmd.stringAtIndex(1)
+	meta, err := metaAtIndex(cmd, 2)
+	if err != nil {
+		return errorReply(err)
+	}
 
-	id, value, err := r.messageQueue.Pop(key)
+	id, msg, err := r.messageQueue.PopMessage(key, 0)
 	if err != nil {
 		return errorReply(err)
 	}
 
-	vals := make([]interface{}, 2)
-	vals[0] = value
-	vals[1] = id
+	return multiBulksReply(messageBulks(id, msg, meta))
+}
 
-	return multiBulksReply(vals)
+func (r *RedisEntry) onQbpop(cmd *command) *reply {
+	key := cmd.stringAtIndex(1)
+	timeout, err := utils.ParseTimeout(cmd.stringAtIndex(2))
+	if err != nil {
+		return errorReply(utils.NewError(
+			utils.ErrBadRequest,
+			err.Error(),
+		))
+	}
+	meta, err := metaAtIndex(cmd, 3)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	id, msg, err := r.messageQueue.PopMessage(key, timeout)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return multiBulksReply(messageBulks(id, msg, meta))
 }
 
 func (r *RedisEntry) onQmpop(cmd *command) *reply {
@@ -89,6 +249,49 @@ func (r *RedisEntry) onQmpop(cmd *command) *reply {
 	return multiBulksReply(vals)
 }
 
+func (r *RedisEntry) onQpeek(cmd *command) *reply {
+	key := cmd.stringAtIndex(1)
+	var offset uint64
+	var err error
+	if cmd.length() > 2 {
+		offset, err = cmd.uint64AtIndex(2)
+		if err != nil {
+			return errorReply(utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+		}
+	}
+	n := 1
+	if cmd.length() > 3 {
+		n, err = cmd.intAtIndex(3)
+		if err != nil {
+			return errorReply(utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			))
+		}
+	}
+
+	ids, values, err := r.messageQueue.Peek(key, offset, n)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	np := len(ids)
+
+	vals := make([]interface{}, np*2)
+	for i, index := 0, 0; i < np; i++ {
+		vals[index] = values[i]
+		index++
+
+		vals[index] = ids[i]
+		index++
+	}
+
+	return multiBulksReply(vals)
+}
+
 func (r *RedisEntry) onQdel(cmd *command) *reply {
 	key := cmd.stringAtIndex(1)
 
@@ -119,6 +322,35 @@ func (r *RedisEntry) onQmdel(cmd *command) *reply {
 	return multiBulksReply(vals)
 }
 
+func (r *RedisEntry) onQnack(cmd *command) *reply {
+	key := cmd.stringAtIndex(1)
+
+	err := r.messageQueue.Nack(key)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return statusReply("OK")
+}
+
+func (r *RedisEntry) onQtouch(cmd *command) *reply {
+	key := cmd.stringAtIndex(1)
+	timeout, err := utils.ParseTimeout(cmd.stringAtIndex(2))
+	if err != nil {
+		return errorReply(utils.NewError(
+			utils.ErrBadRequest,
+			err.Error(),
+		))
+	}
+
+	err = r.messageQueue.Touch(key, timeout)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return statusReply("OK")
+}
+
 func (r *RedisEntry) onQempty(cmd *command) *reply {
 	key := cmd.stringAtIndex(1)
 
@@ -129,6 +361,21 @@ func (r *RedisEntry) onQempty(cmd *command) *reply {
 	return statusReply("OK")
 }
 
+func (r *RedisEntry) onQlist(cmd *command) *reply {
+	qss, err := r.messageQueue.List()
+	if err != nil {
+		return errorReply(err)
+	}
+
+	var vals []interface{}
+	for _, qs := range qss {
+		for _, str := range qs.ToRedisStrings() {
+			vals = append(vals, str)
+		}
+	}
+	return multiBulksReply(vals)
+}
+
 func (r *RedisEntry) onInfo(cmd *command) *reply {
 	key := cmd.stringAtIndex(1)
 
@@ -3,6 +3,8 @@ package entry
 import (
 	"bytes"
 	"fmt"
+
+	"github.com/buaazp/uq/metrics"
 )
 
 type reply struct {
@@ -39,6 +41,7 @@ func errorReply(err error) (r *reply) {
 	r = &reply{}
 	r.rType = replyTypeError
 	if err != nil {
+		metrics.Error(err)
 		r.value = err.Error()
 	}
 	return
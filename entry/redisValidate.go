@@ -18,24 +18,33 @@ const (
 
 var cmdrules = map[string][]interface{}{
 	// queue
-	"ADD":    []interface{}{2, 3},
-	"QADD":   []interface{}{2, 3},
-	"SET":    []interface{}{3, 3},
-	"QPUSH":  []interface{}{3, 3},
-	"MSET":   []interface{}{3, -1},
-	"QMPUSH": []interface{}{3, -1},
-	"GET":    []interface{}{2, 2},
-	"QPOP":   []interface{}{2, 2},
-	"MGET":   []interface{}{3, -1},
-	"QMPOP":  []interface{}{3, -1},
-	"DEL":    []interface{}{2, 2},
-	"QDEL":   []interface{}{2, 2},
-	"MDEL":   []interface{}{2, -1},
-	"QMDEL":  []interface{}{2, -1},
-	"EMPTY":  []interface{}{2, 2},
-	"QEMPTY": []interface{}{2, 2},
-	"INFO":   []interface{}{2, 2},
-	"QINFO":  []interface{}{2, 2},
+	"ADD":        []interface{}{2, 3},
+	"QADD":       []interface{}{2, 3},
+	"SET":        []interface{}{3, 3},
+	"QPUSH":      []interface{}{3, 3},
+	"QPUSHDELAY": []interface{}{4, 4},
+	"QPUSHMETA":  []interface{}{4, -1},
+	"QPUSHDEDUP": []interface{}{4, 4},
+	"QPUSHGROUP": []interface{}{4, 4},
+	"MSET":       []interface{}{3, -1},
+	"QMPUSH":     []interface{}{3, -1},
+	"GET":        []interface{}{2, 3},
+	"QPOP":       []interface{}{2, 3},
+	"BQPOP":      []interface{}{3, 4},
+	"MGET":       []interface{}{3, -1},
+	"QMPOP":      []interface{}{3, -1},
+	"QPEEK":      []interface{}{2, 4},
+	"DEL":        []interface{}{2, 2},
+	"QDEL":       []interface{}{2, 2},
+	"MDEL":       []interface{}{2, -1},
+	"QMDEL":      []interface{}{2, -1},
+	"QNACK":      []interface{}{2, 2},
+	"QTOUCH":     []interface{}{3, 3},
+	"EMPTY":      []interface{}{2, 2},
+	"QEMPTY":     []interface{}{2, 2},
+	"INFO":       []interface{}{2, 2},
+	"QINFO":      []interface{}{2, 2},
+	"QLIST":      []interface{}{1, 1},
 }
 
 func verifyCommand(cmd *command) error {
@@ -0,0 +1,210 @@
+// Package metrics collects the runtime metrics of uq and writes them in the
+// prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buaazp/uq/utils"
+)
+
+// Counter is a metric which only goes up
+type Counter struct {
+	value uint64
+}
+
+// Inc increases the counter by 1
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increases the counter by n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the value of counter
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// the counters of queue operations
+var (
+	Pushes   = new(Counter)
+	Pops     = new(Counter)
+	Confirms = new(Counter)
+	Nacks    = new(Counter)
+	Touches  = new(Counter)
+	Recycles = new(Counter)
+)
+
+var (
+	errorCounts = make(map[int]*Counter)
+	errorsLock  sync.RWMutex
+)
+
+// Error counts an error returned to clients by its code
+func Error(err error) {
+	code := utils.ErrInternalError
+	if e, ok := err.(*utils.Error); ok {
+		code = e.ErrorCode
+	}
+
+	errorsLock.RLock()
+	c, ok := errorCounts[code]
+	errorsLock.RUnlock()
+	if !ok {
+		errorsLock.Lock()
+		c, ok = errorCounts[code]
+		if !ok {
+			c = new(Counter)
+			errorCounts[code] = c
+		}
+		errorsLock.Unlock()
+	}
+	c.Inc()
+}
+
+// latencyBuckets are the upper bounds in seconds of latency histograms
+var latencyBuckets = []float64{
+	0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5,
+}
+
+type histogram struct {
+	sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	h.Lock()
+	defer h.Unlock()
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+var (
+	commands     = make(map[string]*histogram)
+	commandsLock sync.RWMutex
+)
+
+// ObserveCommand records the latency of a command
+func ObserveCommand(name string, elapsed time.Duration) {
+	commandsLock.RLock()
+	h, ok := commands[name]
+	commandsLock.RUnlock()
+	if !ok {
+		commandsLock.Lock()
+		h, ok = commands[name]
+		if !ok {
+			h = new(histogram)
+			h.counts = make([]uint64, len(latencyBuckets))
+			commands[name] = h
+		}
+		commandsLock.Unlock()
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// Sample is a value of a metric with its labels in name, value pairs
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+func escapeLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return value
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escapeLabel(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteMetric writes a metric with its samples
+func WriteMetric(w io.Writer, name, typ, help string, samples []Sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(s.Labels), formatValue(s.Value))
+	}
+}
+
+func writeCounter(w io.Writer, name, help string, c *Counter) {
+	WriteMetric(w, name, "counter", help, []Sample{{Value: float64(c.Value())}})
+}
+
+// WriteTo writes all the counters and histograms
+func WriteTo(w io.Writer) {
+	writeCounter(w, "uq_pushes_total", "Total number of pushed messages.", Pushes)
+	writeCounter(w, "uq_pops_total", "Total number of popped messages.", Pops)
+	writeCounter(w, "uq_confirms_total", "Total number of confirmed messages.", Confirms)
+	writeCounter(w, "uq_nacks_total", "Total number of nacked messages.", Nacks)
+	writeCounter(w, "uq_touches_total", "Total number of touched messages.", Touches)
+	writeCounter(w, "uq_recycles_total", "Total number of recycled messages.", Recycles)
+
+	errorsLock.RLock()
+	codes := make([]int, 0, len(errorCounts))
+	for code := range errorCounts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	samples := make([]Sample, len(codes))
+	for i, code := range codes {
+		samples[i].Labels = []string{"code", strconv.Itoa(code)}
+		samples[i].Value = float64(errorCounts[code].Value())
+	}
+	errorsLock.RUnlock()
+	WriteMetric(w, "uq_errors_total", "counter", "Total number of errors returned to clients by code.", samples)
+
+	name := "uq_command_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s %s\n", name, "Latency of redis commands.")
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	commandsLock.RLock()
+	cmds := make([]string, 0, len(commands))
+	for cmd := range commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	for _, cmd := range cmds {
+		h := commands[cmd]
+		h.Lock()
+		for i, bound := range latencyBuckets {
+			labels := formatLabels([]string{"command", cmd, "le", formatValue(bound)})
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, h.counts[i])
+		}
+		labels := formatLabels([]string{"command", cmd, "le", "+Inf"})
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, h.count)
+		labels = formatLabels([]string{"command", cmd})
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+		h.Unlock()
+	}
+	commandsLock.RUnlock()
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buaazp/uq/utils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCounter(t *testing.T) {
+	Convey("Test Counter", t, func() {
+		c := new(Counter)
+		c.Inc()
+		c.Add(2)
+		So(c.Value(), ShouldEqual, 3)
+	})
+}
+
+func TestWriteMetric(t *testing.T) {
+	Convey("Test WriteMetric", t, func() {
+		buf := new(bytes.Buffer)
+		WriteMetric(buf, "uq_test", "gauge", "A test gauge.", []Sample{
+			{Labels: []string{"topic", `fo"o`}, Value: 1},
+			{Value: 2.5},
+		})
+		So(buf.String(), ShouldEqual, "# HELP uq_test A test gauge.\n"+
+			"# TYPE uq_test gauge\n"+
+			"uq_test{topic=\"fo\\\"o\"} 1\n"+
+			"uq_test 2.5\n")
+	})
+}
+
+func TestWriteTo(t *testing.T) {
+	Convey("Test WriteTo", t, func() {
+		Pushes.Inc()
+		Nacks.Inc()
+		Error(utils.NewError(utils.ErrBadRequest, `test`))
+		Error(errors.New("test"))
+		ObserveCommand("qpush", 2*time.Millisecond)
+
+		buf := new(bytes.Buffer)
+		WriteTo(buf)
+		out := buf.String()
+		So(strings.Contains(out, "uq_pushes_total 1\n"), ShouldBeTrue)
+		So(strings.Contains(out, "uq_nacks_total 1\n"), ShouldBeTrue)
+		So(strings.Contains(out, "uq_touches_total 0\n"), ShouldBeTrue)
+		So(strings.Contains(out, "uq_errors_total{code=\"400\"} 1\n"), ShouldBeTrue)
+		So(strings.Contains(out, "uq_errors_total{code=\"500\"} 1\n"), ShouldBeTrue)
+		So(strings.Contains(out, "uq_command_duration_seconds_bucket{command=\"qpush\",le=\"0.001\"} 0\n"), ShouldBeTrue)
+		So(strings.Contains(out, "uq_command_duration_seconds_bucket{command=\"qpush\",le=\"0.005\"} 1\n"), ShouldBeTrue)
+		So(strings.Contains(out, "uq_command_duration_seconds_count{command=\"qpush\"} 1\n"), ShouldBeTrue)
+	})
+}
@@ -1,6 +1,9 @@
 package queue
 
 import (
+	"io"
+	"time"
+
 	"github.com/buaazp/uq/store"
 )
 
@@ -16,13 +19,18 @@ func NewFakeQueue(storage store.Storage, ip string, port int, etcdServers []stri
 // queue functions
 
 // Push implements Push interface
-func (f *FakeQueue) Push(key string, data []byte) error {
-	return nil
+func (f *FakeQueue) Push(key string, data []byte) (string, error) {
+	return "", nil
+}
+
+// PushMessage implements PushMessage interface
+func (f *FakeQueue) PushMessage(key string, msg *Message, opts *PushOptions) (string, error) {
+	return "", nil
 }
 
 // MultiPush implements MultiPush interface
-func (f *FakeQueue) MultiPush(key string, datas [][]byte) error {
-	return nil
+func (f *FakeQueue) MultiPush(key string, datas [][]byte) ([]string, error) {
+	return nil, nil
 }
 
 // Pop implements Pop interface
@@ -30,11 +38,26 @@ func (f *FakeQueue) Pop(key string) (string, []byte, error) {
 	return "", nil, nil
 }
 
+// PopWait implements PopWait interface
+func (f *FakeQueue) PopWait(key string, timeout time.Duration) (string, []byte, error) {
+	return "", nil, nil
+}
+
+// PopMessage implements PopMessage interface
+func (f *FakeQueue) PopMessage(key string, timeout time.Duration) (string, *Message, error) {
+	return "", nil, nil
+}
+
 // MultiPop implements MultiPop interface
 func (f *FakeQueue) MultiPop(key string, n int) ([]string, [][]byte, error) {
 	return nil, nil, nil
 }
 
+// Peek implements Peek interface
+func (f *FakeQueue) Peek(key string, offset uint64, n int) ([]string, [][]byte, error) {
+	return nil, nil, nil
+}
+
 // Confirm implements Confirm interface
 func (f *FakeQueue) Confirm(key string) error {
 	return nil
@@ -45,6 +68,16 @@ func (f *FakeQueue) MultiConfirm(keys []string) []error {
 	return nil
 }
 
+// Nack implements Nack interface
+func (f *FakeQueue) Nack(key string) error {
+	return nil
+}
+
+// Touch implements Touch interface
+func (f *FakeQueue) Touch(key string, timeout time.Duration) error {
+	return nil
+}
+
 // admin functions
 
 // Create implements Create interface
@@ -57,6 +90,16 @@ func (f *FakeQueue) Empty(key string) error {
 	return nil
 }
 
+// Seek implements Seek interface
+func (f *FakeQueue) Seek(key string, offset uint64) error {
+	return nil
+}
+
+// SeekTime implements SeekTime interface
+func (f *FakeQueue) SeekTime(key string, ts time.Time) error {
+	return nil
+}
+
 // Remove implements Remove interface
 func (f *FakeQueue) Remove(key string) error {
 	return nil
@@ -67,6 +110,31 @@ func (f *FakeQueue) Stat(key string) (*Stat, error) {
 	return nil, nil
 }
 
+// List implements List interface
+func (f *FakeQueue) List() ([]*Stat, error) {
+	return nil, nil
+}
+
+// Backup implements Backup interface
+func (f *FakeQueue) Backup(w io.Writer) error {
+	return nil
+}
+
+// Restore implements Restore interface
+func (f *FakeQueue) Restore(r io.Reader) error {
+	return nil
+}
+
+// ExportTopic implements ExportTopic interface
+func (f *FakeQueue) ExportTopic(name string, w io.Writer) error {
+	return nil
+}
+
+// ImportTopic implements ImportTopic interface
+func (f *FakeQueue) ImportTopic(r io.Reader) error {
+	return nil
+}
+
 // Close implements Close interface
 func (f *FakeQueue) Close() {
 	return
@@ -1,18 +1,48 @@
 package queue
 
+import (
+	"io"
+	"time"
+)
+
+// PushOptions are the options of pushing a message, the content type,
+// headers and group of message are carried by the message itself
+type PushOptions struct {
+	// Delay is the time before the message can be popped
+	Delay time.Duration
+	// Dedup is the dedup key of message, a message pushed again with the
+	// same key in the dedup window of topic is not appended. The keys are
+	// remembered by each priority level of topic, so the same key pushed
+	// into two levels makes two messages.
+	Dedup string
+}
+
 // MessageQueue is the message queue interface of uq
 type MessageQueue interface {
 	// queue functions
-	Push(key string, data []byte) error
-	MultiPush(key string, datas [][]byte) error
+	Push(key string, data []byte) (string, error)
+	PushMessage(key string, msg *Message, opts *PushOptions) (string, error)
+	MultiPush(key string, datas [][]byte) ([]string, error)
 	Pop(key string) (string, []byte, error)
+	PopWait(key string, timeout time.Duration) (string, []byte, error)
+	PopMessage(key string, timeout time.Duration) (string, *Message, error)
 	MultiPop(key string, n int) ([]string, [][]byte, error)
+	Peek(key string, offset uint64, n int) ([]string, [][]byte, error)
 	Confirm(key string) error
 	MultiConfirm(keys []string) []error
+	Nack(key string) error
+	Touch(key string, timeout time.Duration) error
 	// admin functions
 	Create(key, recycle string) error
 	Empty(key string) error
+	Seek(key string, offset uint64) error
+	SeekTime(key string, ts time.Time) error
 	Remove(key string) error
 	Stat(key string) (*Stat, error)
+	List() ([]*Stat, error)
+	Backup(w io.Writer) error
+	Restore(r io.Reader) error
+	ExportTopic(name string, w io.Writer) error
+	ImportTopic(r io.Reader) error
 	Close()
 }
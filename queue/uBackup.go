@@ -0,0 +1,647 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/buaazp/uq/utils"
+)
+
+// The archive of a backup is the dump of the pairs which uq keeps in its
+// storage, so that a queue is rebuilt by writing them back and loading it:
+//
+//	magic   "UQBK"
+//	version uint32, 1 now
+//	pair    flag 1 | key size uvarint | key | value size uvarint | value
+//	...
+//	end     flag 0 | crc32 IEEE of all the bytes before it, uint32
+//
+// The integers are little endian. The pairs are the queue store, and for
+// each topic its store, head, tail, messages and delays, and the store,
+// recycle and filter of its lines. The inflight messages of a line are in
+// its store. An export of topic is the same without the queue store, so its
+// first key is the name of topic.
+const (
+	archiveMagic   string = "UQBK"
+	archiveVersion uint32 = 1
+	archivePair    byte   = 1
+	archiveEnd     byte   = 0
+	// archiveMaxSize is the max size of a key or value in archive
+	archiveMaxSize uint64 = 1 << 30
+)
+
+type archiveWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	out io.Writer
+}
+
+func newArchiveWriter(w io.Writer) (*archiveWriter, error) {
+	aw := new(archiveWriter)
+	aw.w = bufio.NewWriter(w)
+	aw.crc = crc32.NewIEEE()
+	aw.out = io.MultiWriter(aw.w, aw.crc)
+
+	header := make([]byte, len(archiveMagic)+4)
+	copy(header, archiveMagic)
+	binary.LittleEndian.PutUint32(header[len(archiveMagic):], archiveVersion)
+	_, err := aw.out.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+func (aw *archiveWriter) write(key string, value []byte) error {
+	buf := make([]byte, 1+2*binary.MaxVarintLen64)
+	buf[0] = archivePair
+	n := 1 + binary.PutUvarint(buf[1:], uint64(len(key)))
+	_, err := aw.out.Write(buf[:n])
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(aw.out, key)
+	if err != nil {
+		return err
+	}
+	n = binary.PutUvarint(buf, uint64(len(value)))
+	_, err = aw.out.Write(buf[:n])
+	if err != nil {
+		return err
+	}
+	_, err = aw.out.Write(value)
+	return err
+}
+
+// close writes the end of archive and flushes it
+func (aw *archiveWriter) close() error {
+	_, err := aw.out.Write([]byte{archiveEnd})
+	if err != nil {
+		return err
+	}
+	sum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sum, aw.crc.Sum32())
+	_, err = aw.w.Write(sum)
+	if err != nil {
+		return err
+	}
+	return aw.w.Flush()
+}
+
+type archiveReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func newArchiveReader(r io.Reader) (*archiveReader, error) {
+	ar := new(archiveReader)
+	ar.r = bufio.NewReader(r)
+	ar.crc = crc32.NewIEEE()
+
+	header := make([]byte, len(archiveMagic)+4)
+	err := ar.readFull(header)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, badArchive("not an archive of uq")
+	}
+	if binary.LittleEndian.Uint32(header[len(archiveMagic):]) != archiveVersion {
+		return nil, badArchive("archive version not supported")
+	}
+	return ar, nil
+}
+
+func badArchive(cause string) error {
+	return utils.NewError(
+		utils.ErrBadRequest,
+		`bad archive: `+cause,
+	)
+}
+
+// ReadByte reads a byte and adds it to the crc of archive
+func (ar *archiveReader) ReadByte() (byte, error) {
+	b, err := ar.r.ReadByte()
+	if err != nil {
+		return 0, badArchive(err.Error())
+	}
+	ar.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (ar *archiveReader) readFull(data []byte) error {
+	_, err := io.ReadFull(ar.r, data)
+	if err != nil {
+		return badArchive(err.Error())
+	}
+	ar.crc.Write(data)
+	return nil
+}
+
+func (ar *archiveReader) readBytes() ([]byte, error) {
+	size, err := binary.ReadUvarint(ar)
+	if err != nil {
+		return nil, badArchive(err.Error())
+	}
+	if size > archiveMaxSize {
+		return nil, badArchive("record too large")
+	}
+	data := make([]byte, size)
+	err = ar.readFull(data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// next returns the next pair in archive, or io.EOF after the end of
+// archive is read and checked
+func (ar *archiveReader) next() (string, []byte, error) {
+	flag, err := ar.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	switch flag {
+	case archivePair:
+	case archiveEnd:
+		sum := ar.crc.Sum32()
+		data := make([]byte, 4)
+		_, err = io.ReadFull(ar.r, data)
+		if err != nil {
+			return "", nil, badArchive(err.Error())
+		}
+		if binary.LittleEndian.Uint32(data) != sum {
+			return "", nil, badArchive("crc mismatch")
+		}
+		return "", nil, io.EOF
+	default:
+		return "", nil, badArchive("unknown record")
+	}
+
+	key, err := ar.readBytes()
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := ar.readBytes()
+	if err != nil {
+		return "", nil, err
+	}
+	return string(key), value, nil
+}
+
+// archivePairs are the pairs of state taken for an archive
+type archivePairs struct {
+	keys  []string
+	datas [][]byte
+}
+
+func (p *archivePairs) add(key string, data []byte) {
+	p.keys = append(p.keys, key)
+	p.datas = append(p.datas, data)
+}
+
+func (p *archivePairs) marshal(key string, m interface {
+	Marshal() ([]byte, error)
+}) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	p.add(key, data)
+	return nil
+}
+
+func uint64Data(n uint64) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, n)
+	return data
+}
+
+// topicSnapshot is the state of a topic taken for an archive, the clean
+// of topic is held off until its messages are written
+type topicSnapshot struct {
+	t          *topic
+	head, tail uint64
+}
+
+// release lets the topic clean its messages again
+func (snap *topicSnapshot) release() {
+	atomic.AddInt32(&snap.t.archives, -1)
+}
+
+// snapshot adds the state of topic and its lines to pairs, it should be
+// called with the backupLock of queue held so that nothing is changing.
+// The head lock is only held while the state is taken, the messages in
+// [head, tail) are kept from clean by the archives count of topic.
+func (t *topic) snapshot(p *archivePairs) (*topicSnapshot, error) {
+	t.headLock.RLock()
+	defer t.headLock.RUnlock()
+	snap := &topicSnapshot{t, t.head, t.getTail()}
+
+	t.linesLock.RLock()
+	err := p.marshal(t.name, t.genTopicStore())
+	if err == nil {
+		for _, l := range t.lines {
+			l.inflightLock.RLock()
+			l.headLock.RLock()
+			err = p.marshal(t.name+"/"+l.name, l.genLineStore())
+			l.headLock.RUnlock()
+			l.inflightLock.RUnlock()
+			if err != nil {
+				break
+			}
+			p.add(l.recycleKey, []byte(l.recycle.String()))
+			if l.filter != nil {
+				p.add(l.filterKey, []byte(l.filter.String()))
+			}
+		}
+	}
+	t.linesLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	p.add(t.headKey, uint64Data(snap.head))
+	p.add(t.tailKey, uint64Data(snap.tail))
+	t.delaysLock.RLock()
+	for id, deliver := range t.delays {
+		if id >= snap.head && id < snap.tail {
+			p.add(t.delayKey(id), uint64Data(uint64(deliver)))
+		}
+	}
+	t.delaysLock.RUnlock()
+
+	// the dedup keys of the messages pushed after the snapshot are left out
+	t.tailLock.RLock()
+	tail := t.dedupHead
+	for _, e := range t.dedupLog {
+		if e.id >= snap.tail {
+			break
+		}
+		if e.key != "" {
+			p.add(t.dedupEntryKey(e.seq), e.data())
+		}
+		tail = e.seq + 1
+	}
+	if tail > t.dedupHead {
+		p.add(t.dedupKey, dedupRangeData(t.dedupHead, tail))
+	}
+	t.tailLock.RUnlock()
+
+	atomic.AddInt32(&t.archives, 1)
+	return snap, nil
+}
+
+// writeArchive writes the pairs and then the messages of the topics, the
+// topics are released once their messages are written. No lock of topic
+// is held here, so a slow reader of archive does not block the queue.
+func writeArchive(w io.Writer, p *archivePairs, snaps []*topicSnapshot) error {
+	defer func() {
+		for _, snap := range snaps {
+			snap.release()
+		}
+	}()
+
+	aw, err := newArchiveWriter(w)
+	if err != nil {
+		return err
+	}
+	for i, key := range p.keys {
+		err = aw.write(key, p.datas[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	for len(snaps) > 0 {
+		snap := snaps[0]
+		t := snap.t
+		for start := snap.head; start < snap.tail; start += cleanBatchSize {
+			end := start + cleanBatchSize
+			if end > snap.tail {
+				end = snap.tail
+			}
+			ids := make([]uint64, 0, end-start)
+			for id := start; id < end; id++ {
+				ids = append(ids, id)
+			}
+			datas, err := t.getDatas(ids)
+			if err != nil {
+				return err
+			}
+			for i, data := range datas {
+				if data == nil {
+					return utils.NewError(
+						utils.ErrInternalError,
+						`archive message missing: `+utils.Acatui(t.name, ":", ids[i]),
+					)
+				}
+				err = aw.write(utils.Acatui(t.name, ":", ids[i]), data)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		snaps = snaps[1:]
+		snap.release()
+	}
+
+	return aw.close()
+}
+
+// snapshot takes the state of queue and all its topics
+func (u *UnitedQueue) snapshot() (*archivePairs, []*topicSnapshot, error) {
+	u.backupLock.Lock()
+	defer u.backupLock.Unlock()
+	u.topicsLock.RLock()
+	defer u.topicsLock.RUnlock()
+
+	p := new(archivePairs)
+	err := p.marshal(storageKeyWord, u.genQueueStore())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(u.topics))
+	for name := range u.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	snaps := make([]*topicSnapshot, 0, len(names))
+	for _, name := range names {
+		for _, t := range u.topics[name].allLevels() {
+			snap, err := t.snapshot(p)
+			if err != nil {
+				for _, snap := range snaps {
+					snap.release()
+				}
+				return nil, nil, err
+			}
+			snaps = append(snaps, snap)
+		}
+	}
+
+	return p, snaps, nil
+}
+
+// Backup implements Backup interface. The state of queue is taken at once
+// and then the messages are written, pushes and pops go on in the time.
+func (u *UnitedQueue) Backup(w io.Writer) error {
+	p, snaps, err := u.snapshot()
+	if err != nil {
+		return err
+	}
+	return writeArchive(w, p, snaps)
+}
+
+// ExportTopic implements ExportTopic interface. The archive is like the one
+// of a backup, which has only the pairs of the topic.
+func (u *UnitedQueue) ExportTopic(name string, w io.Writer) error {
+	name = strings.TrimPrefix(name, "/")
+	name = strings.TrimSuffix(name, "/")
+
+	u.backupLock.Lock()
+	u.topicsLock.RLock()
+	t, ok := u.topics[name]
+	u.topicsLock.RUnlock()
+	if !ok {
+		u.backupLock.Unlock()
+		return utils.NewError(
+			utils.ErrTopicNotExisted,
+			`queue export`,
+		)
+	}
+	p := new(archivePairs)
+	var snaps []*topicSnapshot
+	for _, lt := range t.allLevels() {
+		snap, err := lt.snapshot(p)
+		if err != nil {
+			for _, snap := range snaps {
+				snap.t.headLock.RUnlock()
+			}
+			u.backupLock.Unlock()
+			return err
+		}
+		snaps = append(snaps, snap)
+	}
+	u.backupLock.Unlock()
+
+	return writeArchive(w, p, snaps)
+}
+
+// spoolArchive copies an archive into a temp file and checks it, the check
+// func is called with the first key in archive
+func spoolArchive(r io.Reader, check func(first string) error) (*os.File, error) {
+	f, err := ioutil.TempFile("", "uq.archive.")
+	if err != nil {
+		return nil, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+
+	first := true
+	err = readArchive(f, func(keys []string, datas [][]byte) error {
+		if first {
+			first = false
+			return check(keys[0])
+		}
+		return nil
+	})
+	if err == nil && first {
+		err = badArchive("archive is empty")
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			err = utils.NewError(
+				utils.ErrInternalError,
+				err.Error(),
+			)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Restore implements Restore interface. The archive is checked before it
+// is written into the storage, and the queue should have no topic.
+func (u *UnitedQueue) Restore(r io.Reader) error {
+	f, err := spoolArchive(r, func(first string) error {
+		if first != storageKeyWord {
+			return badArchive("not a backup of queue")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	u.backupLock.Lock()
+	defer u.backupLock.Unlock()
+	u.topicsLock.RLock()
+	topics := len(u.topics)
+	u.topicsLock.RUnlock()
+	if topics > 0 {
+		return utils.NewError(
+			utils.ErrBadRequest,
+			`queue restore: queue is not empty`,
+		)
+	}
+
+	err = readArchive(f, u.multiSetData)
+	if err != nil {
+		return err
+	}
+	return u.loadQueue()
+}
+
+// ImportTopic implements ImportTopic interface. The archive is an export of
+// topic, and the topic should not exist in the queue.
+func (u *UnitedQueue) ImportTopic(r io.Reader) error {
+	var name string
+	f, err := spoolArchive(r, func(first string) error {
+		if first == storageKeyWord {
+			return badArchive("a backup of queue is not a topic")
+		}
+		if first == "" || strings.ContainsAny(first, "/:"+levelSep) {
+			return badArchive("not an export of topic")
+		}
+		name = first
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// all the pairs should belong to the topic
+	err = readArchive(f, func(keys []string, datas [][]byte) error {
+		for _, key := range keys {
+			if key != name &&
+				!strings.HasPrefix(key, name+":") &&
+				!strings.HasPrefix(key, name+"/") &&
+				!strings.HasPrefix(key, name+levelSep) {
+				return badArchive("key not in topic: " + key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+
+	u.backupLock.Lock()
+	defer u.backupLock.Unlock()
+	u.topicsLock.RLock()
+	_, ok := u.topics[name]
+	u.topicsLock.RUnlock()
+	if ok {
+		return utils.NewError(
+			utils.ErrTopicExisted,
+			`queue import`,
+		)
+	}
+
+	err = readArchive(f, u.multiSetData)
+	if err != nil {
+		return err
+	}
+	topicStoreData, err := u.getData(name)
+	if err != nil {
+		return err
+	}
+	var ts UnitedTopicStore
+	err = ts.Unmarshal(topicStoreData)
+	if err != nil {
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	t, err := u.loadTopic(name, ts)
+	if err != nil {
+		return err
+	}
+
+	u.topicsLock.Lock()
+	defer u.topicsLock.Unlock()
+	u.topics[name] = t
+	err = u.exportQueue()
+	if err != nil {
+		t.remove()
+		delete(u.topics, name)
+		return err
+	}
+	log.Printf("topic[%s] imported.", name)
+	return nil
+}
+
+// readArchive reads the pairs in archive and handles them in batches
+func readArchive(r io.Reader, handle func(keys []string, datas [][]byte) error) error {
+	ar, err := newArchiveReader(r)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	var datas [][]byte
+	for {
+		key, data, err := ar.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		datas = append(datas, data)
+		if uint64(len(keys)) >= cleanBatchSize {
+			err = handle(keys, datas)
+			if err != nil {
+				return err
+			}
+			keys, datas = nil, nil
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return handle(keys, datas)
+}
@@ -0,0 +1,264 @@
+package queue
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buaazp/uq/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackupRestore(t *testing.T) {
+	Convey("Test Backup And Restore Queue", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		src, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer src.Close()
+
+		So(src.Create("bk", ""), ShouldBeNil)
+		So(src.Create("bk/x", "10s"), ShouldBeNil)
+		So(src.Create("bk/y", ""), ShouldBeNil)
+		for i := 0; i < 5; i++ {
+			_, err = src.Push("bk", []byte(strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+		}
+		_, err = src.PushMessage("bk", &Message{Body: []byte("later")}, &PushOptions{Delay: time.Hour})
+		So(err, ShouldBeNil)
+		id0, _, err := src.Pop("bk/x")
+		So(err, ShouldBeNil)
+		id1, _, err := src.Pop("bk/x")
+		So(err, ShouldBeNil)
+		So(src.Confirm(id0), ShouldBeNil)
+		_, _, err = src.Pop("bk/y")
+		So(err, ShouldBeNil)
+
+		var archive bytes.Buffer
+		So(src.Backup(&archive), ShouldBeNil)
+		// the queue goes on after the backup
+		_, err = src.Push("bk", []byte("after"))
+		So(err, ShouldBeNil)
+
+		mdb2, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		dst, err := NewUnitedQueue(mdb2, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer dst.Close()
+		So(dst.Restore(bytes.NewReader(archive.Bytes())), ShouldBeNil)
+
+		qs, err := dst.Stat("bk")
+		So(err, ShouldBeNil)
+		So(qs.Tail, ShouldEqual, 6)
+		So(len(qs.Lines), ShouldEqual, 2)
+		ls, err := dst.Stat("bk/x")
+		So(err, ShouldBeNil)
+		So(ls.Recycle, ShouldEqual, "10s")
+		So(ls.Head, ShouldEqual, 2)
+		So(ls.Inflight, ShouldEqual, 1)
+
+		// the inflight message can be confirmed once only
+		So(dst.Confirm(id1), ShouldBeNil)
+		So(dst.Confirm(id0), ShouldNotBeNil)
+		_, data, err := dst.Pop("bk/x")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "2")
+		_, data, err = dst.Pop("bk/y")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "1")
+		ids, _, err := dst.MultiPop("bk/y", 10)
+		So(err, ShouldBeNil)
+		So(len(ids), ShouldEqual, 3)
+
+		// the delayed message is still delayed
+		_, got, err := dst.Peek("bk", 5, 1)
+		So(err, ShouldBeNil)
+		So(string(got[0]), ShouldEqual, "later")
+		So(dst.topics["bk"].getDelay(5), ShouldBeGreaterThan, time.Now().UnixNano())
+
+		// a queue with topics can not be restored
+		err = dst.Restore(bytes.NewReader(archive.Bytes()))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRestoreBadArchive(t *testing.T) {
+	Convey("Test Restore Bad Archive", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		src, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer src.Close()
+		So(src.Create("bad", ""), ShouldBeNil)
+		_, err = src.Push("bad", []byte("foo"))
+		So(err, ShouldBeNil)
+
+		var archive bytes.Buffer
+		So(src.Backup(&archive), ShouldBeNil)
+		data := archive.Bytes()
+
+		mdb2, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		dst, err := NewUnitedQueue(mdb2, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer dst.Close()
+
+		So(dst.Restore(bytes.NewBufferString("not an archive")), ShouldNotBeNil)
+		So(dst.Restore(bytes.NewReader(data[:len(data)-3])), ShouldNotBeNil)
+		bad := append([]byte(nil), data...)
+		bad[len(bad)/2]++
+		So(dst.Restore(bytes.NewReader(bad)), ShouldNotBeNil)
+
+		// nothing is written by a bad archive
+		stats, err := dst.List()
+		So(err, ShouldBeNil)
+		So(len(stats), ShouldEqual, 0)
+		_, err = mdb2.Get(storageKeyWord)
+		So(err, ShouldNotBeNil)
+
+		So(dst.Restore(bytes.NewReader(data)), ShouldBeNil)
+		_, got, err := dst.Peek("bad", 0, 1)
+		So(err, ShouldBeNil)
+		So(string(got[0]), ShouldEqual, "foo")
+	})
+}
+
+func TestExportImportTopic(t *testing.T) {
+	Convey("Test Export And Import Topic", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		src, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer src.Close()
+
+		So(src.Create("ex", ""), ShouldBeNil)
+		So(src.Create("ex/x", "10s"), ShouldBeNil)
+		So(src.Create("other", ""), ShouldBeNil)
+		for i := 0; i < 3; i++ {
+			_, err = src.Push("ex", []byte(strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+			_, err = src.Push("other", []byte(strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+		}
+		id, _, err := src.Pop("ex/x")
+		So(err, ShouldBeNil)
+
+		var archive bytes.Buffer
+		So(src.ExportTopic("none", &archive), ShouldNotBeNil)
+		So(src.ExportTopic("ex", &archive), ShouldBeNil)
+
+		logPath := "/tmp/uq.queue.test.import"
+		os.RemoveAll(logPath)
+		defer os.RemoveAll(logPath)
+		lsdb, err := store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		dst, err := NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		So(dst.Create("keep", ""), ShouldBeNil)
+		So(dst.Restore(bytes.NewReader(archive.Bytes())), ShouldNotBeNil)
+		So(dst.ImportTopic(bytes.NewReader(archive.Bytes())), ShouldBeNil)
+		So(dst.ImportTopic(bytes.NewReader(archive.Bytes())), ShouldNotBeNil)
+		dst.Close()
+
+		// the imported topic is kept in the queue
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		dst, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer dst.Close()
+		stats, err := dst.List()
+		So(err, ShouldBeNil)
+		So(len(stats), ShouldEqual, 2)
+		_, err = dst.Stat("other")
+		So(err, ShouldNotBeNil)
+		ls, err := dst.Stat("ex/x")
+		So(err, ShouldBeNil)
+		So(ls.Tail, ShouldEqual, 3)
+		So(ls.Inflight, ShouldEqual, 1)
+		So(dst.Confirm(id), ShouldBeNil)
+		_, data, err := dst.Pop("ex/x")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "1")
+
+		// a backup of queue is not an export of topic
+		var backup bytes.Buffer
+		So(src.Backup(&backup), ShouldBeNil)
+		So(dst.ImportTopic(bytes.NewReader(backup.Bytes())), ShouldNotBeNil)
+	})
+}
+
+func TestBackupSlowReader(t *testing.T) {
+	Convey("Test Backup With A Slow Reader", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		src, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer src.Close()
+
+		So(src.Create("slow", ""), ShouldBeNil)
+		So(src.Create("slow/x", ""), ShouldBeNil)
+		// the messages are larger than the buffer of archive writer, so
+		// the backup is stalled on them
+		data := bytes.Repeat([]byte("s"), 8192)
+		for i := 0; i < 3; i++ {
+			_, err = src.Push("slow", data)
+			So(err, ShouldBeNil)
+			_, _, err = src.Pop("slow/x")
+			So(err, ShouldBeNil)
+		}
+		tp := src.topics["slow"]
+
+		// nothing is read from the pipe until the queue is checked
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			err := src.Backup(pw)
+			pw.CloseWithError(err)
+			done <- err
+		}()
+		for atomic.LoadInt32(&tp.archives) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		checked := make(chan bool, 1)
+		go func() {
+			tp.clean()
+			src.Stat("slow")
+			checked <- true
+		}()
+		select {
+		case <-checked:
+		case <-time.After(5 * time.Second):
+			So("stat and clean blocked by backup", ShouldBeNil)
+		}
+		tp.headLock.RLock()
+		head := tp.head
+		tp.headLock.RUnlock()
+		So(head, ShouldEqual, 0)
+
+		archive, err := ioutil.ReadAll(pr)
+		So(err, ShouldBeNil)
+		So(<-done, ShouldBeNil)
+		So(atomic.LoadInt32(&tp.archives), ShouldEqual, 0)
+		tp.clean()
+		tp.headLock.RLock()
+		head = tp.head
+		tp.headLock.RUnlock()
+		So(head, ShouldEqual, 3)
+
+		mdb, err = store.NewMemStore()
+		So(err, ShouldBeNil)
+		dst, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer dst.Close()
+		So(dst.Restore(bytes.NewReader(archive)), ShouldBeNil)
+		ts, err := dst.Stat("slow")
+		So(err, ShouldBeNil)
+		So(ts.Tail, ShouldEqual, 3)
+	})
+}
@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/buaazp/uq/utils"
+)
+
+// dedupEntry remembers the id of a message pushed with a dedup key, it is
+// saved as topic:dedup:seq and the range of seqs is saved as topic:dedup
+type dedupEntry struct {
+	seq    uint64
+	key    string
+	id     uint64
+	expire int64
+}
+
+func (e *dedupEntry) data() []byte {
+	data := make([]byte, 16+len(e.key))
+	binary.LittleEndian.PutUint64(data, uint64(e.expire))
+	binary.LittleEndian.PutUint64(data[8:], e.id)
+	copy(data[16:], e.key)
+	return data
+}
+
+func dedupRangeData(head, tail uint64) []byte {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data, head)
+	binary.LittleEndian.PutUint64(data[8:], tail)
+	return data
+}
+
+func (t *topic) dedupEntryKey(seq uint64) string {
+	return utils.Acatui(t.dedupKey, ":", seq)
+}
+
+func (t *topic) dedupTail() uint64 {
+	return t.dedupHead + uint64(len(t.dedupLog))
+}
+
+// findDedup returns the entry of a dedup key which is not expired yet, it
+// should be called with tailLock held
+func (t *topic) findDedup(key string, now int64) (*dedupEntry, error) {
+	if t.dedup <= 0 {
+		return nil, utils.NewError(
+			utils.ErrBadRequest,
+			`topic has no dedup window`,
+		)
+	}
+	e, ok := t.dedups[key]
+	if !ok || e.expire <= now {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// addDedup keeps an entry whose keys have been written, it should be
+// called with tailLock held
+func (t *topic) addDedup(e *dedupEntry) {
+	t.dedupLog = append(t.dedupLog, e)
+	t.dedups[e.key] = e
+}
+
+// pruneDedups forgets the dedup keys which are out of the window
+func (t *topic) pruneDedups() {
+	t.tailLock.Lock()
+	defer t.tailLock.Unlock()
+
+	now := time.Now().UnixNano()
+	n := 0
+	for n < len(t.dedupLog) && t.dedupLog[n].expire <= now {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+
+	// the entries are deleted first, the missing ones are skipped by load
+	head := t.dedupHead + uint64(n)
+	err := t.q.delRangeData(t.dedupKey+":", t.dedupHead, head)
+	if err != nil {
+		log.Printf("topic[%s] del dedups[%d - %d] error; %s", t.name, t.dedupHead, head, err)
+		return
+	}
+	err = t.q.setData(t.dedupKey, dedupRangeData(head, t.dedupTail()))
+	if err != nil {
+		log.Printf("topic[%s] set %s error; %s", t.name, t.dedupKey, err)
+		return
+	}
+	for _, e := range t.dedupLog[:n] {
+		if t.dedups[e.key] == e {
+			delete(t.dedups, e.key)
+		}
+	}
+	t.dedupLog = t.dedupLog[n:]
+	t.dedupHead = head
+}
+
+// loadDedups loads the dedup keys which are still in the window
+func (t *topic) loadDedups() error {
+	rangeData, err := t.q.getData(t.dedupKey)
+	if err != nil || len(rangeData) != 16 {
+		// nothing is pushed with a dedup key yet
+		return nil
+	}
+	head := binary.LittleEndian.Uint64(rangeData)
+	tail := binary.LittleEndian.Uint64(rangeData[8:])
+
+	t.dedupHead = head
+	now := time.Now().UnixNano()
+	for start := head; start < tail; start += cleanBatchSize {
+		end := start + cleanBatchSize
+		if end > tail {
+			end = tail
+		}
+		keys := make([]string, 0, end-start)
+		for seq := start; seq < end; seq++ {
+			keys = append(keys, t.dedupEntryKey(seq))
+		}
+		datas, err := t.q.multiGetData(keys)
+		if err != nil {
+			return err
+		}
+		for i, data := range datas {
+			seq := start + uint64(i)
+			if len(data) < 16 {
+				// a pruned entry, it is kept as expired to hold its seq
+				t.dedupLog = append(t.dedupLog, &dedupEntry{seq: seq})
+				continue
+			}
+			e := &dedupEntry{
+				seq:    seq,
+				key:    string(data[16:]),
+				id:     binary.LittleEndian.Uint64(data[8:]),
+				expire: int64(binary.LittleEndian.Uint64(data)),
+			}
+			t.dedupLog = append(t.dedupLog, e)
+			if e.expire > now {
+				t.dedups[e.key] = e
+			}
+		}
+	}
+	return nil
+}
+
+func (t *topic) removeDedupData() error {
+	if t.dedup <= 0 {
+		return nil
+	}
+	if len(t.dedupLog) > 0 {
+		err := t.q.delRangeData(t.dedupKey+":", t.dedupHead, t.dedupTail())
+		if err != nil {
+			return err
+		}
+	}
+	return t.q.delData(t.dedupKey)
+}
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buaazp/uq/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func pushDedup(uq *UnitedQueue, key, dedup, body string) (string, error) {
+	msg := new(Message)
+	msg.Body = []byte(body)
+	return uq.PushMessage(key, msg, &PushOptions{Dedup: dedup})
+}
+
+func TestPushDedup(t *testing.T) {
+	Convey("Test Push With Dedup Keys", t, func() {
+		logPath := "/tmp/uq.queue.test.dedup"
+		os.RemoveAll(logPath)
+		defer os.RemoveAll(logPath)
+
+		lsdb, err := store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err := NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		So(uq.Create("dd", "dedup=1h"), ShouldBeNil)
+		So(uq.Create("dd/x", ""), ShouldBeNil)
+		So(uq.Create("nd", ""), ShouldBeNil)
+		So(uq.Create("bad", "dedup=-1m"), ShouldNotBeNil)
+
+		id, err := pushDedup(uq, "dd", "k1", "a")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/0")
+		id, err = pushDedup(uq, "dd", "k1", "a")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/0")
+		id, err = pushDedup(uq, "dd", "k2", "b")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/1")
+		id, err = pushDedup(uq, "dd", "", "c")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/2")
+		_, err = pushDedup(uq, "nd", "k1", "a")
+		So(err, ShouldNotBeNil)
+
+		// each priority level remembers its own keys
+		So(uq.Create("pd", "dedup=1h,priorities=2"), ShouldBeNil)
+		id, err = pushDedup(uq, "pd", "k1", "a")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "pd/0")
+		id, err = pushDedup(uq, "pd~1", "k1", "a")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "pd~1/0")
+		id, err = pushDedup(uq, "pd~1", "k1", "a")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "pd~1/0")
+
+		qs, err := uq.Stat("dd")
+		So(err, ShouldBeNil)
+		So(qs.Tail, ShouldEqual, 3)
+		So(qs.Dedup, ShouldEqual, "1h0m0s")
+		uq.Close()
+
+		// the keys are remembered after restart, even if consumed
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer uq.Close()
+		_, data, err := uq.Pop("dd/x")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "a")
+		id, err = pushDedup(uq, "dd", "k1", "a")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/0")
+		id, err = pushDedup(uq, "dd", "k2", "b")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/1")
+
+		// the keys are carried by backup
+		var archive bytes.Buffer
+		So(uq.Backup(&archive), ShouldBeNil)
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		dst, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer dst.Close()
+		So(dst.Restore(bytes.NewReader(archive.Bytes())), ShouldBeNil)
+		id, err = pushDedup(dst, "dd", "k2", "b")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/1")
+		id, err = pushDedup(dst, "dd", "k3", "d")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dd/3")
+	})
+}
+
+func TestPruneDedups(t *testing.T) {
+	Convey("Test Dedup Keys Out Of Window", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		uq, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer uq.Close()
+		So(uq.Create("dw", "dedup=10ms"), ShouldBeNil)
+
+		id, err := pushDedup(uq, "dw", "k1", "a")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dw/0")
+		time.Sleep(20 * time.Millisecond)
+		id, err = pushDedup(uq, "dw", "k1", "a")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "dw/1")
+
+		tp := uq.topics["dw"]
+		time.Sleep(20 * time.Millisecond)
+		tp.pruneDedups()
+		tp.tailLock.RLock()
+		So(len(tp.dedupLog), ShouldEqual, 0)
+		So(len(tp.dedups), ShouldEqual, 0)
+		So(tp.dedupHead, ShouldEqual, 2)
+		tp.tailLock.RUnlock()
+		data, err := mdb.Get(tp.dedupEntryKey(0))
+		So(err != nil || data == nil, ShouldBeTrue)
+
+		So(uq.Remove("dw"), ShouldBeNil)
+		_, err = mdb.Get("dw" + keyTopicDedup)
+		So(err, ShouldNotBeNil)
+	})
+}
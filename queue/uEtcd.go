@@ -9,8 +9,6 @@ import (
 
 const (
 	etcdUqServerListValue string        = "online"
-	etcdTTL               uint64        = 60
-	oneSecond             uint64        = uint64(time.Second)
 	etcdWatchDelay        time.Duration = 3 * time.Second
 	etcdRegisterDelay     time.Duration = 3 * time.Second
 )
@@ -133,7 +131,7 @@ func (u *UnitedQueue) register() error {
 	// log.Printf("etcd register self...")
 
 	key := u.etcdKey + "/servers/" + u.selfAddr
-	_, err := u.etcdClient.Set(key, etcdUqServerListValue, etcdTTL)
+	_, err := u.etcdClient.Set(key, etcdUqServerListValue, uint64(GetTunables().EtcdTTL/time.Second))
 	if err != nil {
 		return err
 	}
@@ -176,13 +174,22 @@ func (u *UnitedQueue) etcdRun() {
 		return
 	}
 
-	ticker := time.NewTicker(time.Duration(etcdTTL * oneSecond))
+	ttl := GetTunables().EtcdTTL
+	ticker := time.NewTicker(ttl)
+	defer func() {
+		ticker.Stop()
+	}()
 	quit := false
 	for !quit {
 		select {
 		case <-ticker.C:
 			// log.Printf("etcdRun ticked.")
 			u.register()
+			if t := GetTunables().EtcdTTL; t != ttl {
+				ttl = t
+				ticker.Stop()
+				ticker = time.NewTicker(ttl)
+			}
 		case <-u.etcdStop:
 			// log.Printf("etcdRun stoping...")
 			quit = true
@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/buaazp/uq/utils"
+)
+
+// A filter of line is a list of conditions joined by "&", a message is
+// delivered by the line only if it matches all of them:
+//
+//	header.NAME=VALUE  the header NAME of message is VALUE
+//	type=VALUE         the content type of message is VALUE
+//	prefix=VALUE       the body of message starts with VALUE
+//	json.FIELD=VALUE   the body is a JSON object whose FIELD is VALUE, FIELD
+//	                   can be a path like user.id and VALUE of a number or
+//	                   bool is written as in JSON
+//
+// The messages which do not match are passed by the line without being
+// delivered.
+const (
+	filterSep    string = "&"
+	filterHeader string = "header."
+	filterType   string = "type"
+	filterPrefix string = "prefix"
+	filterJSON   string = "json."
+)
+
+type filterCond struct {
+	kind  string
+	field string
+	value string
+}
+
+type lineFilter struct {
+	expr  string
+	conds []filterCond
+}
+
+func parseFilter(expr string) (*lineFilter, error) {
+	f := new(lineFilter)
+	f.expr = expr
+	for _, c := range strings.Split(expr, filterSep) {
+		kv := strings.SplitN(c, "=", 2)
+		if len(kv) != 2 {
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				`line filter error: `+c,
+			)
+		}
+		var cond filterCond
+		cond.value = kv[1]
+		switch {
+		case kv[0] == filterType, kv[0] == filterPrefix:
+			cond.kind = kv[0]
+		case strings.HasPrefix(kv[0], filterHeader) && len(kv[0]) > len(filterHeader):
+			cond.kind = filterHeader
+			cond.field = kv[0][len(filterHeader):]
+		case strings.HasPrefix(kv[0], filterJSON) && len(kv[0]) > len(filterJSON):
+			cond.kind = filterJSON
+			cond.field = kv[0][len(filterJSON):]
+		default:
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				`line filter unknown: `+kv[0],
+			)
+		}
+		f.conds = append(f.conds, cond)
+	}
+	return f, nil
+}
+
+// String returns the expression of filter
+func (f *lineFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// match returns whether a stored message matches the filter, all messages
+// match a nil filter
+func (f *lineFilter) match(data []byte) bool {
+	if f == nil {
+		return true
+	}
+
+	msg := decodeMessage(data)
+	var doc interface{}
+	parsed := false
+	for _, c := range f.conds {
+		switch c.kind {
+		case filterType:
+			if msg.ContentType != c.value {
+				return false
+			}
+		case filterPrefix:
+			if !bytes.HasPrefix(msg.Body, []byte(c.value)) {
+				return false
+			}
+		case filterHeader:
+			if msg.GetHeader(c.field) != c.value {
+				return false
+			}
+		case filterJSON:
+			if !parsed {
+				if json.Unmarshal(msg.Body, &doc) != nil {
+					return false
+				}
+				parsed = true
+			}
+			if !matchJSON(doc, c.field, c.value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchJSON(doc interface{}, path, value string) bool {
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		doc, ok = obj[key]
+		if !ok {
+			return false
+		}
+	}
+
+	if s, ok := doc.(string); ok {
+		return s == value
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return false
+	}
+	return string(data) == value
+}
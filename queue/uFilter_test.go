@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/buaazp/uq/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseFilter(t *testing.T) {
+	Convey("Test Parse Line Filter", t, func() {
+		for _, expr := range []string{
+			"type=text/csv",
+			"header.producer=tester&prefix=order:",
+			"json.user.id=42",
+		} {
+			f, err := parseFilter(expr)
+			So(err, ShouldBeNil)
+			So(f.String(), ShouldEqual, expr)
+		}
+		for _, expr := range []string{"", "type", "size=1", "header.=a", "json.=1"} {
+			_, err := parseFilter(expr)
+			So(err, ShouldNotBeNil)
+		}
+
+		var f *lineFilter
+		So(f.match([]byte("a")), ShouldBeTrue)
+		So(f.String(), ShouldEqual, "")
+
+		msg := new(Message)
+		msg.ContentType = "application/json"
+		msg.SetHeader("producer", "tester")
+		msg.Body = []byte(`{"kind":"order","user":{"id":42,"vip":true}}`)
+		data, err := encodeMessage(msg)
+		So(err, ShouldBeNil)
+		for expr, match := range map[string]bool{
+			"type=application/json":                     true,
+			"type=text/csv":                             false,
+			"header.producer=tester":                    true,
+			"header.producer=other":                     false,
+			"prefix={\"kind\"":                          true,
+			"json.kind=order":                           true,
+			"json.user.id=42":                           true,
+			"json.user.vip=true&header.producer=tester": true,
+			"json.user.vip=false":                       false,
+			"json.user.name=a":                          false,
+			"json.kind.id=1":                            false,
+		} {
+			f, err := parseFilter(expr)
+			So(err, ShouldBeNil)
+			So(f.match(data), ShouldEqual, match)
+		}
+
+		// a raw message has only body
+		f, err = parseFilter("prefix=ord")
+		So(err, ShouldBeNil)
+		So(f.match([]byte("order")), ShouldBeTrue)
+		f, err = parseFilter("json.kind=order")
+		So(err, ShouldBeNil)
+		So(f.match([]byte("order")), ShouldBeFalse)
+	})
+}
+
+func TestLineConfigFilter(t *testing.T) {
+	Convey("Test Line Config With Filter", t, func() {
+		// the filter takes the rest of arg so it can contain ","
+		lc, err := parseLineConfig("10s,attempts=2,filter=prefix=a,b&type=text/csv")
+		So(err, ShouldBeNil)
+		So(lc.attempts, ShouldEqual, 2)
+		So(lc.filter.String(), ShouldEqual, "prefix=a,b&type=text/csv")
+
+		_, err = parseLineConfig("10s,filter=type=text/csv,attempts=2")
+		So(err, ShouldBeNil)
+		_, err = parseLineConfig("10s,filter=header.a=1,attempts")
+		So(err, ShouldBeNil)
+		_, err = parseLineConfig("10s,filter=a,attempts=2")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// filterErrStore fails to read the filters of lines
+type filterErrStore struct {
+	store.Storage
+}
+
+func (s *filterErrStore) Get(key string) ([]byte, error) {
+	if strings.HasSuffix(key, keyLineFilter) {
+		return nil, errors.New("read error")
+	}
+	return s.Storage.Get(key)
+}
+
+func (s *filterErrStore) MultiGet(keys []string) ([][]byte, error) {
+	for _, key := range keys {
+		if strings.HasSuffix(key, keyLineFilter) {
+			return nil, errors.New("read error")
+		}
+	}
+	return s.Storage.MultiGet(keys)
+}
+
+func TestLineFilter(t *testing.T) {
+	Convey("Test Line With Filter", t, func() {
+		logPath := "/tmp/uq.queue.test.filter"
+		os.RemoveAll(logPath)
+		defer os.RemoveAll(logPath)
+
+		lsdb, err := store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err := NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		So(uq.Create("ft", ""), ShouldBeNil)
+		So(uq.Create("ft/x", "10s,filter=header.kind=order"), ShouldBeNil)
+		So(uq.Create("ft/y", ",filter=prefix=b"), ShouldBeNil)
+		So(uq.Create("ft/z", ",filter=size=1"), ShouldNotBeNil)
+
+		for i, body := range []string{"a0", "b1", "a2", "b3", "a4", "b5"} {
+			msg := new(Message)
+			if i%2 == 0 {
+				msg.SetHeader("kind", "order")
+			}
+			msg.Body = []byte(body)
+			_, err := uq.PushMessage("ft", msg, nil)
+			So(err, ShouldBeNil)
+		}
+
+		id, data, err := uq.Pop("ft/x")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "ft/x/0")
+		So(string(data), ShouldEqual, "a0")
+		ids, _, err := uq.MultiPop("ft/y", 2)
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []string{"ft/y/1", "ft/y/3"})
+
+		ls, err := uq.Stat("ft/x")
+		So(err, ShouldBeNil)
+		So(ls.Filter, ShouldEqual, "header.kind=order")
+		So(ls.Head, ShouldEqual, 1)
+		uq.Close()
+
+		// the filter is loaded with the line
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		ids, _, err = uq.MultiPop("ft/x", 5)
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []string{"ft/x/2", "ft/x/4"})
+		_, _, err = uq.Pop("ft/x")
+		So(err, ShouldNotBeNil)
+		id, _, err = uq.Pop("ft/y")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "ft/y/5")
+
+		// the messages passed by filter still move head
+		ls, err = uq.Stat("ft/x")
+		So(err, ShouldBeNil)
+		So(ls.Head, ShouldEqual, 6)
+		So(ls.Inflight, ShouldEqual, 3)
+
+		So(uq.Remove("ft/x"), ShouldBeNil)
+		_, err = lsdb.Get("ft/x" + keyLineFilter)
+		So(err, ShouldNotBeNil)
+	
+		uq.Close()
+
+		// a line is not loaded without its filter
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		_, err = NewUnitedQueue(&filterErrStore{lsdb}, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldNotBeNil)
+		lsdb.Close()
+	})
+}
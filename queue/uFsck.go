@@ -0,0 +1,452 @@
+package queue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/buaazp/uq/store"
+	"github.com/buaazp/uq/utils"
+)
+
+// FsckProblem is an inconsistency found in the storage of queue
+type FsckProblem struct {
+	Key      string
+	Problem  string
+	Repaired bool
+}
+
+func (p *FsckProblem) String() string {
+	if p.Repaired {
+		return p.Key + ": " + p.Problem + " (repaired)"
+	}
+	return p.Key + ": " + p.Problem
+}
+
+// FsckReport is the result of checking the storage of queue
+type FsckReport struct {
+	Topics   int
+	Lines    int
+	Messages uint64
+	Problems []*FsckProblem
+}
+
+// Unrepaired returns the number of problems not repaired
+func (r *FsckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+type fsck struct {
+	storage store.Storage
+	repair  bool
+	report  *FsckReport
+}
+
+// Fsck walks all the keys written by queue in storage and reports the
+// inconsistencies, they are repaired if repair is true. The storage should
+// not be used by a running queue.
+func Fsck(storage store.Storage, repair bool) (*FsckReport, error) {
+	f := &fsck{
+		storage: storage,
+		repair:  repair,
+		report:  new(FsckReport),
+	}
+	err := f.checkQueue()
+	if err != nil {
+		return nil, err
+	}
+	return f.report, nil
+}
+
+// problem adds a problem to report, it is repaired if the repair is on
+func (f *fsck) problem(key, format string, args ...interface{}) bool {
+	f.report.Problems = append(f.report.Problems, &FsckProblem{
+		Key:      key,
+		Problem:  fmt.Sprintf(format, args...),
+		Repaired: f.repair,
+	})
+	return f.repair
+}
+
+// unrepairable adds a problem which can not be repaired to report
+func (f *fsck) unrepairable(key, format string, args ...interface{}) {
+	f.report.Problems = append(f.report.Problems, &FsckProblem{
+		Key:     key,
+		Problem: fmt.Sprintf(format, args...),
+	})
+}
+
+func (f *fsck) set(keys []string, datas [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	err := f.storage.MultiSet(keys, datas)
+	if err != nil {
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	return nil
+}
+
+func (f *fsck) setStore(key string, m interface {
+	Marshal() ([]byte, error)
+}) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	return f.set([]string{key}, [][]byte{data})
+}
+
+func (f *fsck) setUint64(key string, n uint64) error {
+	return f.set([]string{key}, [][]byte{uint64Data(n)})
+}
+
+// getUint64 gets an uint64 key, ok is false if it is missing or broken
+func (f *fsck) getUint64(key string) (uint64, bool) {
+	data, err := f.storage.Get(key)
+	if err != nil || len(data) != 8 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(data), true
+}
+
+func (f *fsck) exists(key string) bool {
+	data, err := f.storage.Get(key)
+	return err == nil && data != nil
+}
+
+func (f *fsck) checkQueue() error {
+	data, err := f.storage.Get(storageKeyWord)
+	if err != nil || len(data) == 0 {
+		// nothing is saved by queue
+		return nil
+	}
+	var qs UnitedQueueStore
+	err = qs.Unmarshal(data)
+	if err != nil {
+		f.unrepairable(storageKeyWord, "queue store is broken: %s", err)
+		return nil
+	}
+
+	topics := make([]string, 0, len(qs.Topics))
+	for _, name := range qs.Topics {
+		keep, err := f.checkTopic(name)
+		if err != nil {
+			return err
+		}
+		if keep {
+			topics = append(topics, name)
+		}
+	}
+	if len(topics) == len(qs.Topics) {
+		return nil
+	}
+	qs.Topics = topics
+	return f.setStore(storageKeyWord, &qs)
+}
+
+// checkTopic checks a topic and its lines and messages, keep is false if
+// the topic is dropped from the queue
+func (f *fsck) checkTopic(name string) (keep bool, err error) {
+	data, err := f.storage.Get(name)
+	if err != nil || len(data) == 0 {
+		return !f.problem(name, "topic store is missing, topic is dropped"), nil
+	}
+	var ts UnitedTopicStore
+	err = ts.Unmarshal(data)
+	if err != nil {
+		return !f.problem(name, "topic store is broken, topic is dropped: %s", err), nil
+	}
+	f.report.Topics++
+
+	headKey := name + keyTopicHead
+	tailKey := name + keyTopicTail
+	head, headOk := f.getUint64(headKey)
+	tail, tailOk := f.getUint64(tailKey)
+	oldHead, oldTail := head, tail
+	if !headOk {
+		f.problem(headKey, "topic head is missing")
+	}
+	if !tailOk {
+		f.problem(tailKey, "topic tail is missing")
+		tail = head
+	}
+	if head > tail {
+		f.problem(headKey, "topic head %d is beyond tail %d", head, tail)
+		head = tail
+	}
+
+	// the messages pushed while the tail was not saved
+	end := tail
+	for f.exists(utils.Acatui(name, ":", end)) {
+		end++
+	}
+	if end > tail {
+		f.problem(tailKey, "messages [%d, %d) are beyond tail", tail, end)
+		tail = end
+	}
+
+	head, tail, err = f.checkMessages(name, head, tail)
+	if err != nil {
+		return false, err
+	}
+	f.report.Messages += tail - head
+
+	if f.repair {
+		if !headOk || head != oldHead {
+			err = f.setUint64(headKey, head)
+			if err != nil {
+				return false, err
+			}
+		}
+		if !tailOk || tail != oldTail {
+			err = f.setUint64(tailKey, tail)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(ts.Lines))
+	for _, line := range ts.Lines {
+		keep, err := f.checkLine(name, line, head, tail)
+		if err != nil {
+			return false, err
+		}
+		if keep {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != len(ts.Lines) {
+		ts.Lines = lines
+		err = f.setStore(name, &ts)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// a topic can not be loaded without any of its priority levels
+	for p := 1; p < int(ts.Priorities); p++ {
+		keep, err := f.checkTopic(levelName(name, p))
+		if err != nil || !keep {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// checkMessages finds the missing messages in [head, tail), the missing
+// ones at both ends are cut off by moving head and tail
+func (f *fsck) checkMessages(name string, head, tail uint64) (uint64, uint64, error) {
+	var missing []uint64
+	for start := head; start < tail; start += cleanBatchSize {
+		end := start + cleanBatchSize
+		if end > tail {
+			end = tail
+		}
+		keys := make([]string, 0, end-start)
+		for id := start; id < end; id++ {
+			keys = append(keys, utils.Acatui(name, ":", id))
+		}
+		datas, err := f.storage.MultiGet(keys)
+		if err != nil {
+			return 0, 0, utils.NewError(
+				utils.ErrInternalError,
+				err.Error(),
+			)
+		}
+		for i, data := range datas {
+			if data == nil {
+				missing = append(missing, start+uint64(i))
+			}
+		}
+	}
+
+	first := head
+	for len(missing) > 0 && missing[0] == first {
+		missing = missing[1:]
+		first++
+	}
+	if first > head {
+		f.problem(name+keyTopicHead, "messages [%d, %d) at head are missing", head, first)
+	}
+	last := tail
+	for len(missing) > 0 && missing[len(missing)-1] == last-1 {
+		missing = missing[:len(missing)-1]
+		last--
+	}
+	if last < tail {
+		f.problem(name+keyTopicTail, "tail %d points past missing messages [%d, %d)", tail, last, tail)
+	}
+	if len(missing) > 0 {
+		f.unrepairable(name, "%d messages in [%d, %d) are missing", len(missing), missing[0], missing[len(missing)-1]+1)
+	}
+	return first, last, nil
+}
+
+// checkLine checks a line against the head and tail of its topic, keep is
+// false if the line is dropped from the topic
+func (f *fsck) checkLine(topicName, lineName string, head, tail uint64) (keep bool, err error) {
+	key := topicName + "/" + lineName
+	data, err := f.storage.Get(key)
+	if err != nil || len(data) == 0 {
+		return !f.problem(key, "line store is missing, line is dropped"), nil
+	}
+	var ls UnitedLineStore
+	err = ls.Unmarshal(data)
+	if err != nil {
+		return !f.problem(key, "line store is broken, line is dropped: %s", err), nil
+	}
+	f.report.Lines++
+
+	recycleKey := key + keyLineRecycle
+	recycleData, err := f.storage.Get(recycleKey)
+	if err == nil {
+		_, err = time.ParseDuration(string(recycleData))
+	}
+	if err != nil && f.problem(recycleKey, "line recycle is missing or broken, it is reset to 0s") {
+		err = f.set([]string{recycleKey}, [][]byte{[]byte(time.Duration(0).String())})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// a line without filter data delivers all the messages
+	filterKey := key + keyLineFilter
+	filterData, err := f.storage.Get(filterKey)
+	if err == nil && len(filterData) > 0 {
+		_, err = parseFilter(string(filterData))
+		if err != nil && f.problem(filterKey, "line filter is broken, it is removed: %s", err) {
+			err = f.storage.Del(filterKey)
+			if err != nil {
+				return false, utils.NewError(
+					utils.ErrInternalError,
+					err.Error(),
+				)
+			}
+		}
+	}
+
+	// the head and inflights in journal are newer than the line store
+	problems := len(f.report.Problems)
+	journalKey := key + keyLineHead
+	lineHead, journaled := f.getUint64(journalKey)
+	if !journaled {
+		lineHead = ls.Head
+	}
+	newHead := lineHead
+	if newHead > tail {
+		f.problem(key, "line head %d is beyond topic tail %d", newHead, tail)
+		newHead = tail
+	}
+	if newHead < head {
+		f.problem(key, "line head %d is before topic head %d", newHead, head)
+		newHead = head
+	}
+	valid := func(tid uint64) bool {
+		return tid >= head && tid < newHead
+	}
+
+	var keys []string
+	var datas [][]byte
+	ihead := newHead
+	if journaled {
+		keys, err = f.checkJournal(key, ls.Ihead, lineHead, valid)
+		if err != nil {
+			return false, err
+		}
+		for range keys {
+			datas = append(datas, []byte{})
+		}
+		if newHead != lineHead {
+			keys = append(keys, journalKey)
+			datas = append(datas, uint64Data(newHead))
+		}
+		ihead = ls.Ihead
+		if ihead < head {
+			ihead = head
+		}
+		if ihead > newHead {
+			ihead = newHead
+		}
+	} else {
+		inflights := make([]*InflightMessage, 0, len(ls.Inflights))
+		for _, msg := range ls.Inflights {
+			if !valid(msg.Tid) {
+				f.problem(key, "inflight message %d is out of line, it is dropped", msg.Tid)
+				continue
+			}
+			inflights = append(inflights, msg)
+			if msg.Tid < ihead {
+				ihead = msg.Tid
+			}
+		}
+		ls.Inflights = inflights
+	}
+
+	if !f.repair || len(f.report.Problems) == problems {
+		return true, nil
+	}
+	ls.Head = newHead
+	ls.Ihead = ihead
+	data, err = ls.Marshal()
+	if err != nil {
+		return false, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	keys = append(keys, key)
+	datas = append(datas, data)
+	return true, f.set(keys, datas)
+}
+
+// checkJournal checks the inflight keys of a line in [ihead, head), the
+// keys of the broken or invalid messages are returned to be landed
+func (f *fsck) checkJournal(key string, ihead, head uint64, valid func(uint64) bool) ([]string, error) {
+	var bad []string
+	for start := ihead; start < head; start += cleanBatchSize {
+		end := start + cleanBatchSize
+		if end > head {
+			end = head
+		}
+		keys := make([]string, 0, end-start)
+		for tid := start; tid < end; tid++ {
+			keys = append(keys, utils.Acatui(key+keyLineInflight, ":", tid))
+		}
+		datas, err := f.storage.MultiGet(keys)
+		if err != nil {
+			return nil, utils.NewError(
+				utils.ErrInternalError,
+				err.Error(),
+			)
+		}
+		for i, data := range datas {
+			if len(data) == 0 {
+				continue
+			}
+			var msg InflightMessage
+			if msg.Unmarshal(data) != nil {
+				f.problem(keys[i], "inflight message is broken, it is dropped")
+			} else if !valid(msg.Tid) {
+				f.problem(keys[i], "inflight message %d is out of line, it is dropped", msg.Tid)
+			} else {
+				continue
+			}
+			bad = append(bad, keys[i])
+		}
+	}
+	return bad, nil
+}
@@ -0,0 +1,90 @@
+package queue
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/buaazp/uq/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFsck(t *testing.T) {
+	Convey("Test Fsck Storage Of Queue", t, func() {
+		logPath := "/tmp/uq.queue.test.fsck"
+		os.RemoveAll(logPath)
+		defer os.RemoveAll(logPath)
+
+		lsdb, err := store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err := NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		So(uq.Create("fk", ""), ShouldBeNil)
+		So(uq.Create("fk/x", "10s"), ShouldBeNil)
+		So(uq.Create("fk/y", ""), ShouldBeNil)
+		So(uq.Create("fk/z", ""), ShouldBeNil)
+		for i := 0; i < 5; i++ {
+			_, err = uq.Push("fk", []byte(strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+		}
+		_, _, err = uq.Pop("fk/x")
+		So(err, ShouldBeNil)
+		uq.Close()
+
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		report, err := Fsck(lsdb, false)
+		So(err, ShouldBeNil)
+		So(report.Topics, ShouldEqual, 1)
+		So(report.Lines, ShouldEqual, 3)
+		So(report.Messages, ShouldEqual, 5)
+		So(len(report.Problems), ShouldEqual, 0)
+
+		// a message pushed without its tail, a message cleaned without
+		// the head, a line head beyond the tail and a missing line
+		So(lsdb.Set("fk:5", []byte("5")), ShouldBeNil)
+		So(lsdb.DelRange("fk:", 0, 1), ShouldBeNil)
+		So(lsdb.Set("fk/y"+keyLineHead, uint64Data(100)), ShouldBeNil)
+		So(lsdb.Del("fk/z"), ShouldBeNil)
+		So(lsdb.Del("fk/x"+keyLineRecycle), ShouldBeNil)
+
+		report, err = Fsck(lsdb, false)
+		So(err, ShouldBeNil)
+		So(len(report.Problems), ShouldEqual, 6)
+		So(report.Unrepaired(), ShouldEqual, 6)
+		report, err = Fsck(lsdb, true)
+		So(err, ShouldBeNil)
+		So(len(report.Problems), ShouldEqual, 6)
+		So(report.Unrepaired(), ShouldEqual, 0)
+		report, err = Fsck(lsdb, false)
+		So(err, ShouldBeNil)
+		So(len(report.Problems), ShouldEqual, 0)
+		So(report.Lines, ShouldEqual, 2)
+
+		// a message lost in the middle can not be repaired
+		So(lsdb.DelRange("fk:", 3, 4), ShouldBeNil)
+		report, err = Fsck(lsdb, true)
+		So(err, ShouldBeNil)
+		So(len(report.Problems), ShouldEqual, 1)
+		So(report.Unrepaired(), ShouldEqual, 1)
+		So(report.Problems[0].String(), ShouldContainSubstring, "1 messages in [3, 4) are missing")
+
+		uq, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer uq.Close()
+		qs, err := uq.Stat("fk")
+		So(err, ShouldBeNil)
+		So(qs.Head, ShouldEqual, 1)
+		So(qs.Tail, ShouldEqual, 6)
+		So(len(qs.Lines), ShouldEqual, 2)
+		ls, err := uq.Stat("fk/y")
+		So(err, ShouldBeNil)
+		So(ls.Head, ShouldEqual, 6)
+		ls, err = uq.Stat("fk/x")
+		So(err, ShouldBeNil)
+		So(ls.Inflight, ShouldEqual, 0)
+		_, data, err := uq.Pop("fk/x")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "1")
+	})
+}
@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// A line with recycle time delivers the messages of a group one by one.
+// The inflight messages of a group are kept in push order, the first one
+// is delivered and the others are parked at the end of inflight list until
+// the ones before them leave the line.
+
+// parkedExptime is the exptime of a parked message, it never expires
+const parkedExptime int64 = math.MaxInt64
+
+// grouping returns whether the line keeps the messages of a group in order,
+// a line without recycle time forgets a message once it is popped
+func (l *line) grouping() bool {
+	return l.recycle > 0
+}
+
+// joinGroup adds a message which goes into inflight list to its group, it
+// should be parked if an earlier message of the group is still inflight
+func (l *line) joinGroup(tid uint64, group string) (parked bool) {
+	if group == "" || !l.grouping() {
+		return false
+	}
+	tids := l.groups[group]
+	l.groups[group] = append(tids, tid)
+	l.grouped[tid] = group
+	return len(tids) > 0
+}
+
+// ungroup removes a message from its group, the next message of the group
+// is returned if the removed one was the first
+func (l *line) ungroup(tid uint64) (next uint64, ok bool) {
+	group, grouped := l.grouped[tid]
+	if !grouped {
+		return 0, false
+	}
+	delete(l.grouped, tid)
+
+	tids := l.groups[group]
+	first := len(tids) > 0 && tids[0] == tid
+	for i, id := range tids {
+		if id == tid {
+			tids = append(tids[:i], tids[i+1:]...)
+			break
+		}
+	}
+	if len(tids) == 0 {
+		delete(l.groups, group)
+		return 0, false
+	}
+	l.groups[group] = tids
+	return tids[0], first
+}
+
+// leaveGroup removes a message which leaves inflight list from its group
+// and releases the next message of the group
+func (l *line) leaveGroup(tid uint64, now time.Time, j *lineJournal) error {
+	next, ok := l.ungroup(tid)
+	if !ok {
+		return nil
+	}
+	return l.release(next, now, j)
+}
+
+// release makes a parked message due so that it is popped next, a delayed
+// message is still not popped before its deliver time
+func (l *line) release(tid uint64, now time.Time, j *lineJournal) error {
+	for m := l.inflight.Back(); m != nil; m = m.Prev() {
+		msg := m.Value.(*InflightMessage)
+		if msg.Tid != tid {
+			continue
+		}
+		if msg.Exptime != parkedExptime {
+			return nil
+		}
+		next := *msg
+		next.Exptime = now.UnixNano()
+		if deliver := l.t.getDelay(tid); deliver > next.Exptime {
+			next.Exptime = deliver
+		}
+		err := j.flight(&next)
+		if err != nil {
+			return err
+		}
+		l.inflight.Remove(m)
+		msg.Exptime = next.Exptime
+		l.pushInflight(msg)
+		return nil
+	}
+	return nil
+}
+
+// dropGroups removes the messages which do not go into inflight list
+// because their journal is not written
+func (l *line) dropGroups(msgs ...[]*InflightMessage) {
+	for _, ms := range msgs {
+		for _, msg := range ms {
+			l.ungroup(msg.Tid)
+		}
+	}
+}
+
+// parked returns the number of parked messages
+func (l *line) parked() uint64 {
+	return uint64(len(l.grouped) - len(l.groups))
+}
+
+// loadGroups rebuilds the groups of inflight messages of a loaded line,
+// the first message of a group is released if it is still parked
+func (l *line) loadGroups() error {
+	l.groups = make(map[string][]uint64)
+	l.grouped = make(map[uint64]string)
+	if !l.grouping() || l.inflight.Len() == 0 {
+		return nil
+	}
+
+	tids := make([]uint64, 0, l.inflight.Len())
+	for m := l.inflight.Front(); m != nil; m = m.Next() {
+		tids = append(tids, m.Value.(*InflightMessage).Tid)
+	}
+	sort.Sort(byTid(tids))
+	datas, err := l.t.getDatas(tids)
+	if err != nil {
+		return err
+	}
+	for i, tid := range tids {
+		if datas[i] != nil {
+			l.joinGroup(tid, decodeMessage(datas[i]).Group)
+		}
+	}
+
+	now := time.Now()
+	j := l.newJournal()
+	for _, tids := range l.groups {
+		err = l.release(tids[0], now, j)
+		if err != nil {
+			return err
+		}
+	}
+	return j.commit()
+}
+
+type byTid []uint64
+
+func (a byTid) Len() int           { return len(a) }
+func (a byTid) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTid) Less(i, j int) bool { return a[i] < a[j] }
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buaazp/uq/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func pushGroup(uq *UnitedQueue, key, group, body string) (string, error) {
+	msg := new(Message)
+	msg.Group = group
+	msg.Body = []byte(body)
+	return uq.PushMessage(key, msg, nil)
+}
+
+func TestGroups(t *testing.T) {
+	Convey("Test Ordered Message Groups", t, func() {
+		logPath := "/tmp/uq.queue.test.groups"
+		os.RemoveAll(logPath)
+		defer os.RemoveAll(logPath)
+
+		lsdb, err := store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err := NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		So(uq.Create("gp", ""), ShouldBeNil)
+		So(uq.Create("gp/x", "10s"), ShouldBeNil)
+		So(uq.Create("gp/y", ""), ShouldBeNil)
+
+		for _, c := range []struct{ group, body string }{
+			{"a", "a1"},
+			{"a", "a2"},
+			{"b", "b1"},
+			{"", "n1"},
+			{"a", "a3"},
+			{"b", "b2"},
+		} {
+			_, err := pushGroup(uq, "gp", c.group, c.body)
+			So(err, ShouldBeNil)
+		}
+
+		// only the first message of a group is inflight
+		id, msg, err := uq.PopMessage("gp/x", 0)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "gp/x/0")
+		So(msg.Group, ShouldEqual, "a")
+		ids, _, err := uq.MultiPop("gp/x", 5)
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []string{"gp/x/2", "gp/x/3"})
+		_, _, err = uq.Pop("gp/x")
+		So(err, ShouldNotBeNil)
+
+		ls, err := uq.Stat("gp/x")
+		So(err, ShouldBeNil)
+		So(ls.Parked, ShouldEqual, 3)
+		So(ls.Head, ShouldEqual, 6)
+
+		// a parked message is not delivered yet
+		So(uq.Confirm("gp/x/1"), ShouldNotBeNil)
+		So(uq.Nack("gp/x/4"), ShouldNotBeNil)
+
+		// a line without recycle does not keep the groups
+		ids, _, err = uq.MultiPop("gp/y", 10)
+		So(err, ShouldBeNil)
+		So(len(ids), ShouldEqual, 6)
+
+		// the next message of group is released when the first leaves
+		So(uq.Confirm("gp/x/0"), ShouldBeNil)
+		id, data, err := uq.Pop("gp/x")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "gp/x/1")
+		So(string(data), ShouldEqual, "a2")
+		_, _, err = uq.Pop("gp/x")
+		So(err, ShouldNotBeNil)
+
+		// an expired message keeps its place in group
+		So(uq.Nack("gp/x/2"), ShouldBeNil)
+		id, _, err = uq.Pop("gp/x")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "gp/x/2")
+		So(uq.Confirm("gp/x/2"), ShouldBeNil)
+		uq.Close()
+
+		// the groups are rebuilt from the inflight messages after restart
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer uq.Close()
+		ls, err = uq.Stat("gp/x")
+		So(err, ShouldBeNil)
+		So(ls.Parked, ShouldEqual, 1)
+		id, _, err = uq.Pop("gp/x")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "gp/x/5")
+		_, _, err = uq.Pop("gp/x")
+		So(err, ShouldNotBeNil)
+
+		So(uq.Confirm("gp/x/1"), ShouldBeNil)
+		time.Sleep(time.Millisecond)
+		id, data, err = uq.Pop("gp/x")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "gp/x/4")
+		So(string(data), ShouldEqual, "a3")
+
+		So(uq.Empty("gp/x"), ShouldBeNil)
+		ls, err = uq.Stat("gp/x")
+		So(err, ShouldBeNil)
+		So(ls.Parked, ShouldEqual, 0)
+	})
+}
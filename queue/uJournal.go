@@ -0,0 +1,203 @@
+package queue
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/buaazp/uq/utils"
+)
+
+// The journal of a line keeps its state between two backups of line. The
+// head of line is saved in the head key and each inflight message in its
+// own inflight key, they are written in one batch with the change of line
+// so a pop, confirm or recycle is durable once it returns. A message which
+// leaves inflight is marked by an empty value, and these keys below the
+// inflight head are removed when the line is backed up.
+
+// lineJournal collects the changes of a line to be written at once
+type lineJournal struct {
+	l     *line
+	keys  []string
+	datas [][]byte
+}
+
+func (l *line) headKey() string {
+	return l.t.name + "/" + l.name + keyLineHead
+}
+
+func (l *line) inflightPrefix() string {
+	return l.t.name + "/" + l.name + keyLineInflight + ":"
+}
+
+func (l *line) inflightKey(tid uint64) string {
+	return utils.Acatui(l.t.name+"/"+l.name+keyLineInflight, ":", tid)
+}
+
+func (l *line) newJournal() *lineJournal {
+	j := new(lineJournal)
+	j.l = l
+	return j
+}
+
+// setHead records the new head of line
+func (j *lineJournal) setHead(head uint64) {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, head)
+	j.keys = append(j.keys, j.l.headKey())
+	j.datas = append(j.datas, data)
+}
+
+// flight records a message which is put into or moved in inflight list
+func (j *lineJournal) flight(msg *InflightMessage) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	j.keys = append(j.keys, j.l.inflightKey(msg.Tid))
+	j.datas = append(j.datas, data)
+	return nil
+}
+
+// land records a message which leaves inflight list
+func (j *lineJournal) land(tid uint64) {
+	j.keys = append(j.keys, j.l.inflightKey(tid))
+	j.datas = append(j.datas, []byte{})
+}
+
+// commit writes all the changes in one batch
+func (j *lineJournal) commit() error {
+	if len(j.keys) == 0 {
+		return nil
+	}
+	err := j.l.t.q.multiSetData(j.keys, j.datas)
+	j.keys = nil
+	j.datas = nil
+	return err
+}
+
+// pruneJournal removes the inflight keys below the inflight head of line,
+// it is called with the write lock of inflight held
+func (l *line) pruneJournal() error {
+	if l.pruned >= l.ihead {
+		return nil
+	}
+	err := l.t.q.delRangeData(l.inflightPrefix(), l.pruned, l.ihead)
+	if err != nil {
+		return err
+	}
+	l.pruned = l.ihead
+	return nil
+}
+
+// resetJournal saves the head of a line which is moved by seek or empty
+// and removes the inflight keys of the dropped inflight messages. The head
+// is saved first so that no message is lost if uq crashes between them.
+func (l *line) resetJournal(oldIhead, oldHead uint64) error {
+	j := l.newJournal()
+	j.setHead(l.head)
+	err := j.commit()
+	if err != nil {
+		return err
+	}
+
+	if oldHead > oldIhead {
+		err = l.t.q.delRangeData(l.inflightPrefix(), oldIhead, oldHead)
+		if err != nil {
+			return err
+		}
+	}
+	if l.pruned > l.ihead {
+		l.pruned = l.ihead
+	}
+	return nil
+}
+
+// loadJournal applies the journal on the line loaded from its backup
+func (l *line) loadJournal() error {
+	l.pruned = l.ihead
+	headData, err := l.t.q.getData(l.headKey())
+	if err != nil || len(headData) != 8 {
+		// the line is saved by an older uq without journal, start the
+		// journal with the state in backup
+		j := l.newJournal()
+		for m := l.inflight.Front(); m != nil; m = m.Next() {
+			msg := m.Value.(*InflightMessage)
+			// an older uq may not count the attempts, its inflight
+			// messages without delay have been delivered
+			if msg.Attempts == 0 && l.recycle > 0 && l.t.getDelay(msg.Tid) == 0 {
+				msg.Attempts = 1
+			}
+			err = j.flight(msg)
+			if err != nil {
+				return err
+			}
+		}
+		j.setHead(l.head)
+		return j.commit()
+	}
+	l.head = binary.LittleEndian.Uint64(headData)
+	if l.ihead > l.head {
+		l.ihead = l.head
+	}
+	l.pruned = l.ihead
+
+	var inflights []*InflightMessage
+	for start := l.ihead; start < l.head; start += cleanBatchSize {
+		end := start + cleanBatchSize
+		if end > l.head {
+			end = l.head
+		}
+		keys := make([]string, 0, end-start)
+		for tid := start; tid < end; tid++ {
+			keys = append(keys, l.inflightKey(tid))
+		}
+		datas, err := l.t.q.multiGetData(keys)
+		if err != nil {
+			return err
+		}
+		for _, data := range datas {
+			if len(data) == 0 {
+				continue
+			}
+			msg := new(InflightMessage)
+			err = msg.Unmarshal(data)
+			if err != nil {
+				return utils.NewError(
+					utils.ErrInternalError,
+					err.Error(),
+				)
+			}
+			inflights = append(inflights, msg)
+		}
+	}
+	sort.Stable(byExptime(inflights))
+
+	l.imap = make(map[uint64]bool)
+	l.inflight.Init()
+	for _, msg := range inflights {
+		l.inflight.PushBack(msg)
+		l.imap[msg.Tid] = true
+	}
+	l.updateiHead()
+	return nil
+}
+
+// removeJournal removes all the journal keys of a removed line
+func (l *line) removeJournal() error {
+	if l.head > l.pruned {
+		err := l.t.q.delRangeData(l.inflightPrefix(), l.pruned, l.head)
+		if err != nil {
+			return err
+		}
+	}
+	return l.t.q.delData(l.headKey())
+}
+
+type byExptime []*InflightMessage
+
+func (a byExptime) Len() int           { return len(a) }
+func (a byExptime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byExptime) Less(i, j int) bool { return a[i].Exptime < a[j].Exptime }
@@ -0,0 +1,218 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buaazp/uq/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// crashStore is a storage which crashes after a number of writes. The write
+// which crashes is broken: a batch is only partly written. The writes after
+// it are all lost, and closing it leaves the storage as it is.
+type crashStore struct {
+	store.Storage
+	mu   sync.Mutex
+	left int
+}
+
+var errCrashed = errors.New("store crashed")
+
+// write reports whether a write can be done, or the part of a batch to
+// be written when the store crashes
+func (c *crashStore) write(n int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.left < 0 {
+		return n, nil
+	}
+	if c.left == 0 {
+		return 0, errCrashed
+	}
+	c.left--
+	if c.left == 0 {
+		return n / 2, errCrashed
+	}
+	return n, nil
+}
+
+func (c *crashStore) crashAfter(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.left = n
+}
+
+func (c *crashStore) Set(key string, data []byte) error {
+	n, err := c.write(1)
+	if n == 1 {
+		return c.Storage.Set(key, data)
+	}
+	return err
+}
+
+func (c *crashStore) Del(key string) error {
+	n, err := c.write(1)
+	if n == 1 {
+		return c.Storage.Del(key)
+	}
+	return err
+}
+
+func (c *crashStore) MultiSet(keys []string, datas [][]byte) error {
+	n, err := c.write(len(keys))
+	if n > 0 {
+		e := c.Storage.MultiSet(keys[:n], datas[:n])
+		if e != nil {
+			return e
+		}
+	}
+	return err
+}
+
+func (c *crashStore) DelRange(prefix string, start, end uint64) error {
+	n, err := c.write(1)
+	if n == 1 {
+		return c.Storage.DelRange(prefix, start, end)
+	}
+	return err
+}
+
+func (c *crashStore) Close() error {
+	return nil
+}
+
+// crashClient is what a client knows after the store crashes
+type crashClient struct {
+	pushed    []string
+	delivered map[uint64]bool
+	confirmed map[uint64]bool
+	// confirming is the message whose confirm is broken by the crash
+	confirming uint64
+}
+
+func popID(key string) uint64 {
+	id, _ := strconv.ParseUint(key[strings.LastIndex(key, "/")+1:], 10, 64)
+	return id
+}
+
+// run runs the operations until the store crashes
+func (c *crashClient) run(uq *UnitedQueue) bool {
+	c.delivered = make(map[uint64]bool)
+	c.confirmed = make(map[uint64]bool)
+	c.confirming = ^uint64(0)
+
+	push := func(n int) bool {
+		for i := 0; i < n; i++ {
+			data := "msg " + strconv.Itoa(len(c.pushed))
+			if _, err := uq.Push("crash", []byte(data)); err != nil {
+				return false
+			}
+			c.pushed = append(c.pushed, data)
+		}
+		return true
+	}
+	pop := func(n int) bool {
+		keys, _, err := uq.MultiPop("crash/x", n)
+		if err != nil {
+			return false
+		}
+		for _, key := range keys {
+			c.delivered[popID(key)] = true
+		}
+		return true
+	}
+	confirm := func(id uint64) bool {
+		c.confirming = id
+		if uq.Confirm("crash/x/"+strconv.FormatUint(id, 10)) != nil {
+			return false
+		}
+		c.confirmed[id] = true
+		return true
+	}
+
+	if !push(6) || !pop(1) || !pop(1) || !confirm(0) || !pop(3) {
+		return false
+	}
+	if _, err := uq.MultiPush("crash", [][]byte{[]byte("msg 6"), []byte("msg 7")}); err != nil {
+		return false
+	}
+	c.pushed = append(c.pushed, "msg 6", "msg 7")
+	if !confirm(3) || !confirm(1) || !pop(2) || !confirm(5) {
+		return false
+	}
+	return true
+}
+
+// check checks the queue loaded after crash: no pushed message is lost, the
+// delivered messages which are not confirmed will be delivered again, and
+// the confirmed ones never
+func (c *crashClient) check(uq *UnitedQueue) {
+	t := uq.topics["crash"]
+	So(t, ShouldNotBeNil)
+	So(t.tail, ShouldBeGreaterThanOrEqualTo, len(c.pushed))
+	for i, data := range c.pushed {
+		got, err := t.getData(uint64(i))
+		So(err, ShouldBeNil)
+		So(string(decodeMessage(got).Body), ShouldEqual, data)
+	}
+
+	l := t.lines["x"]
+	So(l, ShouldNotBeNil)
+	inflight := make(map[uint64]bool)
+	for m := l.inflight.Front(); m != nil; m = m.Next() {
+		inflight[m.Value.(*InflightMessage).Tid] = true
+	}
+	for id := range c.delivered {
+		So(id, ShouldBeLessThan, l.head)
+		So(inflight[id], ShouldEqual, !c.confirmed[id])
+	}
+	for id := uint64(0); id < l.head; id++ {
+		if !inflight[id] {
+			So(c.confirmed[id] || c.confirming == id, ShouldBeTrue)
+		}
+	}
+	So(l.ihead, ShouldBeLessThanOrEqualTo, l.head)
+}
+
+func TestCrashRecovery(t *testing.T) {
+	Convey("Test Recovery After Store Crashes", t, func() {
+		logPath := "/tmp/uq.queue.test.crash"
+		defer os.RemoveAll(logPath)
+
+		for n := 0; ; n++ {
+			os.RemoveAll(logPath)
+			lsdb, err := store.NewLogStore(logPath)
+			So(err, ShouldBeNil)
+			cs := &crashStore{Storage: lsdb, left: -1}
+			uq, err := NewUnitedQueue(cs, "127.0.0.1", 9689, nil, "uq")
+			So(err, ShouldBeNil)
+			err = uq.Create("crash", "")
+			So(err, ShouldBeNil)
+			err = uq.Create("crash/x", "10s")
+			So(err, ShouldBeNil)
+
+			cs.crashAfter(n)
+			client := new(crashClient)
+			done := client.run(uq)
+			cs.crashAfter(0)
+			uq.Close()
+			lsdb.Close()
+
+			lsdb, err = store.NewLogStore(logPath)
+			So(err, ShouldBeNil)
+			uq, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+			So(err, ShouldBeNil)
+			client.check(uq)
+			uq.Close()
+
+			if done {
+				break
+			}
+		}
+	})
+}
@@ -3,9 +3,12 @@ package queue
 import (
 	"container/list"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/buaazp/uq/metrics"
 	"github.com/buaazp/uq/utils"
 )
 
@@ -15,13 +18,117 @@ type line struct {
 	headLock     sync.RWMutex
 	recycle      time.Duration
 	recycleKey   string
+	attempts     uint64
+	dead         string
+	filter       *lineFilter
+	filterKey    string
 	inflight     *list.List
 	inflightLock sync.RWMutex
 	ihead        uint64
 	imap         map[uint64]bool
+	groups       map[string][]uint64
+	grouped      map[uint64]string
+	skipped      uint64
+	pruned       uint64
 	t            *topic
 }
 
+func (l *line) getHead() uint64 {
+	l.headLock.RLock()
+	defer l.headLock.RUnlock()
+	return l.head
+}
+
+// lineFilterOpt starts the filter option of line, the filter should be the
+// last option and takes the rest of arg so that it can contain ","
+const lineFilterOpt string = ",filter="
+
+// lineConfig is the config of a line which is given by the create arg,
+// the arg looks like "10s,attempts=3,dead=foo_dead,filter=type=text/csv"
+type lineConfig struct {
+	recycle  time.Duration
+	attempts uint64
+	dead     string
+	filter   *lineFilter
+}
+
+func parseLineConfig(arg string) (*lineConfig, error) {
+	lc := new(lineConfig)
+	if arg == "" {
+		return lc, nil
+	}
+
+	var err error
+	if i := strings.Index(arg, lineFilterOpt); i >= 0 {
+		lc.filter, err = parseFilter(arg[i+len(lineFilterOpt):])
+		if err != nil {
+			return nil, err
+		}
+		arg = arg[:i]
+	}
+
+	opts := strings.Split(arg, ",")
+	if opts[0] != "" {
+		lc.recycle, err = time.ParseDuration(opts[0])
+		if err != nil {
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			)
+		}
+	}
+
+	for _, opt := range opts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				`line option error: `+opt,
+			)
+		}
+		switch kv[0] {
+		case "attempts":
+			lc.attempts, err = strconv.ParseUint(kv[1], 10, 0)
+			if err != nil {
+				return nil, utils.NewError(
+					utils.ErrBadRequest,
+					err.Error(),
+				)
+			}
+		case "dead":
+			lc.dead = kv[1]
+		default:
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				`line option unknown: `+kv[0],
+			)
+		}
+	}
+
+	if lc.attempts > 0 && lc.recycle == 0 {
+		return nil, utils.NewError(
+			utils.ErrBadRequest,
+			`line attempts need recycle`,
+		)
+	}
+	return lc, nil
+}
+
+// config returns the create arg of line
+func (l *line) config() string {
+	arg := l.recycle.String()
+	if l.attempts > 0 {
+		arg += ",attempts=" + strconv.FormatUint(l.attempts, 10)
+	}
+	if l.dead != "" {
+		arg += ",dead=" + l.dead
+	}
+	if l.filter != nil {
+		arg += ",filter=" + l.filter.String()
+	}
+	return arg
+}
+
 func (l *line) exportRecycle() error {
 	lineRecycleData := []byte(l.recycle.String())
 	err := l.t.q.setData(l.recycleKey, lineRecycleData)
@@ -39,6 +146,42 @@ func (l *line) removeRecycleData() error {
 	return nil
 }
 
+// exportFilter saves the filter next to the recycle of line, a line
+// without filter has no filter data
+func (l *line) exportFilter() error {
+	if l.filter == nil {
+		return nil
+	}
+	return l.t.q.setData(l.filterKey, []byte(l.filter.String()))
+}
+
+// loadFilter loads the filter of line, a line saved by an older uq has no
+// filter data
+func (l *line) loadFilter() error {
+	datas, err := l.t.q.multiGetData([]string{l.filterKey})
+	if err != nil {
+		return err
+	}
+	if len(datas[0]) == 0 {
+		return nil
+	}
+	l.filter, err = parseFilter(string(datas[0]))
+	if err != nil {
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	return nil
+}
+
+func (l *line) removeFilterData() error {
+	if l.filter == nil {
+		return nil
+	}
+	return l.t.q.delData(l.filterKey)
+}
+
 func (l *line) genLineStore() *UnitedLineStore {
 	inflights := make([]*InflightMessage, l.inflight.Len())
 	i := 0
@@ -53,6 +196,9 @@ func (l *line) genLineStore() *UnitedLineStore {
 	ls.Head = l.head
 	ls.Inflights = inflights
 	ls.Ihead = l.ihead
+	ls.MaxAttempts = l.attempts
+	ls.DeadTopic = l.dead
+	ls.Skipped = l.skipped
 	return ls
 }
 
@@ -74,7 +220,7 @@ func (l *line) exportLine() error {
 	}
 
 	// log.Printf("line[%s] export finisded.", l.name)
-	return nil
+	return l.pruneJournal()
 }
 
 func (l *line) removeLineData() error {
@@ -104,66 +250,279 @@ func (l *line) updateiHead() {
 	}
 }
 
+// pushInflight inserts the message into inflight list ordered by exptime
+func (l *line) pushInflight(msg *InflightMessage) {
+	for m := l.inflight.Back(); m != nil; m = m.Prev() {
+		if m.Value.(*InflightMessage).Exptime <= msg.Exptime {
+			l.inflight.InsertAfter(msg, m)
+			return
+		}
+	}
+	l.inflight.PushFront(msg)
+}
+
+// delayInflight parks a message which is not due yet in inflight list,
+// it will be popped as an expired message when it is due
+func (l *line) delayInflight(tid uint64, deliver int64) {
+	if l.recycle == 0 && l.inflight.Len() == 0 {
+		l.ihead = tid
+	}
+
+	msg := new(InflightMessage)
+	msg.Tid = tid
+	msg.Exptime = deliver
+
+	l.pushInflight(msg)
+	l.imap[tid] = true
+}
+
+// reflight moves an expired message to its new place in inflight list,
+// a line without recycle only holds delayed messages so it just drops it
+func (l *line) reflight(m *list.Element, now time.Time, j *lineJournal) error {
+	msg := m.Value.(*InflightMessage)
+	l.inflight.Remove(m)
+	if l.recycle > 0 {
+		msg.Exptime = now.Add(l.recycle).UnixNano()
+		msg.Attempts++
+		l.pushInflight(msg)
+		metrics.Recycles.Inc()
+		return j.flight(msg)
+	}
+
+	j.land(msg.Tid)
+	l.imap[msg.Tid] = false
+	l.updateiHead()
+	return nil
+}
+
+// exceeded returns whether the message has been delivered too many times
+func (l *line) exceeded(msg *InflightMessage) bool {
+	return l.attempts > 0 && msg.Attempts >= l.attempts
+}
+
+// deadLetter moves an exceeded message out of the line, it is pushed into
+// the dead topic of line if there is one
+func (l *line) deadLetter(m *list.Element, j *lineJournal) error {
+	msg := m.Value.(*InflightMessage)
+	if l.dead != "" {
+		data, err := l.t.getData(msg.Tid)
+		if err != nil {
+			return err
+		}
+		_, err = l.t.q.pushData(l.dead, data, 0, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	j.land(msg.Tid)
+	l.inflight.Remove(m)
+	l.imap[msg.Tid] = false
+	l.updateiHead()
+	log.Printf("key[%s/%s/%d] dead after %d attempts.", l.t.name, l.name, msg.Tid, msg.Attempts)
+	return l.leaveGroup(msg.Tid, time.Now(), j)
+}
+
 func (l *line) pop() (uint64, []byte, error) {
+	l.t.q.backupLock.RLock()
+	defer l.t.q.backupLock.RUnlock()
 	l.inflightLock.Lock()
 	defer l.inflightLock.Unlock()
 
 	now := time.Now()
-	if l.recycle > 0 {
-
-		m := l.inflight.Front()
-		if m != nil {
-			msg := m.Value.(*InflightMessage)
-			exp := time.Unix(0, msg.Exptime)
-			if now.After(exp) {
-				// log.Printf("key[%s/%d] is expired.", l.name, msg.Tid)
-				msg.Exptime = now.Add(l.recycle).UnixNano()
-				data, err := l.t.getData(msg.Tid)
-				if err != nil {
-					return 0, nil, err
-				}
-				l.inflight.Remove(m)
-				l.inflight.PushBack(msg)
-				// log.Printf("key[%s/%s/%d] poped.", l.t.name, l.name, msg.Tid)
-				return msg.Tid, data, nil
+	j := l.newJournal()
+	for m := l.inflight.Front(); m != nil; m = l.inflight.Front() {
+		msg := m.Value.(*InflightMessage)
+		exp := time.Unix(0, msg.Exptime)
+		if !now.After(exp) {
+			break
+		}
+		// log.Printf("key[%s/%d] is expired.", l.name, msg.Tid)
+		if l.exceeded(msg) {
+			err := l.deadLetter(m, j)
+			if err == nil {
+				continue
 			}
+			log.Printf("key[%s/%s/%d] dead letter error: %s", l.t.name, l.name, msg.Tid, err)
+		}
+		data, err := l.t.getData(msg.Tid)
+		if err != nil {
+			return 0, nil, err
+		}
+		err = l.reflight(m, now, j)
+		if err == nil {
+			err = j.commit()
+		}
+		if err != nil {
+			return 0, nil, err
 		}
+		// log.Printf("key[%s/%s/%d] poped.", l.t.name, l.name, msg.Tid)
+		return msg.Tid, data, nil
 	}
 
 	l.headLock.Lock()
 	defer l.headLock.Unlock()
-	tid := l.head
 
+	// the head is moved only after the journal is written, or a message
+	// may be skipped without being delivered
+	var delayed []*InflightMessage
 	topicTail := l.t.getTail()
-	if l.head >= topicTail {
-		// log.Printf("line[%s] is blank. head:%d - tail:%d", l.name, l.head, l.t.tail)
-		return 0, nil, utils.NewError(
-			utils.ErrNone,
-			`line pop`,
-		)
+	for tid := l.head; ; tid++ {
+		if tid >= topicTail {
+			// log.Printf("line[%s] is blank. head:%d - tail:%d", l.name, l.head, l.t.tail)
+			err := l.advance(j, tid, delayed, nil)
+			if err != nil {
+				return 0, nil, err
+			}
+			return 0, nil, utils.NewError(
+				utils.ErrNone,
+				`line pop`,
+			)
+		}
+
+		deliver := l.t.getDelay(tid)
+		var data []byte
+		if deliver <= now.UnixNano() || l.grouping() || l.filter != nil {
+			var err error
+			data, err = l.t.getData(tid)
+			if err != nil {
+				l.dropGroups(delayed)
+				return 0, nil, err
+			}
+		}
+		// a message which does not match the filter is passed by head
+		if !l.filter.match(data) {
+			continue
+		}
+
+		// a message waits in inflight list if it is delayed or an earlier
+		// message of its group is inflight
+		parked := l.grouping() && l.joinGroup(tid, decodeMessage(data).Group)
+		if parked || deliver > now.UnixNano() {
+			if parked {
+				deliver = parkedExptime
+			}
+			msg, err := l.journalFlight(j, tid, deliver, 0)
+			if err != nil {
+				l.dropGroups(delayed, []*InflightMessage{{Tid: tid}})
+				return 0, nil, err
+			}
+			delayed = append(delayed, msg)
+			continue
+		}
+
+		var flights []*InflightMessage
+		if l.recycle > 0 {
+			msg, err := l.journalFlight(j, tid, now.Add(l.recycle).UnixNano(), 1)
+			if err != nil {
+				l.dropGroups(delayed, []*InflightMessage{{Tid: tid}})
+				return 0, nil, err
+			}
+			flights = append(flights, msg)
+		}
+		err := l.advance(j, tid+1, delayed, flights)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return tid, data, nil
 	}
+}
 
-	data, err := l.t.getData(tid)
+// delivered returns whether an inflight message has been popped, the
+// delayed and parked messages wait in inflight list with no attempts
+func delivered(msg *InflightMessage) bool {
+	return msg.Attempts > 0
+}
+
+// journalFlight records a message which is going to be put into inflight
+// list from the head of line
+func (l *line) journalFlight(j *lineJournal, tid uint64, exptime int64, attempts uint64) (*InflightMessage, error) {
+	msg := new(InflightMessage)
+	msg.Tid = tid
+	msg.Exptime = exptime
+	msg.Attempts = attempts
+	err := j.flight(msg)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
+	return msg, nil
+}
 
-	l.head++
+// advance commits the journal with the new head of line and then applies
+// the delayed and delivered messages to inflight list
+func (l *line) advance(j *lineJournal, head uint64, delayed, flights []*InflightMessage) error {
+	if head == l.head && len(j.keys) == 0 {
+		return nil
+	}
+	if head != l.head {
+		j.setHead(head)
+	}
+	err := j.commit()
+	if err != nil {
+		l.dropGroups(delayed, flights)
+		return err
+	}
 
-	if l.recycle > 0 {
-		msg := new(InflightMessage)
-		msg.Tid = tid
-		msg.Exptime = now.Add(l.recycle).UnixNano()
+	for _, msg := range delayed {
+		l.delayInflight(msg.Tid, msg.Exptime)
+	}
+	for _, msg := range flights {
+		l.pushInflight(msg)
+		// log.Printf("key[%s/%s/%d] flighted.", l.t.name, l.name, msg.Tid)
+		l.imap[msg.Tid] = true
+	}
+	l.head = head
+	return nil
+}
+
+func (l *line) nextExpire(now time.Time) time.Duration {
+	l.inflightLock.RLock()
+	defer l.inflightLock.RUnlock()
 
-		l.inflight.PushBack(msg)
-		// log.Printf("key[%s/%s/%d] flighted.", l.t.name, l.name, l.head)
-		l.imap[tid] = true
+	m := l.inflight.Front()
+	if m == nil {
+		return 0
 	}
+	msg := m.Value.(*InflightMessage)
+	return time.Unix(0, msg.Exptime).Sub(now)
+}
+
+func (l *line) popWait(timeout time.Duration) (uint64, []byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		// get the channel before pop so that no push will be missed
+		pushed := l.t.waitPush()
+		tid, data, err := l.pop()
+		if err == nil || !utils.IsErrNone(err) {
+			return tid, data, err
+		}
 
-	return tid, data, nil
+		now := time.Now()
+		wait := deadline.Sub(now)
+		if wait <= 0 {
+			return tid, data, err
+		}
+		// an inflight message may expire before any new message comes
+		if exp := l.nextExpire(now); exp > 0 && exp < wait {
+			wait = exp
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-pushed:
+		case <-timer.C:
+		case <-l.t.quit:
+			timer.Stop()
+			return tid, data, err
+		}
+		timer.Stop()
+	}
 }
 
 func (l *line) mPop(n int) ([]uint64, [][]byte, error) {
+	l.t.q.backupLock.RLock()
+	defer l.t.q.backupLock.RUnlock()
 	l.inflightLock.Lock()
 	defer l.inflightLock.Unlock()
 
@@ -171,68 +530,145 @@ func (l *line) mPop(n int) ([]uint64, [][]byte, error) {
 	var ids []uint64
 	var datas [][]byte
 	now := time.Now()
-	if l.recycle > 0 {
-		for m := l.inflight.Front(); m != nil && fc < n; m = m.Next() {
-			msg := m.Value.(*InflightMessage)
-			exp := time.Unix(0, msg.Exptime)
-			if now.After(exp) {
-				msg := m.Value.(*InflightMessage)
-				data, err := l.t.getData(msg.Tid)
-				if err != nil {
-					return nil, nil, err
-				}
-				ids = append(ids, msg.Tid)
-				datas = append(datas, data)
-				fc++
-			} else {
-				break
+	j := l.newJournal()
+	for m := l.inflight.Front(); m != nil && fc < n; m = l.inflight.Front() {
+		msg := m.Value.(*InflightMessage)
+		exp := time.Unix(0, msg.Exptime)
+		if !now.After(exp) {
+			break
+		}
+		if l.exceeded(msg) {
+			err := l.deadLetter(m, j)
+			if err == nil {
+				continue
 			}
+			log.Printf("key[%s/%s/%d] dead letter error: %s", l.t.name, l.name, msg.Tid, err)
 		}
-		exptime := now.Add(l.recycle).UnixNano()
-		for i := 0; i < fc; i++ {
-			m := l.inflight.Front()
-			msg := m.Value.(*InflightMessage)
-			msg.Exptime = exptime
-			l.inflight.Remove(m)
-			l.inflight.PushBack(msg)
+		data, err := l.t.getData(msg.Tid)
+		if err != nil {
+			return nil, nil, err
 		}
-		if fc >= n {
-			return ids, datas, nil
+		err = l.reflight(m, now, j)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, msg.Tid)
+		datas = append(datas, data)
+		fc++
+	}
+	if fc >= n {
+		err := j.commit()
+		if err != nil {
+			return nil, nil, err
 		}
+		return ids, datas, nil
 	}
 
 	l.headLock.Lock()
 	defer l.headLock.Unlock()
 
-	for ; fc < n; fc++ {
-		tid := l.head
-		topicTail := l.t.getTail()
-		if l.head >= topicTail {
+	// find the messages to deliver from head and get them at once, the
+	// delayed ones are skipped unless their groups or filter are needed
+	topicTail := l.t.getTail()
+	var tids []uint64
+	var tdatas [][]byte
+	delays := make(map[uint64]int64)
+	passed := make(map[uint64]bool)
+	for tid, due := l.head, 0; tid < topicTail && fc+due < n; {
+		var batch []uint64
+		for ; tid < topicTail && fc+due < n; tid++ {
+			deliver := l.t.getDelay(tid)
+			if deliver > now.UnixNano() {
+				delays[tid] = deliver
+			} else {
+				due++
+			}
+			if deliver <= now.UnixNano() || l.grouping() || l.filter != nil {
+				batch = append(batch, tid)
+			}
+		}
+		datas, err := l.t.getDatas(batch)
+		if err != nil {
+			log.Printf("get datas failed: %s", err)
+			datas = make([][]byte, len(batch))
+		}
+		tids = append(tids, batch...)
+		tdatas = append(tdatas, datas...)
+
+		// more messages are needed for the ones filtered out
+		for i, data := range datas {
+			if data == nil || l.filter.match(data) {
+				continue
+			}
+			passed[batch[i]] = true
+			if _, delay := delays[batch[i]]; !delay {
+				due--
+			}
+		}
+	}
+
+	var delayed, flights []*InflightMessage
+	var rids []uint64
+	var rdatas [][]byte
+	head := l.head
+	for i := 0; fc+len(rids) < n; head++ {
+		tid := head
+		if head >= topicTail {
 			// log.Printf("line[%s] is blank. head:%d - tail:%d", l.name, l.head, l.t.tail)
 			break
 		}
 
-		data, err := l.t.getData(tid)
-		if err != nil {
-			log.Printf("get data failed: %s", err)
+		deliver, delay := delays[tid]
+		var data []byte
+		if i < len(tids) && tids[i] == tid {
+			data = tdatas[i]
+			i++
+		} else if !delay {
+			break
+		}
+		if data == nil && (!delay || l.grouping() || l.filter != nil) {
+			log.Printf("get data failed: key[%s:%d] not existed", l.t.name, tid)
 			break
 		}
+		if passed[tid] {
+			continue
+		}
 
-		l.head++
-		ids = append(ids, tid)
-		datas = append(datas, data)
+		parked := l.grouping() && l.joinGroup(tid, decodeMessage(data).Group)
+		if parked || delay {
+			if parked {
+				deliver = parkedExptime
+			}
+			msg, err := l.journalFlight(j, tid, deliver, 0)
+			if err != nil {
+				l.dropGroups(delayed, flights, []*InflightMessage{{Tid: tid}})
+				return nil, nil, err
+			}
+			delayed = append(delayed, msg)
+			continue
+		}
 
-		if l.recycle > 0 {
-			msg := new(InflightMessage)
-			msg.Tid = tid
-			msg.Exptime = now.Add(l.recycle).UnixNano()
+		rids = append(rids, tid)
+		rdatas = append(rdatas, data)
 
-			l.inflight.PushBack(msg)
-			// log.Printf("key[%s/%s/%d] flighted.", l.t.name, l.name, l.head)
-			l.imap[tid] = true
+		if l.recycle > 0 {
+			msg, err := l.journalFlight(j, tid, now.Add(l.recycle).UnixNano(), 1)
+			if err != nil {
+				l.dropGroups(delayed, flights, []*InflightMessage{{Tid: tid}})
+				return nil, nil, err
+			}
+			flights = append(flights, msg)
 		}
 	}
 
+	// the recycled messages and the moved head are written at once
+	err := l.advance(j, head, delayed, flights)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids = append(ids, rids...)
+	datas = append(datas, rdatas...)
+
 	if len(ids) > 0 {
 		return ids, datas, nil
 	}
@@ -250,6 +686,11 @@ func (l *line) confirm(id uint64) error {
 		)
 	}
 
+	// the locks are taken in the same order as pop
+	l.t.q.backupLock.RLock()
+	defer l.t.q.backupLock.RUnlock()
+	l.inflightLock.Lock()
+	defer l.inflightLock.Unlock()
 	l.headLock.RLock()
 	defer l.headLock.RUnlock()
 	head := l.head
@@ -260,16 +701,34 @@ func (l *line) confirm(id uint64) error {
 		)
 	}
 
-	l.inflightLock.Lock()
-	defer l.inflightLock.Unlock()
-
 	for m := l.inflight.Front(); m != nil; m = m.Next() {
 		msg := m.Value.(*InflightMessage)
 		if msg.Tid == id {
+			// a delayed or parked message has not been delivered yet
+			if !delivered(msg) {
+				break
+			}
+			j := l.newJournal()
+			j.land(id)
+			err := j.commit()
+			if err != nil {
+				return err
+			}
 			l.inflight.Remove(m)
 			// log.Printf("key[%s/%s/%d] comfirmed.", l.t.name, l.name, id)
 			l.imap[id] = false
 			l.updateiHead()
+
+			// a parked message which is not released now will be released
+			// when the line is loaded again
+			j = l.newJournal()
+			err = l.leaveGroup(id, time.Now(), j)
+			if err == nil {
+				err = j.commit()
+			}
+			if err != nil {
+				log.Printf("key[%s/%s/%d] release group error: %s", l.t.name, l.name, id, err)
+			}
 			return nil
 		}
 	}
@@ -280,6 +739,152 @@ func (l *line) confirm(id uint64) error {
 	)
 }
 
+// expire sets the exptime of an inflight message and keeps the inflight
+// list in order
+func (l *line) expire(id uint64, exptime time.Time, op string) error {
+	if l.recycle == 0 {
+		return utils.NewError(
+			utils.ErrNotDelivered,
+			`line `+op,
+		)
+	}
+
+	// the locks are taken in the same order as pop
+	l.t.q.backupLock.RLock()
+	defer l.t.q.backupLock.RUnlock()
+	l.inflightLock.Lock()
+	defer l.inflightLock.Unlock()
+	l.headLock.RLock()
+	defer l.headLock.RUnlock()
+	if id >= l.head {
+		return utils.NewError(
+			utils.ErrNotDelivered,
+			`line `+op,
+		)
+	}
+
+	for m := l.inflight.Front(); m != nil; m = m.Next() {
+		msg := m.Value.(*InflightMessage)
+		if msg.Tid == id {
+			// a delayed or parked message has not been delivered yet
+			if !delivered(msg) {
+				break
+			}
+			next := *msg
+			next.Exptime = exptime.UnixNano()
+			j := l.newJournal()
+			err := j.flight(&next)
+			if err == nil {
+				err = j.commit()
+			}
+			if err != nil {
+				return err
+			}
+			l.inflight.Remove(m)
+			msg.Exptime = next.Exptime
+			l.pushInflight(msg)
+			return nil
+		}
+	}
+
+	return utils.NewError(
+		utils.ErrNotDelivered,
+		`line `+op,
+	)
+}
+
+// nack makes an inflight message expire at once so that it can be popped
+// again without waiting for the recycle time
+func (l *line) nack(id uint64) error {
+	return l.expire(id, time.Now().Add(-time.Nanosecond), "nack")
+}
+
+// touch extends the exptime of an inflight message from now on
+func (l *line) touch(id uint64, timeout time.Duration) error {
+	return l.expire(id, time.Now().Add(timeout), "touch")
+}
+
+// seek moves the line to id, all the inflight messages are dropped and
+// messages from id are delivered again
+func (l *line) seek(id uint64) error {
+	l.inflightLock.Lock()
+	defer l.inflightLock.Unlock()
+	l.headLock.Lock()
+	defer l.headLock.Unlock()
+
+	oldIhead, oldHead := l.ihead, l.head
+	l.inflight.Init()
+	l.imap = make(map[uint64]bool)
+	l.groups = make(map[string][]uint64)
+	l.grouped = make(map[uint64]string)
+	l.ihead = id
+	l.head = id
+
+	err := l.resetJournal(oldIhead, oldHead)
+	if err != nil {
+		return err
+	}
+	err = l.exportLine()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("line[%s/%s] seek to %d succ", l.t.name, l.name, id)
+	return nil
+}
+
+// skipTo fast-forwards the line to id because the messages before id are
+// removed by the retention policy of topic
+func (l *line) skipTo(id uint64) {
+	l.inflightLock.Lock()
+	defer l.inflightLock.Unlock()
+	l.headLock.Lock()
+	defer l.headLock.Unlock()
+
+	var skipped uint64
+	var removed []uint64
+	j := l.newJournal()
+	for m := l.inflight.Front(); m != nil; {
+		next := m.Next()
+		msg := m.Value.(*InflightMessage)
+		if msg.Tid < id {
+			j.land(msg.Tid)
+			l.inflight.Remove(m)
+			removed = append(removed, msg.Tid)
+			skipped++
+		}
+		m = next
+	}
+	now := time.Now()
+	for _, tid := range removed {
+		err := l.leaveGroup(tid, now, j)
+		if err != nil {
+			log.Printf("key[%s/%s/%d] release group error: %s", l.t.name, l.name, tid, err)
+		}
+	}
+	if l.head < id {
+		skipped += id - l.head
+		l.head = id
+		j.setHead(id)
+	}
+	err := j.commit()
+	if err != nil {
+		log.Printf("line[%s/%s] journal skip error: %s", l.t.name, l.name, err)
+	}
+	for i := l.ihead; i < id; i++ {
+		delete(l.imap, i)
+	}
+	if l.ihead < id {
+		l.ihead = id
+	}
+	l.updateiHead()
+
+	if skipped > 0 {
+		l.skipped += skipped
+		log.Printf("line[%s/%s] skipped %d messages to %d.", l.t.name, l.name, skipped, id)
+	}
+}
+
 func (l *line) stat() *Stat {
 	l.inflightLock.RLock()
 	defer l.inflightLock.RUnlock()
@@ -290,6 +895,12 @@ func (l *line) stat() *Stat {
 	qs.Name = l.t.name + "/" + l.name
 	qs.Type = "line"
 	qs.Recycle = l.recycle.String()
+	qs.Attempts = l.attempts
+	qs.Dead = l.dead
+	qs.Filter = l.filter.String()
+	qs.Skipped = l.skipped
+	qs.Inflight = uint64(l.inflight.Len())
+	qs.Parked = l.parked()
 	qs.IHead = l.ihead
 	inflightLen := uint64(l.inflight.Len())
 	qs.Head = l.head
@@ -302,15 +913,23 @@ func (l *line) stat() *Stat {
 func (l *line) empty() error {
 	l.inflightLock.Lock()
 	defer l.inflightLock.Unlock()
+	oldIhead := l.ihead
 	l.inflight.Init()
 	l.imap = make(map[uint64]bool)
+	l.groups = make(map[string][]uint64)
+	l.grouped = make(map[uint64]string)
 	l.ihead = l.t.getTail()
 
 	l.headLock.Lock()
 	defer l.headLock.Unlock()
+	oldHead := l.head
 	l.head = l.t.getTail()
 
-	err := l.exportLine()
+	err := l.resetJournal(oldIhead, oldHead)
+	if err != nil {
+		return err
+	}
+	err = l.exportLine()
 	if err != nil {
 		return err
 	}
@@ -330,6 +949,18 @@ func (l *line) remove() error {
 		log.Printf("line[%s] removeRecycleData error: %s", l.name, err)
 	}
 
+	err = l.removeFilterData()
+	if err != nil {
+		log.Printf("line[%s] removeFilterData error: %s", l.name, err)
+	}
+
+	l.inflightLock.Lock()
+	err = l.removeJournal()
+	l.inflightLock.Unlock()
+	if err != nil {
+		log.Printf("line[%s] removeJournal error: %s", l.name, err)
+	}
+
 	log.Printf("line[%s] remove succ", l.name)
 	return nil
 }
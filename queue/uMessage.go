@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"bytes"
+
+	"github.com/buaazp/uq/utils"
+)
+
+// msgMagic is the prefix of an encoded message envelope in storage. Data
+// without it is a raw message pushed by an older uq and is popped as the
+// message body directly.
+var msgMagic = []byte{0x00, 'u', 'q', 0x01}
+
+func encodeMessage(msg *Message) ([]byte, error) {
+	data := make([]byte, len(msgMagic)+msg.Size())
+	copy(data, msgMagic)
+	_, err := msg.MarshalTo(data[len(msgMagic):])
+	if err != nil {
+		return nil, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	return data, nil
+}
+
+func decodeMessage(data []byte) *Message {
+	msg := new(Message)
+	if bytes.HasPrefix(data, msgMagic) {
+		err := msg.Unmarshal(data[len(msgMagic):])
+		if err == nil {
+			return msg
+		}
+		msg.Reset()
+	}
+	msg.Body = data
+	return msg
+}
+
+// GetHeader returns the value of a header of the message
+func (m *Message) GetHeader(key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// SetHeader sets a header of the message
+func (m *Message) SetHeader(key, value string) {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			h.Value = value
+			return
+		}
+	}
+	h := new(MessageHeader)
+	h.Key = key
+	h.Value = value
+	m.Headers = append(m.Headers, h)
+}
@@ -0,0 +1,224 @@
+package queue
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buaazp/uq/utils"
+)
+
+const (
+	// levelSep joins the name of topic and a priority, the level of
+	// priority 2 of topic foo is foo~2
+	levelSep      string = "~"
+	maxPriorities uint64 = 16
+)
+
+func levelName(name string, p int) string {
+	return name + levelSep + strconv.Itoa(p)
+}
+
+// sublevels returns the priority levels except the topic itself
+func (t *topic) sublevels() []*topic {
+	if len(t.levels) == 0 {
+		return nil
+	}
+	return t.levels[1:]
+}
+
+// allLevels returns the priority levels from low to high, a topic without
+// priorities is its only level
+func (t *topic) allLevels() []*topic {
+	if len(t.levels) == 0 {
+		return []*topic{t}
+	}
+	return t.levels
+}
+
+// newLevels creates the priority levels of a new topic, all the topic
+// stores are written so that the levels are loaded when uq restarts
+func (u *UnitedQueue) newLevels(t *topic, tc *topicConfig) error {
+	if tc.priorities <= 1 {
+		return nil
+	}
+
+	lc := *tc
+	lc.priorities = 0
+	levels := []*topic{t}
+	for p := 1; p < int(tc.priorities); p++ {
+		lt, err := u.newTopic(levelName(t.name, p), &lc)
+		if err == nil {
+			lt.parent = t
+			levels = append(levels, lt)
+			err = lt.exportTopic()
+		}
+		if err != nil {
+			for _, lt := range levels[1:] {
+				lt.remove()
+			}
+			return err
+		}
+	}
+	t.levels = levels
+	return t.exportTopic()
+}
+
+// loadLevels loads the priority levels of a topic
+func (u *UnitedQueue) loadLevels(t *topic, priorities uint64) error {
+	if priorities <= 1 {
+		return nil
+	}
+
+	levels := []*topic{t}
+	for p := 1; p < int(priorities); p++ {
+		name := levelName(t.name, p)
+		data, err := u.getData(name)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return errors.New("level backup data missing: " + name)
+		}
+		var ts UnitedTopicStore
+		err = ts.Unmarshal(data)
+		if err != nil {
+			return err
+		}
+		lt, err := u.openTopic(name, ts)
+		if err != nil {
+			return err
+		}
+		lt.parent = t
+		levels = append(levels, lt)
+	}
+	t.levels = levels
+	return nil
+}
+
+// getTopic returns a topic by name, the name can also be a priority level
+// of topic like foo~2
+func (u *UnitedQueue) getTopic(name string) (*topic, bool) {
+	u.topicsLock.RLock()
+	defer u.topicsLock.RUnlock()
+
+	t, ok := u.topics[name]
+	if ok {
+		return t, true
+	}
+	i := strings.LastIndex(name, levelSep)
+	if i < 0 {
+		return nil, false
+	}
+	t, ok = u.topics[name[:i]]
+	if !ok {
+		return nil, false
+	}
+	p, err := strconv.Atoi(name[i+len(levelSep):])
+	if err != nil || p < 1 || p >= len(t.levels) {
+		return nil, false
+	}
+	return t.levels[p], true
+}
+
+// popLevel pops a message of line from the highest priority level which
+// has one, the level is returned to make the key of message
+func (t *topic) popLevel(name string, timeout time.Duration) (*topic, uint64, []byte, error) {
+	var id uint64
+	var data []byte
+	var err error
+	if len(t.levels) == 0 {
+		if timeout > 0 {
+			id, data, err = t.popWait(name, timeout)
+		} else {
+			id, data, err = t.pop(name)
+		}
+		return t, id, data, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		// get the channel before pop so that no push will be missed, the
+		// levels notify the topic when they are pushed
+		pushed := t.waitPush()
+		for p := len(t.levels) - 1; p >= 0; p-- {
+			lt := t.levels[p]
+			id, data, err = lt.pop(name)
+			if err == nil || !utils.IsErrNone(err) {
+				return lt, id, data, err
+			}
+		}
+
+		now := time.Now()
+		wait := deadline.Sub(now)
+		if wait <= 0 {
+			return nil, 0, nil, err
+		}
+		// an inflight message may expire before any new message comes
+		if exp := t.nextExpire(name, now); exp > 0 && exp < wait {
+			wait = exp
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-pushed:
+		case <-timer.C:
+		case <-t.quit:
+			timer.Stop()
+			return nil, 0, nil, err
+		}
+		timer.Stop()
+	}
+}
+
+// nextExpire returns the time before the first inflight message of line
+// in all levels expires
+func (t *topic) nextExpire(name string, now time.Time) time.Duration {
+	var next time.Duration
+	for _, lt := range t.levels {
+		lt.linesLock.RLock()
+		l, ok := lt.lines[name]
+		lt.linesLock.RUnlock()
+		if !ok {
+			continue
+		}
+		if exp := l.nextExpire(now); exp > 0 && (next == 0 || exp < next) {
+			next = exp
+		}
+	}
+	return next
+}
+
+// mPopLevel pops at most n messages of line from the highest priority
+// levels first, the level of every message is returned with it
+func (t *topic) mPopLevel(name string, n int) ([]*topic, []uint64, [][]byte, error) {
+	var lts []*topic
+	var ids []uint64
+	var datas [][]byte
+	var err error
+	levels := t.allLevels()
+	for p := len(levels) - 1; p >= 0 && len(ids) < n; p-- {
+		lt := levels[p]
+		var lids []uint64
+		var ldatas [][]byte
+		lids, ldatas, err = lt.mPop(name, n-len(ids))
+		if err != nil {
+			if utils.IsErrNone(err) {
+				continue
+			}
+			// the messages popped from higher levels are still returned
+			break
+		}
+		for range lids {
+			lts = append(lts, lt)
+		}
+		ids = append(ids, lids...)
+		datas = append(datas, ldatas...)
+	}
+
+	if len(ids) > 0 {
+		return lts, ids, datas, nil
+	}
+	return nil, nil, nil, err
+}
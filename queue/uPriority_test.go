@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buaazp/uq/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPriorities(t *testing.T) {
+	Convey("Test Topic With Priorities", t, func() {
+		logPath := "/tmp/uq.queue.test.priorities"
+		os.RemoveAll(logPath)
+		defer os.RemoveAll(logPath)
+
+		lsdb, err := store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err := NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		So(uq.Create("pr", "priorities=17"), ShouldNotBeNil)
+		So(uq.Create("pr", "priorities=3"), ShouldBeNil)
+		So(uq.Create("pr/x", "10s"), ShouldBeNil)
+		So(uq.Create("pr~1", ""), ShouldNotBeNil)
+
+		for _, c := range []struct{ key, body, id string }{
+			{"pr", "a", "pr/0"},
+			{"pr~2", "b", "pr~2/0"},
+			{"pr~1", "c", "pr~1/0"},
+			{"pr~2", "d", "pr~2/1"},
+		} {
+			id, err := uq.Push(c.key, []byte(c.body))
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, c.id)
+		}
+		_, err = uq.Push("pr~3", []byte("e"))
+		So(err, ShouldNotBeNil)
+
+		// the highest priority is served first
+		for _, c := range []struct{ id, body string }{
+			{"pr~2/x/0", "b"},
+			{"pr~2/x/1", "d"},
+			{"pr~1/x/0", "c"},
+			{"pr/x/0", "a"},
+		} {
+			id, data, err := uq.Pop("pr/x")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, c.id)
+			So(string(data), ShouldEqual, c.body)
+		}
+		_, _, err = uq.Pop("pr/x")
+		So(err, ShouldNotBeNil)
+		So(uq.Confirm("pr~2/x/0"), ShouldBeNil)
+		So(uq.Confirm("pr~2/x/0"), ShouldNotBeNil)
+
+		_, err = uq.Push("pr", []byte("e"))
+		So(err, ShouldBeNil)
+		_, err = uq.Push("pr~1", []byte("f"))
+		So(err, ShouldBeNil)
+		ids, datas, err := uq.MultiPop("pr/x", 5)
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []string{"pr~1/x/1", "pr/x/1"})
+		So(string(datas[0]), ShouldEqual, "f")
+
+		// a push into any level wakes the waiting pop
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			uq.Push("pr~2", []byte("g"))
+		}()
+		id, data, err := uq.PopWait("pr/x", time.Second)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "pr~2/x/2")
+		So(string(data), ShouldEqual, "g")
+
+		qs, err := uq.Stat("pr")
+		So(err, ShouldBeNil)
+		So(qs.Tail, ShouldEqual, 2)
+		So(len(qs.Priorities), ShouldEqual, 2)
+		So(qs.Priorities[1].Name, ShouldEqual, "pr~2")
+		So(qs.Priorities[1].Tail, ShouldEqual, 3)
+		So(uq.Seek("pr/x", 0), ShouldNotBeNil)
+		uq.Close()
+
+		// the levels are loaded with their lines
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer uq.Close()
+		ls, err := uq.Stat("pr/x")
+		So(err, ShouldBeNil)
+		So(len(ls.Priorities), ShouldEqual, 2)
+		So(ls.Priorities[1].Name, ShouldEqual, "pr~2/x")
+		So(ls.Priorities[1].Head, ShouldEqual, 3)
+		So(ls.Priorities[1].Inflight, ShouldEqual, 2)
+		So(uq.Confirm("pr~2/x/1"), ShouldBeNil)
+		So(uq.SeekTime("pr/x", time.Unix(0, 0)), ShouldBeNil)
+		id, _, err = uq.Pop("pr/x")
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "pr~2/x/0")
+
+		// the levels are carried by the export of topic
+		var archive bytes.Buffer
+		So(uq.ExportTopic("pr", &archive), ShouldBeNil)
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		dst, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer dst.Close()
+		So(dst.ImportTopic(bytes.NewReader(archive.Bytes())), ShouldBeNil)
+		qs, err = dst.Stat("pr")
+		So(err, ShouldBeNil)
+		So(len(qs.Priorities), ShouldEqual, 2)
+		So(qs.Priorities[0].Tail, ShouldEqual, 2)
+
+		So(uq.Remove("pr"), ShouldBeNil)
+		_, err = lsdb.Get("pr~2")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -4,28 +4,31 @@ import (
 	"encoding/binary"
 	"errors"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/buaazp/uq/metrics"
 	"github.com/buaazp/uq/store"
 	"github.com/buaazp/uq/utils"
 	"github.com/coreos/go-etcd/etcd"
 )
 
 const (
-	storageKeyWord   string        = "UnitedQueueKey"
-	bgBackupInterval time.Duration = 10 * time.Second
-	bgCleanInterval  time.Duration = 20 * time.Second
-	bgCleanTimeout   time.Duration = 5 * time.Second
-	keyTopicStore    string        = ":store"
-	keyTopicHead     string        = ":head"
-	keyTopicTail     string        = ":tail"
-	keyLineStore     string        = ":store"
-	keyLineHead      string        = ":head"
-	keyLineRecycle   string        = ":recycle"
-	keyLineInflight  string        = ":inflight"
+	storageKeyWord  string = "UnitedQueueKey"
+	keyTopicStore   string = ":store"
+	keyTopicHead    string = ":head"
+	keyTopicTail    string = ":tail"
+	keyLineStore    string = ":store"
+	keyLineHead     string = ":head"
+	keyLineRecycle  string = ":recycle"
+	keyLineFilter   string = ":filter"
+	keyLineInflight string = ":inflight"
+	keyMsgDelay     string = ":delay"
+	keyTopicDedup   string = ":dedup"
+	cleanBatchSize  uint64 = 1024
 )
 
 // UnitedQueue is a implemention of message queue in uq
@@ -39,6 +42,9 @@ type UnitedQueue struct {
 	etcdKey    string
 	etcdStop   chan bool
 	wg         sync.WaitGroup
+	// backupLock is held by the changes of state for reading, so that a
+	// backup takes the state when nothing is changing
+	backupLock sync.RWMutex
 }
 
 // NewUnitedQueue returns a new UnitedQueue
@@ -103,22 +109,60 @@ func (u *UnitedQueue) delData(key string) error {
 	return nil
 }
 
+func (u *UnitedQueue) multiSetData(keys []string, datas [][]byte) error {
+	err := u.storage.MultiSet(keys, datas)
+	if err != nil {
+		// log.Printf("keys%v multi set data error: %s", keys, err)
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	return nil
+}
+
+func (u *UnitedQueue) multiGetData(keys []string) ([][]byte, error) {
+	datas, err := u.storage.MultiGet(keys)
+	if err != nil {
+		// log.Printf("keys%v multi get data error: %s", keys, err)
+		return nil, utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	return datas, nil
+}
+
+func (u *UnitedQueue) delRangeData(prefix string, start, end uint64) error {
+	err := u.storage.DelRange(prefix, start, end)
+	if err != nil {
+		// log.Printf("keys[%s%d - %d] del data error: %s", prefix, start, end, err)
+		return utils.NewError(
+			utils.ErrInternalError,
+			err.Error(),
+		)
+	}
+	return nil
+}
+
 func (u *UnitedQueue) exportTopics() error {
 	u.topicsLock.RLock()
 	defer u.topicsLock.RUnlock()
 
-	for _, t := range u.topics {
-		err := t.exportLines()
-		if err != nil {
-			log.Printf("topic[%s] export lines error: %s", t.name, err)
-			continue
-		}
-		t.linesLock.RLock()
-		err = t.exportTopic()
-		t.linesLock.RUnlock()
-		if err != nil {
-			log.Printf("topic[%s] export error: %s", t.name, err)
-			continue
+	for _, topic := range u.topics {
+		for _, t := range topic.allLevels() {
+			err := t.exportLines()
+			if err != nil {
+				log.Printf("topic[%s] export lines error: %s", t.name, err)
+				continue
+			}
+			t.linesLock.RLock()
+			err = t.exportTopic()
+			t.linesLock.RUnlock()
+			if err != nil {
+				log.Printf("topic[%s] export error: %s", t.name, err)
+				continue
+			}
 		}
 	}
 
@@ -160,11 +204,33 @@ func (u *UnitedQueue) exportQueue() error {
 }
 
 func (u *UnitedQueue) loadTopic(topicName string, ts UnitedTopicStore) (*topic, error) {
+	t, err := u.openTopic(topicName, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	u.registerTopic(t.name)
+	// log.Printf("topic[%s] load succ.", topicName)
+	// log.Printf("topic: %v", t)
+	return t, nil
+}
+
+// openTopic loads a topic and its priority levels from storage
+func (u *UnitedQueue) openTopic(topicName string, ts UnitedTopicStore) (*topic, error) {
 	t := new(topic)
 	t.name = topicName
 	t.persist = ts.Persist
+	t.maxAge = time.Duration(ts.MaxAge)
+	t.maxCount = ts.MaxCount
+	t.maxBytes = ts.MaxBytes
+	t.delayed = ts.Delayed
+	t.delays = make(map[uint64]int64)
+	t.dedup = time.Duration(ts.Dedup)
+	t.dedups = make(map[string]*dedupEntry)
+	t.dedupKey = topicName + keyTopicDedup
 	t.q = u
 	t.quit = make(chan bool)
+	t.waitChan = make(chan bool)
 
 	t.headKey = topicName + keyTopicHead
 	topicHeadData, err := u.getData(t.headKey)
@@ -178,6 +244,18 @@ func (u *UnitedQueue) loadTopic(topicName string, ts UnitedTopicStore) (*topic,
 		return nil, err
 	}
 	t.tail = binary.LittleEndian.Uint64(topicTailData)
+	if t.delayed {
+		t.loadDelays()
+	}
+	if t.maxBytes > 0 {
+		t.loadBytes()
+	}
+	if t.dedup > 0 {
+		err = t.loadDedups()
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	lines := make(map[string]*line)
 	for _, lineName := range ts.Lines {
@@ -196,18 +274,20 @@ func (u *UnitedQueue) loadTopic(topicName string, ts UnitedTopicStore) (*topic,
 		}
 		l, err := t.loadLine(lineName, ls)
 		if err != nil {
-			continue
+			// a line loaded in part may deliver the wrong messages
+			return nil, err
 		}
 		lines[lineName] = l
 		// log.Printf("line[%s] load succ.", lineStoreKey)
 	}
 	t.lines = lines
 
-	u.registerTopic(t.name)
+	err = u.loadLevels(t, ts.Priorities)
+	if err != nil {
+		return nil, err
+	}
 
 	t.start()
-	// log.Printf("topic[%s] load succ.", topicName)
-	// log.Printf("topic: %v", t)
 	return t, nil
 }
 
@@ -252,18 +332,26 @@ func (u *UnitedQueue) loadQueue() error {
 	return nil
 }
 
-func (u *UnitedQueue) newTopic(name string, persist bool) (*topic, error) {
+func (u *UnitedQueue) newTopic(name string, tc *topicConfig) (*topic, error) {
 	lines := make(map[string]*line)
 	t := new(topic)
 	t.name = name
-	t.persist = persist
+	t.persist = tc.persist
+	t.maxAge = tc.maxAge
+	t.maxCount = tc.maxCount
+	t.maxBytes = tc.maxBytes
+	t.dedup = tc.dedup
 	t.lines = lines
+	t.delays = make(map[uint64]int64)
+	t.dedups = make(map[string]*dedupEntry)
+	t.dedupKey = name + keyTopicDedup
 	t.head = 0
 	t.headKey = name + keyTopicHead
 	t.tail = 0
 	t.tailKey = name + keyTopicTail
 	t.q = u
 	t.quit = make(chan bool)
+	t.waitChan = make(chan bool)
 
 	err := t.exportHead()
 	if err != nil {
@@ -275,10 +363,15 @@ func (u *UnitedQueue) newTopic(name string, persist bool) (*topic, error) {
 	}
 
 	t.start()
+	err = u.newLevels(t, tc)
+	if err != nil {
+		t.remove()
+		return nil, err
+	}
 	return t, nil
 }
 
-func (u *UnitedQueue) createTopic(name string, persist, fromEtcd bool) error {
+func (u *UnitedQueue) createTopic(name string, tc *topicConfig, fromEtcd bool) error {
 	u.topicsLock.RLock()
 	_, ok := u.topics[name]
 	u.topicsLock.RUnlock()
@@ -289,7 +382,7 @@ func (u *UnitedQueue) createTopic(name string, persist, fromEtcd bool) error {
 		)
 	}
 
-	t, err := u.newTopic(name, persist)
+	t, err := u.newTopic(name, tc)
 	if err != nil {
 		return err
 	}
@@ -313,6 +406,9 @@ func (u *UnitedQueue) createTopic(name string, persist, fromEtcd bool) error {
 }
 
 func (u *UnitedQueue) create(key, arg string, fromEtcd bool) error {
+	u.backupLock.RLock()
+	defer u.backupLock.RUnlock()
+
 	key = strings.TrimPrefix(key, "/")
 	key = strings.TrimSuffix(key, "/")
 
@@ -333,22 +429,29 @@ func (u *UnitedQueue) create(key, arg string, fromEtcd bool) error {
 			`create topic is nil`,
 		)
 	}
+	if strings.Contains(topicName, levelSep) {
+		return utils.NewError(
+			utils.ErrBadKey,
+			`create topic name contains `+levelSep,
+		)
+	}
 
 	if len(parts) == 2 {
 		lineName = parts[1]
-		var recycle time.Duration
-		if arg != "" {
-			recycle, err = time.ParseDuration(arg)
-			if err != nil {
-				return utils.NewError(
-					utils.ErrBadRequest,
-					err.Error(),
-				)
-			}
+		lc, err := parseLineConfig(arg)
+		if err != nil {
+			return err
+		}
+		if lc.dead == topicName {
+			return utils.NewError(
+				utils.ErrBadRequest,
+				`line dead topic is itself`,
+			)
 		}
 
 		u.topicsLock.RLock()
 		t, ok := u.topics[topicName]
+		_, deadOk := u.topics[lc.dead]
 		u.topicsLock.RUnlock()
 		if !ok {
 			return utils.NewError(
@@ -356,18 +459,24 @@ func (u *UnitedQueue) create(key, arg string, fromEtcd bool) error {
 				`queue create`,
 			)
 		}
+		if lc.dead != "" && !deadOk {
+			return utils.NewError(
+				utils.ErrTopicNotExisted,
+				`queue create dead topic`,
+			)
+		}
 
-		err = t.createLine(lineName, recycle, fromEtcd)
+		err = t.createLine(lineName, lc, fromEtcd)
 		if err != nil {
 			// log.Printf("create line[%s] error: %s", lineName, err)
 			return err
 		}
 	} else {
-		var persist bool
-		if arg == "persist" {
-			persist = true
+		tc, err := parseTopicConfig(arg)
+		if err != nil {
+			return err
 		}
-		err = u.createTopic(topicName, persist, fromEtcd)
+		err = u.createTopic(topicName, tc, fromEtcd)
 		if err != nil {
 			// log.Printf("create topic[%s] error: %s", topicName, err)
 			return err
@@ -383,60 +492,141 @@ func (u *UnitedQueue) Create(key, arg string) error {
 }
 
 // Push implements Push interface
-func (u *UnitedQueue) Push(key string, data []byte) error {
-	key = strings.TrimPrefix(key, "/")
-	key = strings.TrimSuffix(key, "/")
+func (u *UnitedQueue) Push(key string, data []byte) (string, error) {
+	msg := new(Message)
+	msg.Body = data
+	return u.PushMessage(key, msg, nil)
+}
 
-	if len(data) <= 0 {
-		return utils.NewError(
+// PushMessage implements PushMessage interface, opts can be nil. A message
+// pushed again with the same dedup key in the dedup window of topic is not
+// appended, the id of the former one is returned.
+func (u *UnitedQueue) PushMessage(key string, msg *Message, opts *PushOptions) (string, error) {
+	if msg == nil || len(msg.Body) <= 0 {
+		return "", utils.NewError(
 			utils.ErrBadRequest,
 			`message has no content`,
 		)
 	}
+	if opts == nil {
+		opts = new(PushOptions)
+	}
+	if opts.Delay < 0 {
+		return "", utils.NewError(
+			utils.ErrBadRequest,
+			`message delay is negative`,
+		)
+	}
 
-	u.topicsLock.RLock()
-	t, ok := u.topics[key]
-	u.topicsLock.RUnlock()
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixNano()
+	}
+	data, err := encodeMessage(msg)
+	if err != nil {
+		return "", err
+	}
+
+	u.backupLock.RLock()
+	id, err := u.pushData(key, data, opts.Delay, opts.Dedup)
+	u.backupLock.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	metrics.Pushes.Inc()
+	return id, nil
+}
+
+// pushData pushes the encoded data into a topic and returns its id
+func (u *UnitedQueue) pushData(key string, data []byte, delay time.Duration, dedup string) (string, error) {
+	key = strings.TrimPrefix(key, "/")
+	key = strings.TrimSuffix(key, "/")
+
+	t, ok := u.getTopic(key)
 	if !ok {
-		return utils.NewError(
+		return "", utils.NewError(
 			utils.ErrTopicNotExisted,
 			`queue push`,
 		)
 	}
 
-	return t.push(data)
+	id, err := t.push(data, delay, dedup)
+	if err != nil {
+		return "", err
+	}
+	return utils.Acatui(key, "/", id), nil
 }
 
 // MultiPush implements MultiPush interface
-func (u *UnitedQueue) MultiPush(key string, datas [][]byte) error {
+func (u *UnitedQueue) MultiPush(key string, datas [][]byte) ([]string, error) {
 	key = strings.TrimPrefix(key, "/")
 	key = strings.TrimSuffix(key, "/")
 
 	for i, data := range datas {
 		if len(data) <= 0 {
 			cause := "message " + strconv.Itoa(i) + " has no content"
-			return utils.NewError(
+			return nil, utils.NewError(
 				utils.ErrBadRequest,
 				cause,
 			)
 		}
 	}
 
-	u.topicsLock.RLock()
-	t, ok := u.topics[key]
-	u.topicsLock.RUnlock()
+	t, ok := u.getTopic(key)
 	if !ok {
-		return utils.NewError(
+		return nil, utils.NewError(
 			utils.ErrTopicNotExisted,
 			`queue multiPush`,
 		)
 	}
 
-	return t.mPush(datas)
+	now := time.Now().UnixNano()
+	encoded := make([][]byte, len(datas))
+	for i, data := range datas {
+		msg := new(Message)
+		msg.Timestamp = now
+		msg.Body = data
+		var err error
+		encoded[i], err = encodeMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	u.backupLock.RLock()
+	first, err := t.mPush(encoded)
+	u.backupLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	metrics.Pushes.Add(uint64(len(encoded)))
+
+	ids := make([]string, len(encoded))
+	for i := range ids {
+		ids[i] = utils.Acatui(key, "/", first+uint64(i))
+	}
+	return ids, nil
 }
 
 // Pop implements Pop interface
 func (u *UnitedQueue) Pop(key string) (string, []byte, error) {
+	id, msg, err := u.PopMessage(key, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, msg.Body, nil
+}
+
+// PopWait implements PopWait interface
+func (u *UnitedQueue) PopWait(key string, timeout time.Duration) (string, []byte, error) {
+	id, msg, err := u.PopMessage(key, timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, msg.Body, nil
+}
+
+// PopMessage implements PopMessage interface
+func (u *UnitedQueue) PopMessage(key string, timeout time.Duration) (string, *Message, error) {
 	key = strings.TrimPrefix(key, "/")
 	key = strings.TrimSuffix(key, "/")
 
@@ -451,9 +641,7 @@ func (u *UnitedQueue) Pop(key string) (string, []byte, error) {
 	tName := parts[0]
 	lName := parts[1]
 
-	u.topicsLock.RLock()
-	t, ok := u.topics[tName]
-	u.topicsLock.RUnlock()
+	t, ok := u.getTopic(tName)
 	if !ok {
 		// log.Printf("topic[%s] not existed.", tName)
 		return "", nil, utils.NewError(
@@ -462,12 +650,13 @@ func (u *UnitedQueue) Pop(key string) (string, []byte, error) {
 		)
 	}
 
-	id, data, err := t.pop(lName)
+	lt, id, data, err := t.popLevel(lName, timeout)
 	if err != nil {
 		return "", nil, err
 	}
 
-	return utils.Acatui(key, "/", id), data, nil
+	metrics.Pops.Inc()
+	return utils.Acatui(lt.name+"/"+lName, "/", id), decodeMessage(data), nil
 }
 
 // MultiPop implements MultiPop interface
@@ -486,9 +675,7 @@ func (u *UnitedQueue) MultiPop(key string, n int) ([]string, [][]byte, error) {
 	tName := parts[0]
 	lName := parts[1]
 
-	u.topicsLock.RLock()
-	t, ok := u.topics[tName]
-	u.topicsLock.RUnlock()
+	t, ok := u.getTopic(tName)
 	if !ok {
 		// log.Printf("topic[%s] not existed.", tName)
 		return nil, nil, utils.NewError(
@@ -497,7 +684,59 @@ func (u *UnitedQueue) MultiPop(key string, n int) ([]string, [][]byte, error) {
 		)
 	}
 
-	ids, datas, err := t.mPop(lName, n)
+	lts, ids, datas, err := t.mPopLevel(lName, n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = utils.Acatui(lts[i].name+"/"+lName, "/", id)
+		datas[i] = decodeMessage(datas[i]).Body
+	}
+	metrics.Pops.Add(uint64(len(ids)))
+	return keys, datas, nil
+}
+
+// Peek implements Peek interface
+func (u *UnitedQueue) Peek(key string, offset uint64, n int) ([]string, [][]byte, error) {
+	key = strings.TrimPrefix(key, "/")
+	key = strings.TrimSuffix(key, "/")
+
+	parts := strings.Split(key, "/")
+	if len(parts) < 1 || len(parts) > 2 {
+		return nil, nil, utils.NewError(
+			utils.ErrBadKey,
+			`peek key parts error: `+utils.ItoaQuick(len(parts)),
+		)
+	}
+	if n <= 0 {
+		return nil, nil, utils.NewError(
+			utils.ErrBadRequest,
+			`peek n should be positive`,
+		)
+	}
+	// a peek is kept in memory as a whole, so it is limited to a batch
+	if uint64(n) > cleanBatchSize {
+		return nil, nil, utils.NewError(
+			utils.ErrBadRequest,
+			`peek n should be at most `+utils.ItoaQuick(int(cleanBatchSize)),
+		)
+	}
+
+	t, ok := u.getTopic(parts[0])
+	if !ok {
+		return nil, nil, utils.NewError(
+			utils.ErrTopicNotExisted,
+			`queue peek`,
+		)
+	}
+
+	var lineName string
+	if len(parts) == 2 {
+		lineName = parts[1]
+	}
+	ids, datas, err := t.peek(lineName, offset, n)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -505,44 +744,57 @@ func (u *UnitedQueue) MultiPop(key string, n int) ([]string, [][]byte, error) {
 	keys := make([]string, len(ids))
 	for i, id := range ids {
 		keys[i] = utils.Acatui(key, "/", id)
+		datas[i] = decodeMessage(datas[i]).Body
 	}
 	return keys, datas, nil
 }
 
-// Confirm implements Confirm interface
-func (u *UnitedQueue) Confirm(key string) error {
+func (u *UnitedQueue) getMsgTopic(key, op string) (*topic, string, uint64, error) {
 	key = strings.TrimPrefix(key, "/")
 	key = strings.TrimSuffix(key, "/")
 
 	parts := strings.Split(key, "/")
 	if len(parts) != 3 {
-		return utils.NewError(
+		return nil, "", 0, utils.NewError(
 			utils.ErrBadKey,
-			`confirm key parts error: `+utils.ItoaQuick(len(parts)),
+			op+` key parts error: `+utils.ItoaQuick(len(parts)),
 		)
 	}
 	topicName := parts[0]
 	lineName := parts[1]
 	id, err := strconv.ParseUint(parts[2], 10, 0)
 	if err != nil {
-		return utils.NewError(
+		return nil, "", 0, utils.NewError(
 			utils.ErrBadKey,
-			`confirm key parse id error: `+err.Error(),
+			op+` key parse id error: `+err.Error(),
 		)
 	}
 
-	u.topicsLock.RLock()
-	t, ok := u.topics[topicName]
-	u.topicsLock.RUnlock()
+	t, ok := u.getTopic(topicName)
 	if !ok {
 		// log.Printf("topic[%s] not existed.", topicName)
-		return utils.NewError(
+		return nil, "", 0, utils.NewError(
 			utils.ErrTopicNotExisted,
-			`queue confirm`,
+			`queue `+op,
 		)
 	}
 
-	return t.confirm(lineName, id)
+	return t, lineName, id, nil
+}
+
+// Confirm implements Confirm interface
+func (u *UnitedQueue) Confirm(key string) error {
+	t, lineName, id, err := u.getMsgTopic(key, "confirm")
+	if err != nil {
+		return err
+	}
+
+	err = t.confirm(lineName, id)
+	if err != nil {
+		return err
+	}
+	metrics.Confirms.Inc()
+	return nil
 }
 
 // MultiConfirm implements MultiConfirm interface
@@ -554,6 +806,43 @@ func (u *UnitedQueue) MultiConfirm(keys []string) []error {
 	return errs
 }
 
+// Nack implements Nack interface
+func (u *UnitedQueue) Nack(key string) error {
+	t, lineName, id, err := u.getMsgTopic(key, "nack")
+	if err != nil {
+		return err
+	}
+
+	err = t.nack(lineName, id)
+	if err != nil {
+		return err
+	}
+	metrics.Nacks.Inc()
+	return nil
+}
+
+// Touch implements Touch interface
+func (u *UnitedQueue) Touch(key string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return utils.NewError(
+			utils.ErrBadRequest,
+			`touch timeout should be positive`,
+		)
+	}
+
+	t, lineName, id, err := u.getMsgTopic(key, "touch")
+	if err != nil {
+		return err
+	}
+
+	err = t.touch(lineName, id, timeout)
+	if err != nil {
+		return err
+	}
+	metrics.Touches.Inc()
+	return nil
+}
+
 // Stat implements Stat interface
 func (u *UnitedQueue) Stat(key string) (*Stat, error) {
 	key = strings.TrimPrefix(key, "/")
@@ -576,9 +865,7 @@ func (u *UnitedQueue) Stat(key string) (*Stat, error) {
 		)
 	}
 
-	u.topicsLock.RLock()
-	t, ok := u.topics[topicName]
-	u.topicsLock.RUnlock()
+	t, ok := u.getTopic(topicName)
 	if !ok {
 		return nil, utils.NewError(
 			utils.ErrTopicNotExisted,
@@ -595,6 +882,27 @@ func (u *UnitedQueue) Stat(key string) (*Stat, error) {
 	return qs, nil
 }
 
+// List implements List interface
+func (u *UnitedQueue) List() ([]*Stat, error) {
+	u.topicsLock.RLock()
+	names := make([]string, 0, len(u.topics))
+	for name := range u.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	topics := make([]*topic, len(names))
+	for i, name := range names {
+		topics[i] = u.topics[name]
+	}
+	u.topicsLock.RUnlock()
+
+	qss := make([]*Stat, len(topics))
+	for i, t := range topics {
+		qss[i] = t.stat()
+	}
+	return qss, nil
+}
+
 // Empty implements Empty interface
 func (u *UnitedQueue) Empty(key string) error {
 	key = strings.TrimPrefix(key, "/")
@@ -628,6 +936,9 @@ func (u *UnitedQueue) Empty(key string) error {
 		)
 	}
 
+	u.backupLock.RLock()
+	defer u.backupLock.RUnlock()
+
 	if len(parts) == 2 {
 		lineName = parts[1]
 		return t.emptyLine(lineName)
@@ -641,6 +952,55 @@ func (u *UnitedQueue) Empty(key string) error {
 	return t.empty()
 }
 
+func (u *UnitedQueue) getLineTopic(key, op string) (*topic, string, error) {
+	key = strings.TrimPrefix(key, "/")
+	key = strings.TrimSuffix(key, "/")
+
+	parts := strings.Split(key, "/")
+	if len(parts) != 2 {
+		return nil, "", utils.NewError(
+			utils.ErrBadKey,
+			op+` key parts error: `+utils.ItoaQuick(len(parts)),
+		)
+	}
+
+	u.topicsLock.RLock()
+	t, ok := u.topics[parts[0]]
+	u.topicsLock.RUnlock()
+	if !ok {
+		return nil, "", utils.NewError(
+			utils.ErrTopicNotExisted,
+			`queue `+op,
+		)
+	}
+
+	return t, parts[1], nil
+}
+
+// Seek implements Seek interface
+func (u *UnitedQueue) Seek(key string, offset uint64) error {
+	t, lineName, err := u.getLineTopic(key, "seek")
+	if err != nil {
+		return err
+	}
+
+	u.backupLock.RLock()
+	defer u.backupLock.RUnlock()
+	return t.seek(lineName, offset)
+}
+
+// SeekTime implements SeekTime interface
+func (u *UnitedQueue) SeekTime(key string, ts time.Time) error {
+	t, lineName, err := u.getLineTopic(key, "seekTime")
+	if err != nil {
+		return err
+	}
+
+	u.backupLock.RLock()
+	defer u.backupLock.RUnlock()
+	return t.seekTime(lineName, ts)
+}
+
 func (u *UnitedQueue) removeTopic(name string, fromEtcd bool) error {
 	u.topicsLock.Lock()
 	defer u.topicsLock.Unlock()
@@ -668,6 +1028,9 @@ func (u *UnitedQueue) removeTopic(name string, fromEtcd bool) error {
 }
 
 func (u *UnitedQueue) remove(key string, fromEtcd bool) error {
+	u.backupLock.RLock()
+	defer u.backupLock.RUnlock()
+
 	key = strings.TrimPrefix(key, "/")
 	key = strings.TrimSuffix(key, "/")
 
@@ -718,7 +1081,9 @@ func (u *UnitedQueue) Close() {
 	u.wg.Wait()
 
 	for _, t := range u.topics {
-		t.close()
+		for _, lt := range t.allLevels() {
+			lt.close()
+		}
 	}
 
 	err := u.exportTopics()
@@ -3,7 +3,9 @@ package queue
 import (
 	"os"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/buaazp/uq/store"
 	. "github.com/smartystreets/goconvey/convey"
@@ -78,8 +80,9 @@ func TestCreateLine(t *testing.T) {
 func TestPush(t *testing.T) {
 	Convey("Test Push a Message", t, func() {
 		data := []byte("1")
-		err = uq.Push("foo", data)
+		id, err := uq.Push("foo", data)
 		So(err, ShouldBeNil)
+		So(id, ShouldEqual, "foo/0")
 	})
 }
 
@@ -89,8 +92,9 @@ func TestMultiPush(t *testing.T) {
 		for i := 0; i < 5; i++ {
 			datas[i] = []byte(strconv.Itoa(i + 2))
 		}
-		err = uq.MultiPush("foo", datas)
+		ids, err := uq.MultiPush("foo", datas)
 		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []string{"foo/1", "foo/2", "foo/3", "foo/4", "foo/5"})
 	})
 }
 
@@ -112,6 +116,393 @@ func TestMultiPop(t *testing.T) {
 	})
 }
 
+func TestPopWait(t *testing.T) {
+	Convey("Test Pop a Message with Timeout", t, func() {
+		_, _, err := uq.PopWait("foo/x", 10*time.Millisecond)
+		So(err, ShouldNotBeNil)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			uq.Push("foo", []byte("7"))
+		}()
+		_, msg, err := uq.PopWait("foo/x", time.Second)
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "7")
+	})
+}
+
+func TestPushDelay(t *testing.T) {
+	Convey("Test Push a Delayed Message", t, func() {
+		_, err = uq.PushMessage("foo", &Message{Body: []byte("8")}, &PushOptions{Delay: 20 * time.Millisecond})
+		So(err, ShouldBeNil)
+
+		_, _, err := uq.Pop("foo/x")
+		So(err, ShouldNotBeNil)
+
+		_, msg, err := uq.PopWait("foo/x", time.Second)
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "8")
+	})
+}
+
+func TestDelayedNotDelivered(t *testing.T) {
+	Convey("Test a Delayed Message Is Not Delivered Before Deliver Time", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		dq, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer dq.Close()
+		So(dq.Create("p", ""), ShouldBeNil)
+		So(dq.Create("p/l", "10s"), ShouldBeNil)
+		_, err = dq.PushMessage("p", &Message{Body: []byte("1")}, &PushOptions{Delay: time.Hour})
+		So(err, ShouldBeNil)
+		_, _, err = dq.Pop("p/l")
+		So(err, ShouldNotBeNil)
+
+		l := dq.topics["p"].lines["l"]
+		deliver := l.inflight.Front().Value.(*InflightMessage).Exptime
+		So(dq.Nack("p/l/0"), ShouldNotBeNil)
+		So(dq.Touch("p/l/0", time.Second), ShouldNotBeNil)
+		So(dq.Confirm("p/l/0"), ShouldNotBeNil)
+		So(l.inflight.Len(), ShouldEqual, 1)
+		So(l.inflight.Front().Value.(*InflightMessage).Exptime, ShouldEqual, deliver)
+		_, _, err = dq.Pop("p/l")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestConcurrentPopNack(t *testing.T) {
+	Convey("Test Pop With Nack, Touch and Confirm Concurrently", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		cq, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		So(cq.Create("cc", ""), ShouldBeNil)
+		So(cq.Create("cc/x", "10s"), ShouldBeNil)
+		for i := 0; i < 100; i++ {
+			_, err = cq.Push("cc", []byte(strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+		}
+
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 2000; i++ {
+					id, _, err := cq.Pop("cc/x")
+					if err != nil {
+						continue
+					}
+					switch (w + i) % 3 {
+					case 0:
+						cq.Nack(id)
+					case 1:
+						cq.Touch(id, time.Millisecond)
+					default:
+						cq.Confirm(id)
+						cq.Push("cc", []byte(id))
+					}
+				}
+			}(w)
+		}
+		done := make(chan bool)
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		deadlocked := false
+		select {
+		case <-done:
+			cq.Close()
+		case <-time.After(30 * time.Second):
+			deadlocked = true
+		}
+		So(deadlocked, ShouldBeFalse)
+	})
+}
+
+func TestConcurrentExport(t *testing.T) {
+	Convey("Test Export Lines With Pop and Confirm Concurrently", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		cq, err := NewUnitedQueue(mdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer cq.Close()
+		So(cq.Create("ce", ""), ShouldBeNil)
+		So(cq.Create("ce/x", "10s"), ShouldBeNil)
+		for i := 0; i < 100; i++ {
+			_, err = cq.Push("ce", []byte(strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+		}
+
+		var wg sync.WaitGroup
+		done := make(chan bool)
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						cq.exportTopics()
+					}
+				}
+			}()
+		}
+		for i := 0; i < 2000; i++ {
+			id, _, err := cq.Pop("ce/x")
+			So(err, ShouldBeNil)
+			So(cq.Confirm(id), ShouldBeNil)
+			_, err = cq.Push("ce", []byte(id))
+			So(err, ShouldBeNil)
+		}
+		close(done)
+		wg.Wait()
+
+		ls, err := cq.Stat("ce/x")
+		So(err, ShouldBeNil)
+		So(ls.Inflight, ShouldEqual, 0)
+	})
+}
+
+func TestLoadOldInflights(t *testing.T) {
+	Convey("Test Inflights Saved Without Attempts Are Delivered", t, func() {
+		logPath := "/tmp/uq.queue.test.oldinflights"
+		os.RemoveAll(logPath)
+		defer os.RemoveAll(logPath)
+
+		lsdb, err := store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		oq, err := NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		So(oq.Create("old", ""), ShouldBeNil)
+		So(oq.Create("old/x", "1h"), ShouldBeNil)
+		_, err = oq.Push("old", []byte("1"))
+		So(err, ShouldBeNil)
+		_, _, err = oq.Pop("old/x")
+		So(err, ShouldBeNil)
+		oq.Close()
+
+		// an older uq saves the inflights in line store without journal
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		ls := new(UnitedLineStore)
+		ls.Head = 1
+		ls.Inflights = []*InflightMessage{{Tid: 0, Exptime: time.Now().Add(time.Hour).UnixNano()}}
+		data, err := ls.Marshal()
+		So(err, ShouldBeNil)
+		So(lsdb.Set("old/x", data), ShouldBeNil)
+		So(lsdb.Del("old/x"+keyLineHead), ShouldBeNil)
+		oq, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		defer oq.Close()
+		So(oq.Confirm("old/x/0"), ShouldBeNil)
+	})
+}
+
+func TestDeadLetter(t *testing.T) {
+	Convey("Test Move a Message to Dead Topic", t, func() {
+		err := uq.Create("bar_dead", "")
+		So(err, ShouldBeNil)
+		err = uq.Create("bar_dead/x", "")
+		So(err, ShouldBeNil)
+		err = uq.Create("bar", "")
+		So(err, ShouldBeNil)
+		err = uq.Create("bar/x", "10ms,attempts=1,dead=bar")
+		So(err, ShouldNotBeNil)
+		err = uq.Create("bar/x", "10ms,attempts=1,dead=bar_dead")
+		So(err, ShouldBeNil)
+
+		qs, err := uq.Stat("bar/x")
+		So(err, ShouldBeNil)
+		So(qs.Attempts, ShouldEqual, 1)
+		So(qs.Dead, ShouldEqual, "bar_dead")
+
+		_, err = uq.Push("bar", []byte("1"))
+		So(err, ShouldBeNil)
+		_, msg, err := uq.Pop("bar/x")
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "1")
+
+		time.Sleep(20 * time.Millisecond)
+		_, _, err = uq.Pop("bar/x")
+		So(err, ShouldNotBeNil)
+
+		_, msg, err = uq.Pop("bar_dead/x")
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "1")
+	})
+}
+
+func TestNackTouch(t *testing.T) {
+	Convey("Test Nack and Touch a Message", t, func() {
+		err := uq.Create("bar/y", "10s")
+		So(err, ShouldBeNil)
+		_, err = uq.Push("bar", []byte("2"))
+		So(err, ShouldBeNil)
+
+		id, msg, err := uq.Pop("bar/y")
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "1")
+
+		err = uq.Nack(id)
+		So(err, ShouldBeNil)
+		nid, msg, err := uq.Pop("bar/y")
+		So(err, ShouldBeNil)
+		So(nid, ShouldEqual, id)
+		So(string(msg), ShouldEqual, "1")
+
+		err = uq.Touch(id, 0)
+		So(err, ShouldNotBeNil)
+		err = uq.Touch(id, 10*time.Millisecond)
+		So(err, ShouldBeNil)
+		_, msg, err = uq.Pop("bar/y")
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "2")
+		time.Sleep(20 * time.Millisecond)
+		nid, msg, err = uq.Pop("bar/y")
+		So(err, ShouldBeNil)
+		So(nid, ShouldEqual, id)
+		So(string(msg), ShouldEqual, "1")
+
+		err = uq.Nack("bar/y/100")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPushMessage(t *testing.T) {
+	Convey("Test Push a Message with Metadata", t, func() {
+		err := uq.Create("meta", "")
+		So(err, ShouldBeNil)
+		err = uq.Create("meta/x", "")
+		So(err, ShouldBeNil)
+
+		msg := new(Message)
+		msg.ContentType = "application/json"
+		msg.Body = []byte(`{"a":1}`)
+		msg.SetHeader("producer", "tester")
+		_, err = uq.PushMessage("meta", msg, nil)
+		So(err, ShouldBeNil)
+
+		_, pmsg, err := uq.PopMessage("meta/x", 0)
+		So(err, ShouldBeNil)
+		So(string(pmsg.Body), ShouldEqual, `{"a":1}`)
+		So(pmsg.ContentType, ShouldEqual, "application/json")
+		So(pmsg.GetHeader("producer"), ShouldEqual, "tester")
+		So(pmsg.Timestamp, ShouldBeGreaterThan, 0)
+	})
+	Convey("Test Decode a Raw Message", t, func() {
+		msg := decodeMessage([]byte("raw"))
+		So(string(msg.Body), ShouldEqual, "raw")
+		So(msg.Timestamp, ShouldEqual, 0)
+	})
+}
+
+func TestRetention(t *testing.T) {
+	Convey("Test Topic Retention Policy", t, func() {
+		err := uq.Create("ret", "age=-1s")
+		So(err, ShouldNotBeNil)
+		err = uq.Create("ret", "persist,count=2")
+		So(err, ShouldBeNil)
+		err = uq.Create("ret/x", "10s")
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 5; i++ {
+			_, err = uq.Push("ret", []byte(strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+		}
+		_, msg, err := uq.Pop("ret/x")
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "0")
+
+		uq.topics["ret"].clean()
+
+		qs, err := uq.Stat("ret")
+		So(err, ShouldBeNil)
+		So(qs.Retention, ShouldEqual, "count=2")
+		So(qs.Head, ShouldEqual, 3)
+		So(qs.Lines[0].Skipped, ShouldEqual, 3)
+
+		_, msg, err = uq.Pop("ret/x")
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "3")
+	})
+}
+
+func TestSeek(t *testing.T) {
+	Convey("Test Seek a Line", t, func() {
+		err := uq.Create("seek", "persist")
+		So(err, ShouldBeNil)
+		err = uq.Create("seek/x", "10s")
+		So(err, ShouldBeNil)
+
+		_, err = uq.Push("seek", []byte("0"))
+		So(err, ShouldBeNil)
+		ts := time.Now()
+		_, err = uq.Push("seek", []byte("1"))
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 2; i++ {
+			_, _, err = uq.Pop("seek/x")
+			So(err, ShouldBeNil)
+		}
+
+		err = uq.Seek("seek/x", 3)
+		So(err, ShouldNotBeNil)
+		err = uq.Seek("seek/x", 0)
+		So(err, ShouldBeNil)
+		qs, err := uq.Stat("seek/x")
+		So(err, ShouldBeNil)
+		So(qs.Head, ShouldEqual, 0)
+		So(qs.Count, ShouldEqual, 2)
+		_, msg, err := uq.Pop("seek/x")
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "0")
+
+		err = uq.SeekTime("seek/x", ts)
+		So(err, ShouldBeNil)
+		_, msg, err = uq.Pop("seek/x")
+		So(err, ShouldBeNil)
+		So(string(msg), ShouldEqual, "1")
+	})
+}
+
+func TestPeek(t *testing.T) {
+	Convey("Test Peek Messages", t, func() {
+		qs, err := uq.Stat("seek/x")
+		So(err, ShouldBeNil)
+		head := qs.Head
+
+		ids, msgs, err := uq.Peek("seek", 0, 5)
+		So(err, ShouldBeNil)
+		So(len(ids), ShouldEqual, 2)
+		So(ids[0], ShouldEqual, "seek/0")
+		So(string(msgs[1]), ShouldEqual, "1")
+
+		err = uq.Seek("seek/x", 0)
+		So(err, ShouldBeNil)
+		ids, msgs, err = uq.Peek("seek/x", 1, 5)
+		So(err, ShouldBeNil)
+		So(len(ids), ShouldEqual, 1)
+		So(ids[0], ShouldEqual, "seek/x/1")
+		So(string(msgs[0]), ShouldEqual, "1")
+
+		_, _, err = uq.Peek("seek/x", 2, 1)
+		So(err, ShouldNotBeNil)
+		_, _, err = uq.Peek("seek/x", 0, 1025)
+		So(err, ShouldNotBeNil)
+
+		qs, err = uq.Stat("seek/x")
+		So(err, ShouldBeNil)
+		So(qs.Head, ShouldEqual, 0)
+		So(head, ShouldEqual, 2)
+	})
+}
+
 func TestConfirm(t *testing.T) {
 	Convey("Test Confirm a Message", t, func() {
 		id, msg, err := uq.Pop("foo/y")
@@ -153,6 +544,20 @@ func TestStat(t *testing.T) {
 	})
 }
 
+func TestList(t *testing.T) {
+	Convey("Test List Topics", t, func() {
+		qss, err := uq.List()
+		So(err, ShouldBeNil)
+		So(len(qss), ShouldEqual, len(uq.topics))
+		for i := 1; i < len(qss); i++ {
+			So(qss[i-1].Name < qss[i].Name, ShouldBeTrue)
+		}
+		for _, qs := range qss {
+			So(qs.Type, ShouldEqual, "topic")
+		}
+	})
+}
+
 func TestEmpty(t *testing.T) {
 	Convey("Test Empty Line", t, func() {
 		key := "foo/y"
@@ -212,3 +617,122 @@ func TestLoad(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 }
+
+func TestLoadLogStore(t *testing.T) {
+	Convey("Test Load Queue On Log Store", t, func() {
+		logPath := "/tmp/uq.queue.test.log"
+		os.RemoveAll(logPath)
+		defer os.RemoveAll(logPath)
+
+		lsdb, err := store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err := NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		err = uq.Create("foo", "")
+		So(err, ShouldBeNil)
+		err = uq.Create("foo/x", "")
+		So(err, ShouldBeNil)
+		for i := 0; i < 3; i++ {
+			_, err = uq.Push("foo", []byte(strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+		}
+		_, data, err := uq.Pop("foo/x")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "0")
+		uq.Close()
+
+		lsdb, err = store.NewLogStore(logPath)
+		So(err, ShouldBeNil)
+		uq, err = NewUnitedQueue(lsdb, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		qs, err := uq.Stat("foo")
+		So(err, ShouldBeNil)
+		So(qs.Tail, ShouldEqual, 3)
+		_, data, err = uq.Pop("foo/x")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "1")
+		uq.Close()
+	})
+}
+
+// countingStore counts the calls to a storage
+type countingStore struct {
+	store.Storage
+	sets, gets, dels int
+	multiSets        int
+	multiGets        int
+	delRanges        int
+}
+
+func (c *countingStore) Set(key string, data []byte) error {
+	c.sets++
+	return c.Storage.Set(key, data)
+}
+
+func (c *countingStore) Get(key string) ([]byte, error) {
+	c.gets++
+	return c.Storage.Get(key)
+}
+
+func (c *countingStore) Del(key string) error {
+	c.dels++
+	return c.Storage.Del(key)
+}
+
+func (c *countingStore) MultiSet(keys []string, datas [][]byte) error {
+	c.multiSets++
+	return c.Storage.MultiSet(keys, datas)
+}
+
+func (c *countingStore) MultiGet(keys []string) ([][]byte, error) {
+	c.multiGets++
+	return c.Storage.MultiGet(keys)
+}
+
+func (c *countingStore) DelRange(prefix string, start, end uint64) error {
+	c.delRanges++
+	return c.Storage.DelRange(prefix, start, end)
+}
+
+func TestBatchWrites(t *testing.T) {
+	Convey("Test Batch Writes Of Multi Operations", t, func() {
+		mdb, err := store.NewMemStore()
+		So(err, ShouldBeNil)
+		cs := &countingStore{Storage: mdb}
+		uq, err := NewUnitedQueue(cs, "127.0.0.1", 9689, nil, "uq")
+		So(err, ShouldBeNil)
+		err = uq.Create("batch", "")
+		So(err, ShouldBeNil)
+		err = uq.Create("batch/x", "")
+		So(err, ShouldBeNil)
+
+		datas := make([][]byte, 1000)
+		for i := range datas {
+			datas[i] = []byte(strconv.Itoa(i))
+		}
+		*cs = countingStore{Storage: mdb}
+		_, err = uq.MultiPush("batch", datas)
+		So(err, ShouldBeNil)
+		So(cs.multiSets, ShouldEqual, 1)
+		So(cs.sets, ShouldEqual, 0)
+
+		*cs = countingStore{Storage: mdb}
+		_, msgs, err := uq.MultiPop("batch/x", 1000)
+		So(err, ShouldBeNil)
+		So(len(msgs), ShouldEqual, 1000)
+		So(string(msgs[999]), ShouldEqual, "999")
+		So(cs.multiGets, ShouldEqual, 1)
+		So(cs.gets, ShouldEqual, 0)
+		So(cs.multiSets, ShouldEqual, 1)
+		So(cs.sets, ShouldEqual, 0)
+
+		*cs = countingStore{Storage: mdb}
+		err = uq.Remove("batch")
+		So(err, ShouldBeNil)
+		// the messages of topic and the journal of line
+		So(cs.delRanges, ShouldEqual, 2)
+		_, err = mdb.Get("batch:0")
+		So(err, ShouldNotBeNil)
+		uq.Close()
+	})
+}
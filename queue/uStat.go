@@ -8,14 +8,23 @@ import (
 
 // Stat is the Stat of a UnitedQueue
 type Stat struct {
-	Name    string  `json:"name"`
-	Type    string  `json:"type"`
-	Lines   []*Stat `json:"lines,omitempty"`
-	Recycle string  `json:"recycle,omitempty"`
-	Head    uint64  `json:"head"`
-	IHead   uint64  `json:"ihead"`
-	Tail    uint64  `json:"tail"`
-	Count   uint64  `json:"count"`
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	Lines      []*Stat `json:"lines,omitempty"`
+	Priorities []*Stat `json:"priorities,omitempty"`
+	Recycle    string  `json:"recycle,omitempty"`
+	Attempts   uint64  `json:"attempts,omitempty"`
+	Dead       string  `json:"dead,omitempty"`
+	Filter     string  `json:"filter,omitempty"`
+	Retention  string  `json:"retention,omitempty"`
+	Dedup      string  `json:"dedup,omitempty"`
+	Skipped    uint64  `json:"skipped,omitempty"`
+	Inflight   uint64  `json:"inflight,omitempty"`
+	Parked     uint64  `json:"parked,omitempty"`
+	Head       uint64  `json:"head"`
+	IHead      uint64  `json:"ihead"`
+	Tail       uint64  `json:"tail"`
+	Count      uint64  `json:"count"`
 }
 
 // ToString returns the string of Stat
@@ -42,12 +51,34 @@ func (q *Stat) ToStrings() []string {
 	replys = append(replys, "name:"+q.Name)
 	if q.Type == "line" {
 		replys = append(replys, "recycle:"+q.Recycle)
+		if q.Attempts > 0 {
+			replys = append(replys, "attempts:"+strconv.FormatUint(q.Attempts, 10))
+		}
+		if q.Dead != "" {
+			replys = append(replys, "dead:"+q.Dead)
+		}
+		if q.Filter != "" {
+			replys = append(replys, "filter:"+q.Filter)
+		}
+	} else {
+		if q.Retention != "" {
+			replys = append(replys, "retention:"+q.Retention)
+		}
+		if q.Dedup != "" {
+			replys = append(replys, "dedup:"+q.Dedup)
+		}
 	}
 
 	replys = append(replys, "head:"+strconv.FormatUint(q.Head, 10))
 	if q.Type == "line" {
 		replys = append(replys, "ihead:"+strconv.FormatUint(q.IHead, 10))
 	}
+	if q.Skipped > 0 {
+		replys = append(replys, "skipped:"+strconv.FormatUint(q.Skipped, 10))
+	}
+	if q.Parked > 0 {
+		replys = append(replys, "parked:"+strconv.FormatUint(q.Parked, 10))
+	}
 	replys = append(replys, "tail:"+strconv.FormatUint(q.Tail, 10))
 	replys = append(replys, "count:"+strconv.FormatUint(q.Count, 10))
 
@@ -58,6 +89,11 @@ func (q *Stat) ToStrings() []string {
 			replys = append(replys, ls...)
 		}
 	}
+	for _, levelStat := range q.Priorities {
+		replys = append(replys, "")
+		ps := levelStat.ToStrings()
+		replys = append(replys, ps...)
+	}
 
 	return replys
 }
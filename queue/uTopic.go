@@ -4,15 +4,25 @@ import (
 	"container/list"
 	"encoding/binary"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/buaazp/uq/utils"
 )
 
 type topic struct {
+	// bytes is accessed atomically and kept first for 64-bit alignment
+	bytes uint64
+
 	name      string
 	persist   bool
+	maxAge    time.Duration
+	maxCount  uint64
+	maxBytes  uint64
 	lines     map[string]*line
 	linesLock sync.RWMutex
 	head      uint64
@@ -23,10 +33,151 @@ type topic struct {
 	tailKey   string
 	q         *UnitedQueue
 
+	// archives counts the archives being written of topic, the messages
+	// are not cleaned until they are all written
+	archives int32
+
+	waitChan chan bool
+	waitLock sync.Mutex
+
+	delayed    bool
+	delays     map[uint64]int64
+	delaysLock sync.RWMutex
+
+	// dedups are guarded by tailLock, the log keeps them in push order
+	dedup     time.Duration
+	dedups    map[string]*dedupEntry
+	dedupLog  []*dedupEntry
+	dedupHead uint64
+	dedupKey  string
+
+	// levels are the priority levels from low to high, levels[0] is the
+	// topic itself and the others are kept as topics named like foo~1
+	levels []*topic
+	parent *topic
+
 	quit chan bool
 	wg   sync.WaitGroup
 }
 
+// topicConfig is the config of a topic which is given by the create arg,
+// the arg looks like "persist,age=24h,count=100000,bytes=1073741824,dedup=10m,priorities=3"
+type topicConfig struct {
+	persist    bool
+	maxAge     time.Duration
+	maxCount   uint64
+	maxBytes   uint64
+	dedup      time.Duration
+	priorities uint64
+}
+
+func parseTopicConfig(arg string) (*topicConfig, error) {
+	tc := new(topicConfig)
+	if arg == "" {
+		return tc, nil
+	}
+
+	var err error
+	for _, opt := range strings.Split(arg, ",") {
+		if opt == "" {
+			continue
+		}
+		if opt == "persist" {
+			tc.persist = true
+			continue
+		}
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				`topic option error: `+opt,
+			)
+		}
+		switch kv[0] {
+		case "age":
+			tc.maxAge, err = time.ParseDuration(kv[1])
+			if err == nil && tc.maxAge < 0 {
+				return nil, utils.NewError(
+					utils.ErrBadRequest,
+					`topic age is negative`,
+				)
+			}
+		case "count":
+			tc.maxCount, err = strconv.ParseUint(kv[1], 10, 0)
+		case "bytes":
+			tc.maxBytes, err = strconv.ParseUint(kv[1], 10, 0)
+		case "dedup":
+			tc.dedup, err = time.ParseDuration(kv[1])
+			if err == nil && tc.dedup < 0 {
+				return nil, utils.NewError(
+					utils.ErrBadRequest,
+					`topic dedup is negative`,
+				)
+			}
+		case "priorities":
+			tc.priorities, err = strconv.ParseUint(kv[1], 10, 0)
+			if err == nil && (tc.priorities == 0 || tc.priorities > maxPriorities) {
+				return nil, utils.NewError(
+					utils.ErrBadRequest,
+					`topic priorities should be in [1, `+strconv.FormatUint(maxPriorities, 10)+`]`,
+				)
+			}
+		default:
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				`topic option unknown: `+kv[0],
+			)
+		}
+		if err != nil {
+			return nil, utils.NewError(
+				utils.ErrBadRequest,
+				err.Error(),
+			)
+		}
+	}
+	return tc, nil
+}
+
+// retention returns the retention policy string of topic
+func (t *topic) retention() string {
+	var opts []string
+	if t.maxAge > 0 {
+		opts = append(opts, "age="+t.maxAge.String())
+	}
+	if t.maxCount > 0 {
+		opts = append(opts, "count="+strconv.FormatUint(t.maxCount, 10))
+	}
+	if t.maxBytes > 0 {
+		opts = append(opts, "bytes="+strconv.FormatUint(t.maxBytes, 10))
+	}
+	return strings.Join(opts, ",")
+}
+
+func (t *topic) retained() bool {
+	return t.maxAge > 0 || t.maxCount > 0 || t.maxBytes > 0
+}
+
+func (t *topic) addBytes(n int) {
+	atomic.AddUint64(&t.bytes, uint64(n))
+}
+
+func (t *topic) subBytes(n int) {
+	atomic.AddUint64(&t.bytes, ^uint64(n-1))
+}
+
+// loadBytes counts the data size of all messages in topic
+func (t *topic) loadBytes() {
+	var bytes uint64
+	for id := t.head; id < t.tail; id++ {
+		data, err := t.getData(id)
+		if err != nil {
+			continue
+		}
+		bytes += uint64(len(data))
+	}
+	atomic.StoreUint64(&t.bytes, bytes)
+}
+
 func (t *topic) getData(id uint64) ([]byte, error) {
 	key := utils.Acatui(t.name, ":", id)
 	return t.q.getData(key)
@@ -37,6 +188,16 @@ func (t *topic) setData(id uint64, data []byte) error {
 	return t.q.setData(key, data)
 }
 
+// getDatas gets the datas of messages by ids, the data of a message not
+// existed is nil
+func (t *topic) getDatas(ids []uint64) ([][]byte, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = utils.Acatui(t.name, ":", id)
+	}
+	return t.q.multiGetData(keys)
+}
+
 func (t *topic) getHead() uint64 {
 	t.headLock.RLock()
 	defer t.headLock.RUnlock()
@@ -49,6 +210,105 @@ func (t *topic) getTail() uint64 {
 	return t.tail
 }
 
+func (t *topic) delayKey(id uint64) string {
+	return utils.Acatui(t.name, ":", id) + keyMsgDelay
+}
+
+// getDelay returns the deliver time of a delayed message, or 0 if the
+// message can be delivered at once
+func (t *topic) getDelay(id uint64) int64 {
+	t.delaysLock.RLock()
+	defer t.delaysLock.RUnlock()
+	return t.delays[id]
+}
+
+// addDelay keeps the deliver time of a delayed message whose delay key
+// has been written
+func (t *topic) addDelay(id uint64, deliver int64) {
+	t.delaysLock.Lock()
+	defer t.delaysLock.Unlock()
+	t.delays[id] = deliver
+}
+
+// removeDelays removes the delays which match the remove func
+func (t *topic) removeDelays(remove func(id uint64, deliver int64) bool) {
+	t.delaysLock.Lock()
+	defer t.delaysLock.Unlock()
+
+	for id, deliver := range t.delays {
+		if !remove(id, deliver) {
+			continue
+		}
+		key := t.delayKey(id)
+		err := t.q.delData(key)
+		if err != nil {
+			log.Printf("topic[%s] del %s error; %s", t.name, key, err)
+			continue
+		}
+		delete(t.delays, id)
+	}
+}
+
+// pruneDelays removes the delays which are due already, lines will
+// deliver these messages as normal ones
+func (t *topic) pruneDelays() {
+	now := time.Now().UnixNano()
+	t.removeDelays(func(id uint64, deliver int64) bool {
+		return deliver <= now
+	})
+}
+
+func (t *topic) loadDelays() {
+	t.delaysLock.Lock()
+	defer t.delaysLock.Unlock()
+
+	for i := t.head; i < t.tail; i++ {
+		delayData, err := t.q.getData(t.delayKey(i))
+		if err != nil || len(delayData) != 8 {
+			continue
+		}
+		t.delays[i] = int64(binary.LittleEndian.Uint64(delayData))
+	}
+}
+
+// markDelayed marks the topic has delayed messages in it so that the
+// delays will be loaded when uq restarts
+func (t *topic) markDelayed() error {
+	t.linesLock.RLock()
+	defer t.linesLock.RUnlock()
+
+	if t.delayed {
+		return nil
+	}
+
+	t.delayed = true
+	err := t.exportTopic()
+	if err != nil {
+		t.delayed = false
+		return err
+	}
+	return nil
+}
+
+// waitPush returns a channel which is closed when the tail of topic moves
+func (t *topic) waitPush() <-chan bool {
+	t.waitLock.Lock()
+	defer t.waitLock.Unlock()
+	return t.waitChan
+}
+
+func (t *topic) notifyPush() {
+	t.waitLock.Lock()
+	close(t.waitChan)
+	t.waitChan = make(chan bool)
+	t.waitLock.Unlock()
+
+	// the consumers of a priority topic wait on the topic itself
+	if t.parent != nil {
+		t.parent.notifyPush()
+	}
+}
+
 func (t *topic) exportHead() error {
 	topicHeadData := make([]byte, 8)
 	binary.LittleEndian.PutUint64(topicHeadData, t.head)
@@ -96,6 +356,12 @@ func (t *topic) genTopicStore() *UnitedTopicStore {
 	ts := new(UnitedTopicStore)
 	ts.Lines = lines
 	ts.Persist = t.persist
+	ts.Delayed = t.delayed
+	ts.MaxAge = int64(t.maxAge)
+	ts.MaxCount = t.maxCount
+	ts.MaxBytes = t.maxBytes
+	ts.Dedup = int64(t.dedup)
+	ts.Priorities = uint64(len(t.levels))
 
 	return ts
 }
@@ -132,11 +398,13 @@ func (t *topic) exportLines() error {
 	defer t.linesLock.RUnlock()
 
 	for lineName, l := range t.lines {
-		l.inflightLock.RLock()
+		// the journal is pruned by export, so it is done under the write
+		// lock of inflight like the other changes of journal
+		l.inflightLock.Lock()
 		l.headLock.RLock()
 		err := l.exportLine()
-		l.inflightLock.RUnlock()
 		l.headLock.RUnlock()
+		l.inflightLock.Unlock()
 		if err != nil {
 			log.Printf("topic[%s] line[%s] export error: %s", t.name, lineName, err)
 			continue
@@ -152,6 +420,7 @@ func (t *topic) loadLine(lineName string, ls UnitedLineStore) (*line, error) {
 	l := new(line)
 	l.name = lineName
 	l.recycleKey = t.name + "/" + lineName + keyLineRecycle
+	l.filterKey = t.name + "/" + lineName + keyLineFilter
 	lineRecycleData, err := t.q.getData(l.recycleKey)
 	if err != nil {
 		return nil, err
@@ -164,6 +433,9 @@ func (t *topic) loadLine(lineName string, ls UnitedLineStore) (*line, error) {
 		)
 	}
 	l.recycle = lineRecycle
+	l.attempts = ls.MaxAttempts
+	l.dead = ls.DeadTopic
+	l.skipped = ls.Skipped
 	l.head = ls.Head
 	l.ihead = ls.Ihead
 	imap := make(map[uint64]bool)
@@ -180,7 +452,20 @@ func (t *topic) loadLine(lineName string, ls UnitedLineStore) (*line, error) {
 	l.inflight = inflight
 	l.t = t
 
-	t.q.registerLine(t.name, l.name, l.recycle.String())
+	err = l.loadFilter()
+	if err != nil {
+		return nil, err
+	}
+	err = l.loadJournal()
+	if err != nil {
+		return nil, err
+	}
+	err = l.loadGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	t.q.registerLine(t.name, l.name, l.config())
 	return l, nil
 }
 
@@ -191,7 +476,7 @@ func (t *topic) getEnd() uint64 {
 	} else {
 		end = t.tail
 		for _, l := range t.lines {
-			if l.recycle > 0 {
+			if l.recycle > 0 || l.inflight.Len() > 0 {
 				if l.ihead < end {
 					end = l.ihead
 				}
@@ -211,8 +496,14 @@ func (t *topic) clean() (quit bool) {
 	t.headLock.Lock()
 	defer t.headLock.Unlock()
 
+	// the archives are counted under the read lock of head, so none can
+	// be started until the head lock is released
+	if atomic.LoadInt32(&t.archives) > 0 {
+		return
+	}
+
 	// starting := t.head
-	endTime := time.Now().Add(bgCleanTimeout)
+	endTime := time.Now().Add(GetTunables().CleanTimeout)
 	// log.Printf("topic[%s] begin to clean at %d", t.name, starting)
 
 	// defer func() {
@@ -221,7 +512,18 @@ func (t *topic) clean() (quit bool) {
 	// 	}
 	// }()
 
-	ending := t.getEnd()
+	ending := t.head
+	if !t.persist {
+		ending = t.getEnd()
+	}
+	if t.retained() {
+		end := t.retain(t.getTail(), endTime)
+		if end > ending {
+			t.skipLines(end)
+			ending = end
+		}
+	}
+
 	for t.head < ending {
 		select {
 		case <-t.quit:
@@ -237,14 +539,33 @@ func (t *topic) clean() (quit bool) {
 			return
 		}
 
-		key := utils.Acatui(t.name, ":", t.head)
-		err := t.q.delData(key)
+		end := t.head + cleanBatchSize
+		if end > ending {
+			end = ending
+		}
+		size := 0
+		if t.maxBytes > 0 {
+			ids := make([]uint64, 0, end-t.head)
+			for id := t.head; id < end; id++ {
+				ids = append(ids, id)
+			}
+			datas, err := t.getDatas(ids)
+			if err == nil {
+				for _, data := range datas {
+					size += len(data)
+				}
+			}
+		}
+		err := t.q.delRangeData(t.name+":", t.head, end)
 		if err != nil {
-			log.Printf("topic[%s] del %s error; %s", t.name, key, err)
+			log.Printf("topic[%s] del [%d - %d] error; %s", t.name, t.head, end-1, err)
 			return
 		}
+		if size > 0 {
+			t.subBytes(size)
+		}
 
-		t.head++
+		t.head = end
 		err = t.exportHead()
 		if err != nil {
 			log.Printf("topic[%s] export head error: %s", t.name, err)
@@ -255,13 +576,68 @@ func (t *topic) clean() (quit bool) {
 	return
 }
 
+// retain returns the first message id kept by the retention policy of
+// topic, messages before it are removed even if some lines have not
+// consumed them yet
+func (t *topic) retain(tail uint64, endTime time.Time) uint64 {
+	end := t.head
+	if t.maxCount > 0 && tail-end > t.maxCount {
+		end = tail - t.maxCount
+	}
+	if t.maxAge == 0 && t.maxBytes == 0 {
+		return end
+	}
+
+	deadline := time.Now().Add(-t.maxAge).UnixNano()
+	bytes := atomic.LoadUint64(&t.bytes)
+	for id := t.head; id < tail; id++ {
+		if time.Now().After(endTime) {
+			break
+		}
+		data, err := t.getData(id)
+		if err != nil {
+			break
+		}
+		if id >= end {
+			// messages pushed by old uq have no timestamp and are
+			// taken as expired
+			old := t.maxAge > 0 && decodeMessage(data).Timestamp < deadline
+			big := t.maxBytes > 0 && bytes > t.maxBytes
+			if !old && !big {
+				break
+			}
+			end = id + 1
+		}
+		if bytes > uint64(len(data)) {
+			bytes -= uint64(len(data))
+		} else {
+			bytes = 0
+		}
+	}
+	return end
+}
+
+// skipLines fast-forwards the lines which fall behind id
+func (t *topic) skipLines(id uint64) {
+	t.linesLock.RLock()
+	defer t.linesLock.RUnlock()
+	for _, l := range t.lines {
+		l.skipTo(id)
+	}
+}
+
 func (t *topic) backgroundClean() {
-	t.wg.Add(1)
 	defer t.wg.Done()
 
 	bgQuit := false
-	backupTick := time.NewTicker(bgBackupInterval)
-	cleanTick := time.NewTicker(bgCleanInterval)
+	backupInterval := GetTunables().BackupInterval
+	cleanInterval := GetTunables().CleanInterval
+	backupTick := time.NewTicker(backupInterval)
+	cleanTick := time.NewTicker(cleanInterval)
+	defer func() {
+		backupTick.Stop()
+		cleanTick.Stop()
+	}()
 	for !bgQuit {
 		select {
 		case <-backupTick.C:
@@ -269,9 +645,20 @@ func (t *topic) backgroundClean() {
 			if err != nil {
 				log.Printf("topic[%s] export lines error: %s", t.name, err)
 			}
+			if interval := GetTunables().BackupInterval; interval != backupInterval {
+				backupInterval = interval
+				backupTick.Stop()
+				backupTick = time.NewTicker(backupInterval)
+			}
 		case <-cleanTick.C:
-			if !t.persist {
-				log.Printf("cleaning... %v", t.persist)
+			if interval := GetTunables().CleanInterval; interval != cleanInterval {
+				cleanInterval = interval
+				cleanTick.Stop()
+				cleanTick = time.NewTicker(cleanInterval)
+			}
+			t.pruneDelays()
+			t.pruneDedups()
+			if !t.persist || t.retained() {
 				bgQuit := t.clean()
 				if bgQuit {
 					// log.Printf("topic[%s] t.clean return quit: %v", t.name, bgQuit)
@@ -289,27 +676,40 @@ func (t *topic) backgroundClean() {
 
 func (t *topic) start() {
 	// log.Printf("topic[%s] is starting...", t.name)
+	// the wait group is added before the goroutine runs, or close may
+	// return before the clean starts
+	t.wg.Add(1)
 	go t.backgroundClean()
 }
 
-func (t *topic) newLine(name string, recycle time.Duration) (*line, error) {
+func (t *topic) newLine(name string, lc *lineConfig) (*line, error) {
 	inflight := list.New()
 	imap := make(map[uint64]bool)
 	l := new(line)
 	l.name = name
-	if !t.persist {
-		l.head = t.head
-	} else {
-		l.head = 0
-	}
-	l.recycle = recycle
+	// t.head is the oldest message kept by topic
+	l.head = t.head
+	l.recycle = lc.recycle
+	l.attempts = lc.attempts
+	l.dead = lc.dead
+	l.filter = lc.filter
 	l.recycleKey = t.name + "/" + name + keyLineRecycle
+	l.filterKey = t.name + "/" + name + keyLineFilter
 	l.inflight = inflight
 	l.ihead = l.head
 	l.imap = imap
+	l.groups = make(map[string][]uint64)
+	l.grouped = make(map[uint64]string)
+	l.pruned = l.head
 	l.t = t
 
-	err := l.exportLine()
+	j := l.newJournal()
+	j.setHead(l.head)
+	err := j.commit()
+	if err != nil {
+		return nil, err
+	}
+	err = l.exportLine()
 	if err != nil {
 		return nil, err
 	}
@@ -317,11 +717,15 @@ func (t *topic) newLine(name string, recycle time.Duration) (*line, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = l.exportFilter()
+	if err != nil {
+		return nil, err
+	}
 
 	return l, nil
 }
 
-func (t *topic) createLine(name string, recycle time.Duration, fromEtcd bool) error {
+func (t *topic) createLine(name string, lc *lineConfig, fromEtcd bool) error {
 	t.linesLock.Lock()
 	defer t.linesLock.Unlock()
 	_, ok := t.lines[name]
@@ -332,7 +736,7 @@ func (t *topic) createLine(name string, recycle time.Duration, fromEtcd bool) er
 		)
 	}
 
-	l, err := t.newLine(name, recycle)
+	l, err := t.newLine(name, lc)
 	if err != nil {
 		return err
 	}
@@ -347,58 +751,128 @@ func (t *topic) createLine(name string, recycle time.Duration, fromEtcd bool) er
 		return err
 	}
 
+	for _, lt := range t.sublevels() {
+		err = lt.createLine(name, lc, true)
+		if err != nil {
+			return err
+		}
+	}
+
 	if !fromEtcd {
-		t.q.registerLine(t.name, l.name, l.recycle.String())
+		t.q.registerLine(t.name, l.name, l.config())
 	}
 
-	log.Printf("topic[%s] line[%s:%v] created.", t.name, name, recycle)
+	log.Printf("topic[%s] line[%s:%s] created.", t.name, name, l.config())
 	return nil
 }
 
-func (t *topic) push(data []byte) error {
+// push writes a message at the tail of topic and returns its id, if the
+// dedup key is seen in the window the id of the former message is returned
+// and nothing is written
+func (t *topic) push(data []byte, delay time.Duration, dedup string) (uint64, error) {
+	if delay > 0 {
+		err := t.markDelayed()
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	t.tailLock.Lock()
 	defer t.tailLock.Unlock()
 
-	key := utils.Acatui(t.name, ":", t.tail)
-	err := t.q.setData(key, data)
+	now := time.Now()
+	var entry *dedupEntry
+	if dedup != "" {
+		e, err := t.findDedup(dedup, now.UnixNano())
+		if err != nil {
+			return 0, err
+		}
+		if e != nil {
+			return e.id, nil
+		}
+		entry = &dedupEntry{
+			seq:    t.dedupTail(),
+			key:    dedup,
+			id:     t.tail,
+			expire: now.Add(t.dedup).UnixNano(),
+		}
+	}
+
+	// the message, its delay, its dedup key and the new tail are written
+	// at once
+	keys := []string{utils.Acatui(t.name, ":", t.tail)}
+	values := [][]byte{data}
+	var deliver int64
+	if delay > 0 {
+		deliver = now.Add(delay).UnixNano()
+		delayData := make([]byte, 8)
+		binary.LittleEndian.PutUint64(delayData, uint64(deliver))
+		keys = append(keys, t.delayKey(t.tail))
+		values = append(values, delayData)
+	}
+	if entry != nil {
+		keys = append(keys, t.dedupEntryKey(entry.seq), t.dedupKey)
+		values = append(values, entry.data(), dedupRangeData(t.dedupHead, entry.seq+1))
+	}
+	tailData := make([]byte, 8)
+	binary.LittleEndian.PutUint64(tailData, t.tail+1)
+	keys = append(keys, t.tailKey)
+	values = append(values, tailData)
+
+	err := t.q.multiSetData(keys, values)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if t.maxBytes > 0 {
+		t.addBytes(len(data))
 	}
 	// log.Printf("topic[%s] %s pushed.", t.name, string(data))
 
-	t.tail++
-	err = t.exportTail()
-	if err != nil {
-		t.tail--
-		return err
+	id := t.tail
+	if delay > 0 {
+		t.addDelay(id, deliver)
+	}
+	if entry != nil {
+		t.addDedup(entry)
 	}
+	t.tail++
 
-	return nil
+	t.notifyPush()
+	return id, nil
 }
 
-func (t *topic) mPush(datas [][]byte) error {
+// mPush writes the messages at the tail of topic and returns the id of
+// the first one, the others follow it
+func (t *topic) mPush(datas [][]byte) (uint64, error) {
 	t.tailLock.Lock()
 	defer t.tailLock.Unlock()
 
-	oldTail := t.tail
-	for _, data := range datas {
-		key := utils.Acatui(t.name, ":", t.tail)
-		err := t.q.setData(key, data)
-		if err != nil {
-			t.tail = oldTail
-			return err
-		}
-		// log.Printf("topic[%s] %s pushed.", t.name, string(data))
-		t.tail++
+	// the messages and the new tail are written at once
+	keys := make([]string, len(datas)+1)
+	values := make([][]byte, len(datas)+1)
+	size := 0
+	for i, data := range datas {
+		keys[i] = utils.Acatui(t.name, ":", t.tail+uint64(i))
+		values[i] = data
+		size += len(data)
 	}
+	tailData := make([]byte, 8)
+	binary.LittleEndian.PutUint64(tailData, t.tail+uint64(len(datas)))
+	keys[len(datas)] = t.tailKey
+	values[len(datas)] = tailData
 
-	err := t.exportTail()
+	err := t.q.multiSetData(keys, values)
 	if err != nil {
-		t.tail = oldTail
-		return err
+		return 0, err
 	}
+	if t.maxBytes > 0 {
+		t.addBytes(size)
+	}
+	first := t.tail
+	t.tail += uint64(len(datas))
 
-	return nil
+	t.notifyPush()
+	return first, nil
 }
 
 func (t *topic) pop(name string) (uint64, []byte, error) {
@@ -416,6 +890,21 @@ func (t *topic) pop(name string) (uint64, []byte, error) {
 	return l.pop()
 }
 
+func (t *topic) popWait(name string, timeout time.Duration) (uint64, []byte, error) {
+	t.linesLock.RLock()
+	l, ok := t.lines[name]
+	t.linesLock.RUnlock()
+	if !ok {
+		// log.Printf("topic[%s] line[%s] not existed.", t.name, name)
+		return 0, nil, utils.NewError(
+			utils.ErrLineNotExisted,
+			`topic popWait`,
+		)
+	}
+
+	return l.popWait(timeout)
+}
+
 func (t *topic) mPop(name string, n int) ([]uint64, [][]byte, error) {
 	t.linesLock.RLock()
 	l, ok := t.lines[name]
@@ -431,6 +920,47 @@ func (t *topic) mPop(name string, n int) ([]uint64, [][]byte, error) {
 	return l.mPop(n)
 }
 
+// peek returns at most n messages from the offset after the head of line,
+// or the head of topic if name is empty, without consuming them
+func (t *topic) peek(name string, offset uint64, n int) ([]uint64, [][]byte, error) {
+	t.headLock.RLock()
+	defer t.headLock.RUnlock()
+
+	start := t.head
+	if name != "" {
+		t.linesLock.RLock()
+		l, ok := t.lines[name]
+		t.linesLock.RUnlock()
+		if !ok {
+			return nil, nil, utils.NewError(
+				utils.ErrLineNotExisted,
+				`topic peek`,
+			)
+		}
+		start = l.getHead()
+	}
+
+	var ids []uint64
+	var datas [][]byte
+	tail := t.getTail()
+	for id := start + offset; id < tail && len(ids) < n; id++ {
+		data, err := t.getData(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		datas = append(datas, data)
+	}
+
+	if len(ids) == 0 {
+		return nil, nil, utils.NewError(
+			utils.ErrNone,
+			`topic peek`,
+		)
+	}
+	return ids, datas, nil
+}
+
 func (t *topic) confirm(name string, id uint64) error {
 	t.linesLock.RLock()
 	l, ok := t.lines[name]
@@ -446,6 +976,39 @@ func (t *topic) confirm(name string, id uint64) error {
 	return l.confirm(id)
 }
 
+func (t *topic) nack(name string, id uint64) error {
+	t.linesLock.RLock()
+	l, ok := t.lines[name]
+	t.linesLock.RUnlock()
+	if !ok {
+		return utils.NewError(
+			utils.ErrLineNotExisted,
+			`topic nack`,
+		)
+	}
+
+	err := l.nack(id)
+	if err != nil {
+		return err
+	}
+	t.notifyPush()
+	return nil
+}
+
+func (t *topic) touch(name string, id uint64, timeout time.Duration) error {
+	t.linesLock.RLock()
+	l, ok := t.lines[name]
+	t.linesLock.RUnlock()
+	if !ok {
+		return utils.NewError(
+			utils.ErrLineNotExisted,
+			`topic touch`,
+		)
+	}
+
+	return l.touch(id, timeout)
+}
+
 func (t *topic) statLine(name string) (*Stat, error) {
 	t.linesLock.RLock()
 	l, ok := t.lines[name]
@@ -459,6 +1022,13 @@ func (t *topic) statLine(name string) (*Stat, error) {
 	}
 
 	qs := l.stat()
+	for _, lt := range t.sublevels() {
+		ls, err := lt.statLine(name)
+		if err != nil {
+			return nil, err
+		}
+		qs.Priorities = append(qs.Priorities, ls)
+	}
 	return qs, nil
 }
 
@@ -474,14 +1044,28 @@ func (t *topic) stat() *Stat {
 	qs.Tail = t.tail
 	t.tailLock.RUnlock()
 	qs.Count = qs.Tail - qs.Head
+	qs.Retention = t.retention()
+	if t.dedup > 0 {
+		qs.Dedup = t.dedup.String()
+	}
 
 	t.linesLock.RLock()
 	defer t.linesLock.RUnlock()
+	names := make([]string, 0, len(t.lines))
+	for name := range t.lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 	qs.Lines = make([]*Stat, 0)
-	for _, l := range t.lines {
-		ls := l.stat()
+	for _, name := range names {
+		ls := t.lines[name].stat()
 		qs.Lines = append(qs.Lines, ls)
 	}
+	for _, lt := range t.sublevels() {
+		ps := lt.stat()
+		ps.Lines = nil
+		qs.Priorities = append(qs.Priorities, ps)
+	}
 
 	return qs
 }
@@ -498,10 +1082,96 @@ func (t *topic) emptyLine(name string) error {
 		)
 	}
 
+	for _, lt := range t.sublevels() {
+		err := lt.emptyLine(name)
+		if err != nil {
+			return err
+		}
+	}
+
 	return l.empty()
 }
 
+// seek moves a line to id, the ids of priority levels are not comparable so
+// a topic with priorities can only seek by time
+func (t *topic) seek(name string, id uint64) error {
+	if len(t.levels) > 0 {
+		return utils.NewError(
+			utils.ErrBadRequest,
+			`topic with priorities can not seek by id`,
+		)
+	}
+	return t.seekTo(name, id)
+}
+
+func (t *topic) seekTo(name string, id uint64) error {
+	t.linesLock.RLock()
+	l, ok := t.lines[name]
+	t.linesLock.RUnlock()
+	if !ok {
+		return utils.NewError(
+			utils.ErrLineNotExisted,
+			`topic seek`,
+		)
+	}
+
+	// hold the head so that messages are not cleaned while seeking
+	t.headLock.RLock()
+	defer t.headLock.RUnlock()
+	if id < t.head || id > t.getTail() {
+		return utils.NewError(
+			utils.ErrBadRequest,
+			`topic seek offset out of range`,
+		)
+	}
+
+	return l.seek(id)
+}
+
+func (t *topic) seekTime(name string, ts time.Time) error {
+	for _, lt := range t.sublevels() {
+		err := lt.seekTime(name, ts)
+		if err != nil {
+			return err
+		}
+	}
+
+	id, err := t.searchTime(ts.UnixNano())
+	if err != nil {
+		return err
+	}
+	return t.seekTo(name, id)
+}
+
+// searchTime returns the id of first message pushed not before ts
+func (t *topic) searchTime(ts int64) (uint64, error) {
+	t.headLock.RLock()
+	defer t.headLock.RUnlock()
+
+	low, high := t.head, t.getTail()
+	for low < high {
+		mid := low + (high-low)/2
+		data, err := t.getData(mid)
+		if err != nil {
+			return 0, err
+		}
+		if decodeMessage(data).Timestamp < ts {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
+
 func (t *topic) empty() error {
+	for _, lt := range t.sublevels() {
+		err := lt.empty()
+		if err != nil {
+			return err
+		}
+	}
+
 	t.linesLock.RLock()
 	defer t.linesLock.RUnlock()
 
@@ -523,6 +1193,10 @@ func (t *topic) empty() error {
 		return err
 	}
 
+	t.removeDelays(func(id uint64, deliver int64) bool {
+		return true
+	})
+
 	log.Printf("topic[%s] empty succ", t.name)
 	return nil
 }
@@ -557,6 +1231,13 @@ func (t *topic) removeLine(name string, fromEtcd bool) error {
 		t.q.unRegisterLine(t.name, name)
 	}
 
+	for _, lt := range t.sublevels() {
+		err = lt.removeLine(name, true)
+		if err != nil {
+			log.Printf("topic[%s] line[%s] remove error: %s", lt.name, name, err)
+		}
+	}
+
 	return l.remove()
 }
 
@@ -575,14 +1256,14 @@ func (t *topic) removeLines() error {
 }
 
 func (t *topic) removeMsgData() error {
-	for i := t.head; i < t.tail; i++ {
-		key := utils.Acatui(t.name, ":", i)
-		err := t.q.delData(key)
-		if err != nil {
-			log.Printf("topic[%s] del data[%s] error; %s", t.name, key, err)
-			continue
-		}
+	err := t.q.delRangeData(t.name+":", t.head, t.tail)
+	if err != nil {
+		log.Printf("topic[%s] del data[%d - %d] error; %s", t.name, t.head, t.tail, err)
 	}
+
+	t.removeDelays(func(id uint64, deliver int64) bool {
+		return true
+	})
 	return nil
 }
 
@@ -621,6 +1302,18 @@ func (t *topic) remove() error {
 		log.Printf("topic[%s] removeMsgData error: %s", t.name, err)
 	}
 
+	err = t.removeDedupData()
+	if err != nil {
+		log.Printf("topic[%s] removeDedupData error: %s", t.name, err)
+	}
+
+	for _, lt := range t.sublevels() {
+		err = lt.remove()
+		if err != nil {
+			log.Printf("topic[%s] remove error: %s", lt.name, err)
+		}
+	}
+
 	log.Printf("topic[%s] remove succ", t.name)
 	return nil
 }
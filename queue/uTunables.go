@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/buaazp/uq/utils"
+)
+
+// Tunables are the settings of the background jobs of queue, they can be
+// changed at runtime by SetTunables
+type Tunables struct {
+	BackupInterval time.Duration
+	CleanInterval  time.Duration
+	CleanTimeout   time.Duration
+	EtcdTTL        time.Duration
+}
+
+var (
+	tunables = Tunables{
+		BackupInterval: 10 * time.Second,
+		CleanInterval:  20 * time.Second,
+		CleanTimeout:   5 * time.Second,
+		EtcdTTL:        60 * time.Second,
+	}
+	defaultTunables = tunables
+	tunablesLock    sync.RWMutex
+)
+
+// DefaultTunables returns the default tunables of queue
+func DefaultTunables() Tunables {
+	return defaultTunables
+}
+
+// GetTunables returns the tunables in use
+func GetTunables() Tunables {
+	tunablesLock.RLock()
+	defer tunablesLock.RUnlock()
+	return tunables
+}
+
+// SetTunables changes the tunables, the running topics and etcd register
+// pick them up at their next tick
+func SetTunables(t Tunables) error {
+	if t.BackupInterval <= 0 || t.CleanInterval <= 0 || t.CleanTimeout <= 0 {
+		return utils.NewError(
+			utils.ErrBadRequest,
+			`intervals and timeout should be positive`,
+		)
+	}
+	if t.EtcdTTL < time.Second {
+		return utils.NewError(
+			utils.ErrBadRequest,
+			`etcd ttl should be at least 1s`,
+		)
+	}
+
+	tunablesLock.Lock()
+	defer tunablesLock.Unlock()
+	tunables = t
+	return nil
+}
@@ -13,6 +13,8 @@
 		UnitedTopicStore
 		InflightMessage
 		UnitedLineStore
+		MessageHeader
+		Message
 */
 package queue
 
@@ -41,6 +43,12 @@ func (*UnitedQueueStore) ProtoMessage()    {}
 type UnitedTopicStore struct {
 	Lines            []string `protobuf:"bytes,1,rep" json:"Lines,omitempty"`
 	Persist          bool     `protobuf:"varint,2,req" json:"Persist"`
+	Delayed          bool     `protobuf:"varint,3,opt" json:"Delayed"`
+	MaxAge           int64    `protobuf:"varint,4,opt" json:"MaxAge"`
+	MaxCount         uint64   `protobuf:"varint,5,opt" json:"MaxCount"`
+	MaxBytes         uint64   `protobuf:"varint,6,opt" json:"MaxBytes"`
+	Dedup            int64    `protobuf:"varint,7,opt" json:"Dedup"`
+	Priorities       uint64   `protobuf:"varint,8,opt" json:"Priorities"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -51,6 +59,7 @@ func (*UnitedTopicStore) ProtoMessage()    {}
 type InflightMessage struct {
 	Tid              uint64 `protobuf:"varint,1,req" json:"Tid"`
 	Exptime          int64  `protobuf:"varint,2,req" json:"Exptime"`
+	Attempts         uint64 `protobuf:"varint,3,opt" json:"Attempts"`
 	XXX_unrecognized []byte `json:"-"`
 }
 
@@ -62,6 +71,9 @@ type UnitedLineStore struct {
 	Head             uint64             `protobuf:"varint,1,req" json:"Head"`
 	Ihead            uint64             `protobuf:"varint,2,req" json:"Ihead"`
 	Inflights        []*InflightMessage `protobuf:"bytes,3,rep" json:"Inflights,omitempty"`
+	MaxAttempts      uint64             `protobuf:"varint,4,opt" json:"MaxAttempts"`
+	DeadTopic        string             `protobuf:"bytes,5,opt" json:"DeadTopic"`
+	Skipped          uint64             `protobuf:"varint,6,opt" json:"Skipped"`
 	XXX_unrecognized []byte             `json:"-"`
 }
 
@@ -69,6 +81,29 @@ func (m *UnitedLineStore) Reset()         { *m = UnitedLineStore{} }
 func (m *UnitedLineStore) String() string { return proto.CompactTextString(m) }
 func (*UnitedLineStore) ProtoMessage()    {}
 
+type MessageHeader struct {
+	Key              string `protobuf:"bytes,1,req" json:"Key"`
+	Value            string `protobuf:"bytes,2,req" json:"Value"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *MessageHeader) Reset()         { *m = MessageHeader{} }
+func (m *MessageHeader) String() string { return proto.CompactTextString(m) }
+func (*MessageHeader) ProtoMessage()    {}
+
+type Message struct {
+	Timestamp        int64            `protobuf:"varint,1,req" json:"Timestamp"`
+	ContentType      string           `protobuf:"bytes,2,opt" json:"ContentType"`
+	Headers          []*MessageHeader `protobuf:"bytes,3,rep" json:"Headers,omitempty"`
+	Body             []byte           `protobuf:"bytes,4,opt" json:"Body,omitempty"`
+	Group            string           `protobuf:"bytes,5,opt" json:"Group"`
+	XXX_unrecognized []byte           `json:"-"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
 func (m *UnitedQueueStore) Marshal() (data []byte, err error) {
 	size := m.Size()
 	data = make([]byte, size)
@@ -143,6 +178,29 @@ func (m *UnitedTopicStore) MarshalTo(data []byte) (int, error) {
 		data[i] = 0
 	}
 	i++
+	data[i] = 0x18
+	i++
+	if m.Delayed {
+		data[i] = 1
+	} else {
+		data[i] = 0
+	}
+	i++
+	data[i] = 0x20
+	i++
+	i = encodeVarintUq(data, i, uint64(m.MaxAge))
+	data[i] = 0x28
+	i++
+	i = encodeVarintUq(data, i, uint64(m.MaxCount))
+	data[i] = 0x30
+	i++
+	i = encodeVarintUq(data, i, uint64(m.MaxBytes))
+	data[i] = 0x38
+	i++
+	i = encodeVarintUq(data, i, uint64(m.Dedup))
+	data[i] = 0x40
+	i++
+	i = encodeVarintUq(data, i, uint64(m.Priorities))
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -170,6 +228,9 @@ func (m *InflightMessage) MarshalTo(data []byte) (int, error) {
 	data[i] = 0x10
 	i++
 	i = encodeVarintUq(data, i, uint64(m.Exptime))
+	data[i] = 0x18
+	i++
+	i = encodeVarintUq(data, i, uint64(m.Attempts))
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -209,6 +270,95 @@ func (m *UnitedLineStore) MarshalTo(data []byte) (int, error) {
 			i += n
 		}
 	}
+	data[i] = 0x20
+	i++
+	i = encodeVarintUq(data, i, uint64(m.MaxAttempts))
+	data[i] = 0x2a
+	i++
+	i = encodeVarintUq(data, i, uint64(len(m.DeadTopic)))
+	i += copy(data[i:], m.DeadTopic)
+	data[i] = 0x30
+	i++
+	i = encodeVarintUq(data, i, uint64(m.Skipped))
+	if m.XXX_unrecognized != nil {
+		i += copy(data[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *MessageHeader) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *MessageHeader) MarshalTo(data []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	data[i] = 0xa
+	i++
+	i = encodeVarintUq(data, i, uint64(len(m.Key)))
+	i += copy(data[i:], m.Key)
+	data[i] = 0x12
+	i++
+	i = encodeVarintUq(data, i, uint64(len(m.Value)))
+	i += copy(data[i:], m.Value)
+	if m.XXX_unrecognized != nil {
+		i += copy(data[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *Message) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *Message) MarshalTo(data []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	data[i] = 0x8
+	i++
+	i = encodeVarintUq(data, i, uint64(m.Timestamp))
+	data[i] = 0x12
+	i++
+	i = encodeVarintUq(data, i, uint64(len(m.ContentType)))
+	i += copy(data[i:], m.ContentType)
+	if len(m.Headers) > 0 {
+		for _, msg := range m.Headers {
+			data[i] = 0x1a
+			i++
+			i = encodeVarintUq(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.Body != nil {
+		data[i] = 0x22
+		i++
+		i = encodeVarintUq(data, i, uint64(len(m.Body)))
+		i += copy(data[i:], m.Body)
+	}
+	data[i] = 0x2a
+	i++
+	i = encodeVarintUq(data, i, uint64(len(m.Group)))
+	i += copy(data[i:], m.Group)
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -267,6 +417,12 @@ func (m *UnitedTopicStore) Size() (n int) {
 		}
 	}
 	n += 2
+	n += 2
+	n += 1 + sovUq(uint64(m.MaxAge))
+	n += 1 + sovUq(uint64(m.MaxCount))
+	n += 1 + sovUq(uint64(m.MaxBytes))
+	n += 1 + sovUq(uint64(m.Dedup))
+	n += 1 + sovUq(uint64(m.Priorities))
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -278,6 +434,7 @@ func (m *InflightMessage) Size() (n int) {
 	_ = l
 	n += 1 + sovUq(uint64(m.Tid))
 	n += 1 + sovUq(uint64(m.Exptime))
+	n += 1 + sovUq(uint64(m.Attempts))
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -295,6 +452,47 @@ func (m *UnitedLineStore) Size() (n int) {
 			n += 1 + l + sovUq(uint64(l))
 		}
 	}
+	n += 1 + sovUq(uint64(m.MaxAttempts))
+	l = len(m.DeadTopic)
+	n += 1 + l + sovUq(uint64(l))
+	n += 1 + sovUq(uint64(m.Skipped))
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *MessageHeader) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Key)
+	n += 1 + l + sovUq(uint64(l))
+	l = len(m.Value)
+	n += 1 + l + sovUq(uint64(l))
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *Message) Size() (n int) {
+	var l int
+	_ = l
+	n += 1 + sovUq(uint64(m.Timestamp))
+	l = len(m.ContentType)
+	n += 1 + l + sovUq(uint64(l))
+	if len(m.Headers) > 0 {
+		for _, e := range m.Headers {
+			l = e.Size()
+			n += 1 + l + sovUq(uint64(l))
+		}
+	}
+	if m.Body != nil {
+		l = len(m.Body)
+		n += 1 + l + sovUq(uint64(l))
+	}
+	l = len(m.Group)
+	n += 1 + l + sovUq(uint64(l))
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -448,6 +646,103 @@ func (m *UnitedTopicStore) Unmarshal(data []byte) error {
 			}
 			m.Persist = bool(v != 0)
 			hasFields[0] |= uint64(0x00000001)
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Delayed", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Delayed = bool(v != 0)
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxAge", wireType)
+			}
+			m.MaxAge = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.MaxAge |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxCount", wireType)
+			}
+			m.MaxCount = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.MaxCount |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxBytes", wireType)
+			}
+			m.MaxBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.MaxBytes |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Dedup", wireType)
+			}
+			m.Dedup = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.Dedup |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Priorities", wireType)
+			}
+			m.Priorities = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.Priorities |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			var sizeOfWire int
 			for {
@@ -532,6 +827,22 @@ func (m *InflightMessage) Unmarshal(data []byte) error {
 				}
 			}
 			hasFields[0] |= uint64(0x00000002)
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Attempts", wireType)
+			}
+			m.Attempts = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.Attempts |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			var sizeOfWire int
 			for {
@@ -647,6 +958,63 @@ func (m *UnitedLineStore) Unmarshal(data []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxAttempts", wireType)
+			}
+			m.MaxAttempts = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.MaxAttempts |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DeadTopic", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := iNdEx + int(stringLen)
+			if stringLen < 0 {
+				return ErrInvalidLengthUq
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.DeadTopic = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Skipped", wireType)
+			}
+			m.Skipped = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.Skipped |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			var sizeOfWire int
 			for {
@@ -680,6 +1048,281 @@ func (m *UnitedLineStore) Unmarshal(data []byte) error {
 
 	return nil
 }
+func (m *MessageHeader) Unmarshal(data []byte) error {
+	var hasFields [1]uint64
+	l := len(data)
+	iNdEx := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := iNdEx + int(stringLen)
+			if stringLen < 0 {
+				return ErrInvalidLengthUq
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Key = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000001)
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := iNdEx + int(stringLen)
+			if stringLen < 0 {
+				return ErrInvalidLengthUq
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Value = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+			hasFields[0] |= uint64(0x00000002)
+		default:
+			var sizeOfWire int
+			for {
+				sizeOfWire++
+				wire >>= 7
+				if wire == 0 {
+					break
+				}
+			}
+			iNdEx -= sizeOfWire
+			skippy, err := skipUq(data[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthUq
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, data[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+	if hasFields[0]&uint64(0x00000001) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("Key")
+	}
+	if hasFields[0]&uint64(0x00000002) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("Value")
+	}
+
+	return nil
+}
+func (m *Message) Unmarshal(data []byte) error {
+	var hasFields [1]uint64
+	l := len(data)
+	iNdEx := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
+			}
+			m.Timestamp = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.Timestamp |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			hasFields[0] |= uint64(0x00000001)
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ContentType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := iNdEx + int(stringLen)
+			if stringLen < 0 {
+				return ErrInvalidLengthUq
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ContentType = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Headers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := iNdEx + msglen
+			if msglen < 0 {
+				return ErrInvalidLengthUq
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Headers = append(m.Headers, &MessageHeader{})
+			if err := m.Headers[len(m.Headers)-1].Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Body", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := iNdEx + byteLen
+			if byteLen < 0 {
+				return ErrInvalidLengthUq
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Body = append([]byte{}, data[iNdEx:postIndex]...)
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Group", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := iNdEx + int(stringLen)
+			if stringLen < 0 {
+				return ErrInvalidLengthUq
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Group = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			var sizeOfWire int
+			for {
+				sizeOfWire++
+				wire >>= 7
+				if wire == 0 {
+					break
+				}
+			}
+			iNdEx -= sizeOfWire
+			skippy, err := skipUq(data[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthUq
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, data[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+	if hasFields[0]&uint64(0x00000001) == 0 {
+		return github_com_gogo_protobuf_proto.NewRequiredNotSetError("Timestamp")
+	}
+
+	return nil
+}
 func skipUq(data []byte) (n int, err error) {
 	l := len(data)
 	iNdEx := 0
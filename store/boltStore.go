@@ -0,0 +1,155 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket which keeps all the data of uq
+var boltBucket = []byte("uq")
+
+// BoltStore is the bolt storage. All the data is in a single file, and
+// every write is a transaction which is flushed to disk when it commits.
+type BoltStore struct {
+	path   string
+	db     *bolt.DB
+	closed bool
+}
+
+// NewBoltStore returns a new BoltStore
+func NewBoltStore(dbpath string) (*BoltStore, error) {
+	if dbpath == "" {
+		return nil, errors.New("bolt store path is empty")
+	}
+	err := os.MkdirAll(path.Dir(dbpath), 0755)
+	if err != nil {
+		return nil, err
+	}
+	// do not wait forever if the file is locked by another uq
+	db, err := bolt.Open(dbpath, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	bs := new(BoltStore)
+	bs.path = dbpath
+	bs.db = db
+
+	return bs, nil
+}
+
+// Set implements the Set interface
+func (b *BoltStore) Set(key string, data []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), data)
+	})
+}
+
+// Get implements the Get interface
+func (b *BoltStore) Get(key string) ([]byte, error) {
+	var data []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBucket).Get([]byte(key))
+		if value == nil {
+			return errors.New(errNotExisted)
+		}
+		// the value is only valid in the transaction
+		data = make([]byte, len(value))
+		copy(data, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Del implements the Del interface
+func (b *BoltStore) Del(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+// MultiSet implements the MultiSet interface
+func (b *BoltStore) MultiSet(keys []string, datas [][]byte) error {
+	if len(keys) != len(datas) {
+		return errors.New(errModeNotMatched)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for i, key := range keys {
+			err := bucket.Put([]byte(key), datas[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MultiGet implements the MultiGet interface
+func (b *BoltStore) MultiGet(keys []string) ([][]byte, error) {
+	datas := make([][]byte, len(keys))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for i, key := range keys {
+			value := bucket.Get([]byte(key))
+			if value == nil {
+				continue
+			}
+			datas[i] = make([]byte, len(value))
+			copy(datas[i], value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return datas, nil
+}
+
+// DelRange implements the DelRange interface
+func (b *BoltStore) DelRange(prefix string, start, end uint64) error {
+	return delRangeChunks(start, end, func(start, end uint64) error {
+		return b.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(boltBucket)
+			for id := start; id < end; id++ {
+				err := bucket.Delete([]byte(prefix + strconv.FormatUint(id, 10)))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Close implements the Close interface
+func (b *BoltStore) Close() error {
+	if b.closed {
+		return errors.New(errClosed)
+	}
+	err := b.db.Close()
+	if err != nil {
+		log.Printf("bolt close error: %s", err)
+		return err
+	}
+	b.closed = true
+	return nil
+}
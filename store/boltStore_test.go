@@ -0,0 +1,91 @@
+package store
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	bdb      Storage
+	boltPath string
+)
+
+func init() {
+	boltPath = os.TempDir() + "/uq.store.test.bolt"
+}
+
+func TestNewBoltStore(t *testing.T) {
+	Convey("Test New Bolt Store", t, func() {
+		os.RemoveAll(boltPath)
+		bdb, err = NewBoltStore(boltPath)
+		So(err, ShouldBeNil)
+		So(bdb, ShouldNotBeNil)
+
+		bdb2, err2 := NewBoltStore("")
+		So(err2, ShouldNotBeNil)
+		So(bdb2, ShouldBeNil)
+	})
+}
+
+func TestSetBolt(t *testing.T) {
+	Convey("Test Bolt Store Set", t, func() {
+		err = bdb.Set("foo", []byte("bar"))
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestGetBolt(t *testing.T) {
+	Convey("Test Bolt Store Get", t, func() {
+		data, err := bdb.Get("foo")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "bar")
+
+		_, err = bdb.Get("bar")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDelBolt(t *testing.T) {
+	Convey("Test Bolt Store Del", t, func() {
+		err = bdb.Del("foo")
+		So(err, ShouldBeNil)
+		_, err = bdb.Get("foo")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestBatchBolt(t *testing.T) {
+	Convey("Test Bolt Store Batch", t, func() {
+		checkBatch(bdb)
+	})
+}
+
+func TestReopenBolt(t *testing.T) {
+	Convey("Test Bolt Store Reopen", t, func() {
+		err = bdb.Set("foo", []byte("bar again"))
+		So(err, ShouldBeNil)
+		err = bdb.Close()
+		So(err, ShouldBeNil)
+
+		bdb, err = NewBoltStore(boltPath)
+		So(err, ShouldBeNil)
+		data, err := bdb.Get("foo")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "bar again")
+	})
+}
+
+func TestCloseBolt(t *testing.T) {
+	Convey("Test Bolt Store Close", t, func() {
+		err = bdb.Close()
+		So(err, ShouldBeNil)
+
+		err = bdb.Close()
+		So(err, ShouldNotBeNil)
+
+		err = os.RemoveAll(boltPath)
+		So(err, ShouldBeNil)
+	})
+}
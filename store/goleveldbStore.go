@@ -1,16 +1,28 @@
 package store
 
 import (
+	"errors"
 	"log"
+	"strconv"
+	"sync"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
+// syncMarkerKey is deleted with sync to flush the writes of goleveldb, it
+// is never set so it does not show in the storage
+const syncMarkerKey = "UnitedSyncMarker"
+
 // LevelStore is the goleveldb storage
 type LevelStore struct {
 	path string
 	db   *leveldb.DB
+
+	syncLock sync.Mutex
+	policy   SyncPolicy
+	ticker   *syncTicker
+	dirty    bool
 }
 
 // NewLevelStore returns a new LevelStore
@@ -29,7 +41,7 @@ func NewLevelStore(path string) (*LevelStore, error) {
 
 // Set implements the Set interface
 func (l *LevelStore) Set(key string, data []byte) error {
-	return l.db.Put([]byte(key), data, nil)
+	return l.db.Put([]byte(key), data, l.writeOptions())
 
 	// err := l.db.Put(keyByte, data, nil)
 	// if err != nil {
@@ -53,7 +65,7 @@ func (l *LevelStore) Get(key string) ([]byte, error) {
 
 // Del implements the Del interface
 func (l *LevelStore) Del(key string) error {
-	return l.db.Delete([]byte(key), nil)
+	return l.db.Delete([]byte(key), l.writeOptions())
 
 	// err := l.db.Delete(keyByte, nil)
 	// if err != nil {
@@ -62,8 +74,105 @@ func (l *LevelStore) Del(key string) error {
 	// return nil
 }
 
+// MultiSet implements the MultiSet interface
+func (l *LevelStore) MultiSet(keys []string, datas [][]byte) error {
+	if len(keys) != len(datas) {
+		return errors.New(errModeNotMatched)
+	}
+
+	batch := new(leveldb.Batch)
+	for i, key := range keys {
+		batch.Put([]byte(key), datas[i])
+	}
+	return l.db.Write(batch, l.writeOptions())
+}
+
+// MultiGet implements the MultiGet interface
+func (l *LevelStore) MultiGet(keys []string) ([][]byte, error) {
+	snapshot, err := l.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
+	datas := make([][]byte, len(keys))
+	for i, key := range keys {
+		data, err := snapshot.Get([]byte(key), nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		datas[i] = data
+	}
+	return datas, nil
+}
+
+// DelRange implements the DelRange interface
+func (l *LevelStore) DelRange(prefix string, start, end uint64) error {
+	return delRangeChunks(start, end, func(start, end uint64) error {
+		batch := new(leveldb.Batch)
+		for id := start; id < end; id++ {
+			batch.Delete([]byte(prefix + strconv.FormatUint(id, 10)))
+		}
+		return l.db.Write(batch, l.writeOptions())
+	})
+}
+
+// SetSyncPolicy implements the Syncer interface
+func (l *LevelStore) SetSyncPolicy(p SyncPolicy) error {
+	l.syncLock.Lock()
+	ticker := l.ticker
+	l.policy = p
+	l.ticker = nil
+	l.syncLock.Unlock()
+
+	ticker.stop()
+	l.flush()
+
+	l.syncLock.Lock()
+	defer l.syncLock.Unlock()
+	l.ticker = startSyncTicker(p, l.flush)
+	return nil
+}
+
+// writeOptions returns the options of a write, it syncs if the policy is
+// always and marks the writes to be flushed otherwise
+func (l *LevelStore) writeOptions() *opt.WriteOptions {
+	l.syncLock.Lock()
+	defer l.syncLock.Unlock()
+	if l.policy == SyncAlways {
+		return &opt.WriteOptions{Sync: true}
+	}
+	l.dirty = l.policy != SyncNever
+	return nil
+}
+
+// flush flushes the writes of goleveldb by a synced write
+func (l *LevelStore) flush() {
+	l.syncLock.Lock()
+	dirty := l.dirty
+	l.dirty = false
+	l.syncLock.Unlock()
+	if !dirty {
+		return
+	}
+
+	err := l.db.Delete([]byte(syncMarkerKey), &opt.WriteOptions{Sync: true})
+	if err != nil {
+		log.Printf("leveldb sync error: %s", err)
+	}
+}
+
 // Close implements the Close interface
 func (l *LevelStore) Close() error {
+	l.syncLock.Lock()
+	ticker := l.ticker
+	l.ticker = nil
+	l.syncLock.Unlock()
+	ticker.stop()
+	l.flush()
+
 	err := l.db.Close()
 	if err != nil {
 		log.Printf("leveldb close error: %s", err)
@@ -50,6 +50,12 @@ func TestDelLevel(t *testing.T) {
 	})
 }
 
+func TestBatchLevel(t *testing.T) {
+	Convey("Test Level Store Batch", t, func() {
+		checkBatch(ldb)
+	})
+}
+
 func TestCloseLevel(t *testing.T) {
 	Convey("Test Level Store Close", t, func() {
 		err = ldb.Close()
@@ -0,0 +1,959 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	errClosed string = "Storage Closed"
+
+	// defaultSegmentSize is the size at which a segment is sealed and a new
+	// one is started
+	defaultSegmentSize int64 = 64 * 1024 * 1024
+	// metaCompactSize is the least size of meta log to be compacted
+	metaCompactSize int64 = 16 * 1024 * 1024
+
+	metaFileName       = "meta.log"
+	topicsDirName      = "topics"
+	segmentExt         = ".log"
+	indexExt           = ".idx"
+	recordSet     byte = 1
+	recordDel     byte = 2
+
+	// crc(4) flag(1) id(8) size(4)
+	segmentHeaderSize = 17
+	// flag(1) id(8) offset(8) size(4)
+	indexEntrySize = 21
+	// crc(4) flag(1) keySize(4) valueSize(4)
+	metaHeaderSize = 13
+)
+
+// LogStore is the storage made of append-only log files. The messages of a
+// topic, which are keyed like "foo:1", are appended to the segments of the
+// topic. Deleting a message only appends a tombstone, and the oldest
+// segments are dropped as a whole once all their messages are deleted. The
+// other keys are kept in memory and in a meta log, which is compacted when
+// it grows too big.
+type LogStore struct {
+	mu          sync.RWMutex
+	path        string
+	segmentSize int64
+	closed      bool
+	policy      SyncPolicy
+	ticker      *syncTicker
+
+	meta      map[string][]byte
+	metaFile  *os.File
+	metaSize  int64
+	metaLive  int64
+	metaDirty bool
+
+	topics map[string]*topicLog
+}
+
+// topicLog is the segments of a topic
+type topicLog struct {
+	mu       sync.RWMutex
+	dir      string
+	segments []*segment
+	index    map[uint64]location
+	// dirty is whether the active segment has writes to be flushed
+	dirty bool
+}
+
+type segment struct {
+	seq  uint64
+	file *os.File
+	size int64
+	live int
+	// entries are the records of the active segment, which are written to
+	// the index file when the segment is sealed
+	entries []indexEntry
+}
+
+type location struct {
+	seg    *segment
+	offset int64
+	size   uint32
+}
+
+type indexEntry struct {
+	flag   byte
+	id     uint64
+	offset int64
+	size   uint32
+}
+
+// NewLogStore returns a new LogStore
+func NewLogStore(path string) (*LogStore, error) {
+	return newLogStore(path, defaultSegmentSize)
+}
+
+func newLogStore(dbpath string, segmentSize int64) (*LogStore, error) {
+	if dbpath == "" {
+		return nil, errors.New("log store path is empty")
+	}
+	err := os.MkdirAll(path.Join(dbpath, topicsDirName), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(LogStore)
+	s.path = dbpath
+	s.segmentSize = segmentSize
+	s.meta = make(map[string][]byte)
+	s.topics = make(map[string]*topicLog)
+
+	err = s.loadMeta()
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadTopics()
+	if err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// splitMessageKey splits a message key like "foo:1" into its topic and id.
+// Only the keys of topic messages are split, the other keys ending with a
+// number like "foo/x:inflight:1" or "foo:dedup:1" are kept in meta.
+func splitMessageKey(key string) (string, uint64, bool) {
+	i := strings.LastIndex(key, ":")
+	if i <= 0 || i == len(key)-1 || !isTopicName(key[:i]) {
+		return "", 0, false
+	}
+	id, err := strconv.ParseUint(key[i+1:], 10, 64)
+	if err != nil || strconv.FormatUint(id, 10) != key[i+1:] {
+		return "", 0, false
+	}
+	return key[:i], id, true
+}
+
+// isTopicName returns whether name can be a topic of message keys
+func isTopicName(name string) bool {
+	return !strings.ContainsAny(name, ":/")
+}
+
+func escapeTopic(name string) string {
+	return strings.Replace(url.QueryEscape(name), ".", "%2E", -1)
+}
+
+// Set implements the Set interface
+func (s *LogStore) Set(key string, data []byte) error {
+	return s.MultiSet([]string{key}, [][]byte{data})
+}
+
+// Get implements the Get interface
+func (s *LogStore) Get(key string) ([]byte, error) {
+	if name, id, ok := splitMessageKey(key); ok {
+		t, err := s.getTopic(name, false)
+		if err != nil {
+			return nil, err
+		}
+		return t.get(id)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, errors.New(errClosed)
+	}
+	data, ok := s.meta[key]
+	if !ok {
+		return nil, errors.New(errNotExisted)
+	}
+	return data, nil
+}
+
+// Del implements the Del interface
+func (s *LogStore) Del(key string) error {
+	if name, id, ok := splitMessageKey(key); ok {
+		return s.DelRange(name+":", id, id+1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New(errClosed)
+	}
+	if _, ok := s.meta[key]; !ok {
+		return nil
+	}
+	return s.appendMeta([]record{{flag: recordDel, key: key}})
+}
+
+// MultiSet implements the MultiSet interface. The messages of a topic are
+// written at once, and they are written and synced before the other keys
+// so that a broken batch never leaves a key which points to missing
+// messages.
+func (s *LogStore) MultiSet(keys []string, datas [][]byte) error {
+	if len(keys) != len(datas) {
+		return errors.New(errModeNotMatched)
+	}
+
+	var names []string
+	messages := make(map[string][]record)
+	var metas []record
+	for i, key := range keys {
+		if name, id, ok := splitMessageKey(key); ok {
+			if _, ok := messages[name]; !ok {
+				names = append(names, name)
+			}
+			messages[name] = append(messages[name], record{flag: recordSet, id: id, data: datas[i]})
+		} else {
+			metas = append(metas, record{flag: recordSet, key: key, data: datas[i]})
+		}
+	}
+
+	policy := s.syncPolicy()
+	for _, name := range names {
+		t, err := s.getTopic(name, true)
+		if err != nil {
+			return err
+		}
+		err = t.set(messages[name], s.segmentSize, policy)
+		if err != nil {
+			return err
+		}
+		// a sync is done by set with SyncAlways and never with SyncNever
+		if len(metas) > 0 {
+			err = t.flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(metas) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New(errClosed)
+	}
+	return s.appendMeta(metas)
+}
+
+// MultiGet implements the MultiGet interface
+func (s *LogStore) MultiGet(keys []string) ([][]byte, error) {
+	datas := make([][]byte, len(keys))
+	for i, key := range keys {
+		data, err := s.Get(key)
+		if err != nil {
+			if err.Error() == errNotExisted {
+				continue
+			}
+			return nil, err
+		}
+		datas[i] = data
+	}
+	return datas, nil
+}
+
+// DelRange implements the DelRange interface. The keys of messages are
+// deleted by tombstones in chunks of writes, and the others one by one.
+func (s *LogStore) DelRange(prefix string, start, end uint64) error {
+	if start >= end {
+		return nil
+	}
+	if name, _, ok := splitMessageKey(prefix + strconv.FormatUint(start, 10)); ok {
+		t, err := s.getTopic(name, false)
+		if err != nil {
+			if err.Error() == errNotExisted {
+				return nil
+			}
+			return err
+		}
+		return delRangeChunks(start, end, func(start, end uint64) error {
+			return t.del(start, end, s.segmentSize, s.syncPolicy())
+		})
+	}
+
+	for id := start; id < end; id++ {
+		err := s.Del(prefix + strconv.FormatUint(id, 10))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements the Close interface
+func (s *LogStore) Close() error {
+	s.mu.Lock()
+	ticker := s.ticker
+	s.ticker = nil
+	s.mu.Unlock()
+	ticker.stop()
+	s.flush()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New(errClosed)
+	}
+	s.closed = true
+	return s.closeFiles()
+}
+
+// SetSyncPolicy implements the Syncer interface
+func (s *LogStore) SetSyncPolicy(p SyncPolicy) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New(errClosed)
+	}
+	ticker := s.ticker
+	s.policy = p
+	s.ticker = nil
+	s.mu.Unlock()
+
+	ticker.stop()
+	s.flush()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ticker = startSyncTicker(p, s.flush)
+	return nil
+}
+
+func (s *LogStore) syncPolicy() SyncPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policy
+}
+
+// flush flushes the written segments and then the meta log, so that the
+// keys in meta log never point to messages which are not flushed
+func (s *LogStore) flush() {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return
+	}
+	topics := make([]*topicLog, 0, len(s.topics))
+	for _, t := range s.topics {
+		topics = append(topics, t)
+	}
+	s.mu.RUnlock()
+
+	for _, t := range topics {
+		err := t.flush()
+		if err != nil {
+			log.Printf("topic log %s sync error: %s", t.dir, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.metaDirty {
+		return
+	}
+	s.metaDirty = false
+	err := s.metaFile.Sync()
+	if err != nil {
+		log.Printf("meta log sync error: %s", err)
+	}
+}
+
+func (s *LogStore) closeFiles() error {
+	var err error
+	if s.metaFile != nil {
+		err = s.metaFile.Close()
+	}
+	for name, t := range s.topics {
+		e := t.close()
+		if e != nil {
+			log.Printf("topic[%s] log close error: %s", name, e)
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *LogStore) getTopic(name string, create bool) (*topicLog, error) {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return nil, errors.New(errClosed)
+	}
+	t, ok := s.topics[name]
+	s.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	if !create {
+		return nil, errors.New(errNotExisted)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New(errClosed)
+	}
+	t, ok = s.topics[name]
+	if ok {
+		return t, nil
+	}
+	t, err := openTopicLog(path.Join(s.path, topicsDirName, escapeTopic(name)))
+	if err != nil {
+		return nil, err
+	}
+	s.topics[name] = t
+	return t, nil
+}
+
+func (s *LogStore) loadTopics() error {
+	dir := path.Join(s.path, topicsDirName)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		name, err := url.QueryUnescape(info.Name())
+		if err != nil {
+			log.Printf("unknown topic dir %s in log store", info.Name())
+			continue
+		}
+		t, err := openTopicLog(path.Join(dir, info.Name()))
+		if err != nil {
+			return fmt.Errorf("topic[%s] log load error: %s", name, err)
+		}
+		s.topics[name] = t
+	}
+	return nil
+}
+
+// ---------- meta log ----------
+
+func encodeMetaRecord(flag byte, key string, data []byte) []byte {
+	buf := make([]byte, metaHeaderSize+len(key)+len(data))
+	buf[4] = flag
+	binary.LittleEndian.PutUint32(buf[5:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[9:], uint32(len(data)))
+	copy(buf[metaHeaderSize:], key)
+	copy(buf[metaHeaderSize+len(key):], data)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+func (s *LogStore) loadMeta() error {
+	metaPath := path.Join(s.path, metaFileName)
+	f, err := os.OpenFile(metaPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	var offset int64
+	for int64(len(data))-offset >= metaHeaderSize {
+		buf := data[offset:]
+		keySize := int64(binary.LittleEndian.Uint32(buf[5:]))
+		valueSize := int64(binary.LittleEndian.Uint32(buf[9:]))
+		size := metaHeaderSize + keySize + valueSize
+		if int64(len(buf)) < size ||
+			crc32.ChecksumIEEE(buf[4:size]) != binary.LittleEndian.Uint32(buf) {
+			break
+		}
+		key := string(buf[metaHeaderSize : metaHeaderSize+keySize])
+		if old, ok := s.meta[key]; ok {
+			s.metaLive -= metaHeaderSize + int64(len(key)+len(old))
+			delete(s.meta, key)
+		}
+		if buf[4] == recordSet {
+			value := make([]byte, valueSize)
+			copy(value, buf[metaHeaderSize+keySize:size])
+			s.meta[key] = value
+			s.metaLive += size
+		}
+		offset += size
+	}
+	if offset < int64(len(data)) {
+		log.Printf("meta log has a broken tail at %d, truncated", offset)
+		err = f.Truncate(offset)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.metaFile = f
+	s.metaSize = offset
+
+	return s.compactMeta(false)
+}
+
+// appendMeta appends the records to the meta log in one write
+func (s *LogStore) appendMeta(records []record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var buf []byte
+	for _, r := range records {
+		buf = append(buf, encodeMetaRecord(r.flag, r.key, r.data)...)
+	}
+	n, err := s.metaFile.Write(buf)
+	if err == nil && s.policy == SyncAlways {
+		err = s.metaFile.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			s.metaFile.Truncate(s.metaSize)
+			s.metaFile.Seek(s.metaSize, io.SeekStart)
+		}
+		return err
+	}
+	s.metaSize += int64(n)
+	s.metaDirty = s.policy != SyncNever && s.policy != SyncAlways
+
+	for _, r := range records {
+		if old, ok := s.meta[r.key]; ok {
+			s.metaLive -= metaHeaderSize + int64(len(r.key)+len(old))
+			delete(s.meta, r.key)
+		}
+		if r.flag == recordSet {
+			value := make([]byte, len(r.data))
+			copy(value, r.data)
+			s.meta[r.key] = value
+			s.metaLive += metaHeaderSize + int64(len(r.key)+len(r.data))
+		}
+	}
+
+	return s.compactMeta(false)
+}
+
+// compactMeta rewrites the meta log with the live pairs only, it is done
+// when the log is big and mostly garbage or if force is true
+func (s *LogStore) compactMeta(force bool) error {
+	if !force && (s.metaSize < metaCompactSize || s.metaSize < 4*s.metaLive) {
+		return nil
+	}
+
+	metaPath := path.Join(s.path, metaFileName)
+	tmpPath := metaPath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for key, value := range s.meta {
+		n, err := f.Write(encodeMetaRecord(recordSet, key, value))
+		if err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		size += int64(n)
+	}
+	err = f.Sync()
+	if err == nil {
+		err = os.Rename(tmpPath, metaPath)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	s.metaFile.Close()
+	s.metaFile = f
+	s.metaSize = size
+	s.metaLive = size
+	s.metaDirty = false
+	return nil
+}
+
+// ---------- topic log ----------
+
+func segmentPath(dir string, seq uint64, ext string) string {
+	return path.Join(dir, fmt.Sprintf("%020d%s", seq, ext))
+}
+
+func openTopicLog(dir string) (*topicLog, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	t := new(topicLog)
+	t.dir = dir
+	t.index = make(map[uint64]location)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Sort(uint64s(seqs))
+
+	for i, seq := range seqs {
+		active := i == len(seqs)-1
+		seg, entries, err := t.loadSegment(seq, active)
+		if err != nil {
+			t.close()
+			return nil, err
+		}
+		t.segments = append(t.segments, seg)
+		for _, e := range entries {
+			t.apply(seg, e)
+		}
+		if active {
+			seg.entries = entries
+		}
+	}
+
+	if len(t.segments) == 0 {
+		err = t.roll()
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.drop()
+	return t, nil
+}
+
+// loadSegment opens a segment and reads its entries from the index file,
+// the log is scanned if the segment is active or the index is broken
+func (t *topicLog) loadSegment(seq uint64, active bool) (*segment, []indexEntry, error) {
+	flag := os.O_RDONLY
+	if active {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(segmentPath(t.dir, seq, segmentExt), flag, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	seg := &segment{seq: seq, file: f, size: info.Size()}
+
+	if !active {
+		entries, ok := readIndex(segmentPath(t.dir, seq, indexExt), seg.size)
+		if ok {
+			return seg, entries, nil
+		}
+	}
+
+	entries, size, err := scanSegment(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if size < seg.size {
+		log.Printf("segment %s has a broken tail at %d", f.Name(), size)
+		if active {
+			err = f.Truncate(size)
+			if err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+		}
+		seg.size = size
+	}
+	if !active {
+		err = writeIndex(segmentPath(t.dir, seq, indexExt), entries)
+		if err != nil {
+			log.Printf("segment %s index write error: %s", f.Name(), err)
+		}
+	}
+	return seg, entries, nil
+}
+
+func scanSegment(f *os.File) ([]indexEntry, int64, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []indexEntry
+	var offset int64
+	for int64(len(data))-offset >= segmentHeaderSize {
+		buf := data[offset:]
+		size := binary.LittleEndian.Uint32(buf[13:])
+		end := segmentHeaderSize + int64(size)
+		if int64(len(buf)) < end ||
+			crc32.ChecksumIEEE(buf[4:end]) != binary.LittleEndian.Uint32(buf) {
+			break
+		}
+		entries = append(entries, indexEntry{
+			flag:   buf[4],
+			id:     binary.LittleEndian.Uint64(buf[5:]),
+			offset: offset,
+			size:   size,
+		})
+		offset += end
+	}
+	return entries, offset, nil
+}
+
+func readIndex(indexPath string, segmentSize int64) ([]indexEntry, bool) {
+	data, err := ioutil.ReadFile(indexPath)
+	if err != nil || len(data)%indexEntrySize != 0 {
+		return nil, false
+	}
+	entries := make([]indexEntry, len(data)/indexEntrySize)
+	for i := range entries {
+		buf := data[i*indexEntrySize:]
+		entries[i].flag = buf[0]
+		entries[i].id = binary.LittleEndian.Uint64(buf[1:])
+		entries[i].offset = int64(binary.LittleEndian.Uint64(buf[9:]))
+		entries[i].size = binary.LittleEndian.Uint32(buf[17:])
+	}
+	var end int64
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		end = last.offset + segmentHeaderSize + int64(last.size)
+	}
+	return entries, end == segmentSize
+}
+
+func writeIndex(indexPath string, entries []indexEntry) error {
+	data := make([]byte, len(entries)*indexEntrySize)
+	for i, e := range entries {
+		buf := data[i*indexEntrySize:]
+		buf[0] = e.flag
+		binary.LittleEndian.PutUint64(buf[1:], e.id)
+		binary.LittleEndian.PutUint64(buf[9:], uint64(e.offset))
+		binary.LittleEndian.PutUint32(buf[17:], e.size)
+	}
+	tmpPath := indexPath + ".tmp"
+	err := ioutil.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, indexPath)
+}
+
+// apply applies a record of segment to the index
+func (t *topicLog) apply(seg *segment, e indexEntry) {
+	if old, ok := t.index[e.id]; ok {
+		old.seg.live--
+		delete(t.index, e.id)
+	}
+	if e.flag == recordSet {
+		t.index[e.id] = location{seg, e.offset, e.size}
+		seg.live++
+	}
+}
+
+func (t *topicLog) active() *segment {
+	return t.segments[len(t.segments)-1]
+}
+
+// roll seals the active segment and starts a new one
+func (t *topicLog) roll() error {
+	var seq uint64 = 1
+	if len(t.segments) > 0 {
+		last := t.active()
+		if t.dirty {
+			err := last.file.Sync()
+			if err != nil {
+				return err
+			}
+			t.dirty = false
+		}
+		err := writeIndex(segmentPath(t.dir, last.seq, indexExt), last.entries)
+		if err != nil {
+			return err
+		}
+		last.entries = nil
+		seq = last.seq + 1
+	}
+
+	f, err := os.OpenFile(segmentPath(t.dir, seq, segmentExt), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	os.Remove(segmentPath(t.dir, seq, indexExt))
+	t.segments = append(t.segments, &segment{seq: seq, file: f})
+	return nil
+}
+
+// drop removes the oldest segments in which no message is alive. Segments
+// are dropped in order so that the tombstones in a segment are never lost
+// before the messages they delete.
+func (t *topicLog) drop() {
+	for len(t.segments) > 1 && t.segments[0].live == 0 {
+		seg := t.segments[0]
+		seg.file.Close()
+		err := os.Remove(segmentPath(t.dir, seg.seq, segmentExt))
+		if err != nil {
+			log.Printf("segment %d of %s remove error: %s", seg.seq, t.dir, err)
+			return
+		}
+		os.Remove(segmentPath(t.dir, seg.seq, indexExt))
+		t.segments = t.segments[1:]
+	}
+}
+
+// record is a record to be appended to a log
+type record struct {
+	flag byte
+	id   uint64
+	key  string
+	data []byte
+}
+
+// append appends the records to the active segment in one write
+func (t *topicLog) append(records []record, segmentSize int64, policy SyncPolicy) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if t.active().size >= segmentSize {
+		err := t.roll()
+		if err != nil {
+			return err
+		}
+	}
+	seg := t.active()
+
+	size := 0
+	for _, r := range records {
+		size += segmentHeaderSize + len(r.data)
+	}
+	buf := make([]byte, size)
+	entries := make([]indexEntry, len(records))
+	offset := 0
+	for i, r := range records {
+		b := buf[offset : offset+segmentHeaderSize+len(r.data)]
+		b[4] = r.flag
+		binary.LittleEndian.PutUint64(b[5:], r.id)
+		binary.LittleEndian.PutUint32(b[13:], uint32(len(r.data)))
+		copy(b[segmentHeaderSize:], r.data)
+		binary.LittleEndian.PutUint32(b, crc32.ChecksumIEEE(b[4:]))
+		entries[i] = indexEntry{r.flag, r.id, seg.size + int64(offset), uint32(len(r.data))}
+		offset += len(b)
+	}
+
+	n, err := seg.file.WriteAt(buf, seg.size)
+	if err == nil && policy == SyncAlways {
+		err = seg.file.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			seg.file.Truncate(seg.size)
+		}
+		return err
+	}
+
+	seg.size += int64(n)
+	t.dirty = t.dirty || (policy != SyncNever && policy != SyncAlways)
+	seg.entries = append(seg.entries, entries...)
+	for _, e := range entries {
+		t.apply(seg, e)
+	}
+	t.drop()
+	return nil
+}
+
+func (t *topicLog) set(records []record, segmentSize int64, policy SyncPolicy) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.append(records, segmentSize, policy)
+}
+
+func (t *topicLog) get(id uint64) ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	loc, ok := t.index[id]
+	if !ok {
+		return nil, errors.New(errNotExisted)
+	}
+	data := make([]byte, loc.size)
+	_, err := loc.seg.file.ReadAt(data, loc.offset+segmentHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// del appends the tombstones of the messages from start to end-1
+func (t *topicLog) del(start, end uint64, segmentSize int64, policy SyncPolicy) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var records []record
+	for id := start; id < end; id++ {
+		if _, ok := t.index[id]; ok {
+			records = append(records, record{flag: recordDel, id: id})
+		}
+	}
+	return t.append(records, segmentSize, policy)
+}
+
+// flush flushes the writes of the active segment
+func (t *topicLog) flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	t.dirty = false
+	return t.active().file.Sync()
+}
+
+func (t *topicLog) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+	for _, seg := range t.segments {
+		e := seg.file.Close()
+		if e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type uint64s []uint64
+
+func (a uint64s) Len() int           { return len(a) }
+func (a uint64s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a uint64s) Less(i, j int) bool { return a[i] < a[j] }
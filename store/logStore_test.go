@@ -0,0 +1,171 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	lsdb    Storage
+	logPath string
+)
+
+func init() {
+	logPath = os.TempDir() + "/uq.store.test.log"
+}
+
+func segmentFiles(topic string) []string {
+	infos, _ := ioutil.ReadDir(path.Join(logPath, topicsDirName, topic))
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func TestNewLogStore(t *testing.T) {
+	Convey("Test New Log Store", t, func() {
+		os.RemoveAll(logPath)
+		lsdb, err = newLogStore(logPath, 256)
+		So(err, ShouldBeNil)
+		So(lsdb, ShouldNotBeNil)
+
+		lsdb2, err2 := NewLogStore("")
+		So(err2, ShouldNotBeNil)
+		So(lsdb2, ShouldBeNil)
+	})
+}
+
+func TestSplitMessageKey(t *testing.T) {
+	Convey("Test Split Message Key", t, func() {
+		name, id, ok := splitMessageKey("foo:12")
+		So(ok, ShouldBeTrue)
+		So(name, ShouldEqual, "foo")
+		So(id, ShouldEqual, 12)
+
+		for _, key := range []string{"foo", "foo:head", "foo/x:head", "foo:1:delay", ":1", "foo:", "foo:01", "foo:+1", "foo/x:inflight:1", "foo:dedup:1", "foo/x:1"} {
+			_, _, ok = splitMessageKey(key)
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
+
+func TestSetLog(t *testing.T) {
+	Convey("Test Log Store Set", t, func() {
+		err = lsdb.Set("foo", []byte("bar"))
+		So(err, ShouldBeNil)
+		err = lsdb.Set("foo:head", []byte("0"))
+		So(err, ShouldBeNil)
+		for i := 0; i < 40; i++ {
+			err = lsdb.Set("foo:"+strconv.Itoa(i), []byte("message "+strconv.Itoa(i)))
+			So(err, ShouldBeNil)
+		}
+		So(len(segmentFiles("foo")), ShouldBeGreaterThan, 4)
+	})
+}
+
+func TestGetLog(t *testing.T) {
+	Convey("Test Log Store Get", t, func() {
+		data, err := lsdb.Get("foo")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "bar")
+		data, err = lsdb.Get("foo:7")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "message 7")
+
+		_, err = lsdb.Get("bar")
+		So(err, ShouldNotBeNil)
+		_, err = lsdb.Get("foo:40")
+		So(err, ShouldNotBeNil)
+		_, err = lsdb.Get("bar:1")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDelLog(t *testing.T) {
+	Convey("Test Log Store Del", t, func() {
+		So(segmentFiles("foo")[0], ShouldEqual, "00000000000000000001.idx")
+		for i := 0; i < 20; i++ {
+			err = lsdb.Del("foo:" + strconv.Itoa(i))
+			So(err, ShouldBeNil)
+		}
+		// the segments of deleted messages are dropped
+		So(segmentFiles("foo")[0], ShouldNotEqual, "00000000000000000001.idx")
+		So(segmentFiles("foo")[0], ShouldNotEqual, "00000000000000000002.idx")
+
+		_, err = lsdb.Get("foo:3")
+		So(err, ShouldNotBeNil)
+		data, err := lsdb.Get("foo:20")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "message 20")
+
+		err = lsdb.Del("foo:head")
+		So(err, ShouldBeNil)
+		_, err = lsdb.Get("foo:head")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestBatchLog(t *testing.T) {
+	Convey("Test Log Store Batch", t, func() {
+		checkBatch(lsdb)
+	})
+}
+
+func TestReopenLog(t *testing.T) {
+	Convey("Test Log Store Reopen", t, func() {
+		err = lsdb.Set("foo:25", []byte("message 25 again"))
+		So(err, ShouldBeNil)
+		err = lsdb.Close()
+		So(err, ShouldBeNil)
+
+		// a torn write at the tail is dropped when the store is opened
+		files := segmentFiles("foo")
+		f, err := os.OpenFile(path.Join(logPath, topicsDirName, "foo", files[len(files)-1]), os.O_WRONLY|os.O_APPEND, 0644)
+		So(err, ShouldBeNil)
+		f.Write([]byte{1, 2, 3, 4, 5})
+		f.Close()
+
+		lsdb, err = newLogStore(logPath, 256)
+		So(err, ShouldBeNil)
+		data, err := lsdb.Get("foo")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "bar")
+		_, err = lsdb.Get("foo:head")
+		So(err, ShouldNotBeNil)
+		_, err = lsdb.Get("foo:19")
+		So(err, ShouldNotBeNil)
+		data, err = lsdb.Get("foo:25")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "message 25 again")
+		data, err = lsdb.Get("foo:39")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "message 39")
+
+		err = lsdb.Set("foo:40", []byte("message 40"))
+		So(err, ShouldBeNil)
+		data, err = lsdb.Get("foo:40")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "message 40")
+	})
+}
+
+func TestCloseLog(t *testing.T) {
+	Convey("Test Log Store Close", t, func() {
+		err = lsdb.Close()
+		So(err, ShouldBeNil)
+
+		err = lsdb.Close()
+		So(err, ShouldNotBeNil)
+		_, err = lsdb.Get("foo")
+		So(err, ShouldNotBeNil)
+
+		err = os.RemoveAll(logPath)
+		So(err, ShouldBeNil)
+	})
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"errors"
+	"strconv"
 	"sync"
 )
 
@@ -55,6 +56,46 @@ func (m *MemStore) Del(key string) error {
 	return nil
 }
 
+// MultiSet implements the MultiSet interface
+func (m *MemStore) MultiSet(keys []string, datas [][]byte) error {
+	if len(keys) != len(datas) {
+		return errors.New(errModeNotMatched)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, key := range keys {
+		m.db[key] = datas[i]
+	}
+	return nil
+}
+
+// MultiGet implements the MultiGet interface
+func (m *MemStore) MultiGet(keys []string) ([][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	datas := make([][]byte, len(keys))
+	for i, key := range keys {
+		datas[i] = m.db[key]
+	}
+	return datas, nil
+}
+
+// DelRange implements the DelRange interface
+func (m *MemStore) DelRange(prefix string, start, end uint64) error {
+	return delRangeChunks(start, end, func(start, end uint64) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		for id := start; id < end; id++ {
+			delete(m.db, prefix+strconv.FormatUint(id, 10))
+		}
+		return nil
+	})
+}
+
 // Close implements the Close interface
 func (m *MemStore) Close() error {
 	m.mu.Lock()
@@ -48,6 +48,12 @@ func TestDelMem(t *testing.T) {
 	})
 }
 
+func TestBatchMem(t *testing.T) {
+	Convey("Test Mem Store Batch", t, func() {
+		checkBatch(mdb)
+	})
+}
+
 func TestCloseMem(t *testing.T) {
 	Convey("Test Mem Store Close", t, func() {
 		err = mdb.Close()
@@ -0,0 +1,21 @@
+//go:build !rocksdb
+// +build !rocksdb
+
+package store
+
+import (
+	"errors"
+)
+
+// RocksdbEnabled is whether uq is built with rocksdb
+const RocksdbEnabled = false
+
+// RockStore is the rocksdb storage, which is not built in this uq
+type RockStore struct {
+	Storage
+}
+
+// NewRockStore returns an error because uq is built without rocksdb
+func NewRockStore(path string, o RockOptions) (*RockStore, error) {
+	return nil, errors.New(errNoRocksdb)
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"fmt"
+)
+
+// RockOptions is the tuning of rocksdb storage
+type RockOptions struct {
+	// BlockCache is the size of LRU block cache in bytes, 0 means the
+	// default cache of rocksdb
+	BlockCache int64
+	// WriteBuffer is the size of memtable in bytes, 0 means the default
+	// size of rocksdb
+	WriteBuffer int64
+	// Compression is the compression of blocks, "snappy" or "none"
+	Compression string
+}
+
+// DefaultRockOptions returns the default tuning of rocksdb storage
+func DefaultRockOptions() RockOptions {
+	return RockOptions{
+		BlockCache:  8 << 20,
+		WriteBuffer: 4 << 20,
+		Compression: "snappy",
+	}
+}
+
+// Check checks the tuning of rocksdb storage
+func (o RockOptions) Check() error {
+	if o.BlockCache < 0 {
+		return fmt.Errorf("block cache should not be negative")
+	}
+	if o.WriteBuffer < 0 {
+		return fmt.Errorf("write buffer should not be negative")
+	}
+	switch o.Compression {
+	case "snappy", "none":
+	default:
+		return fmt.Errorf("compression %s is not supported", o.Compression)
+	}
+	return nil
+}
+
+// errNoRocksdb is returned when a uq built without rocksdb uses it
+const errNoRocksdb = "uq is built without rocksdb, build it with -tags rocksdb to use it"
@@ -0,0 +1,31 @@
+package store
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRockOptions(t *testing.T) {
+	Convey("Test Rock Options", t, func() {
+		o := DefaultRockOptions()
+		So(o.Check(), ShouldBeNil)
+		o.Compression = "none"
+		So(o.Check(), ShouldBeNil)
+
+		o.Compression = "lzma"
+		So(o.Check(), ShouldNotBeNil)
+		o = DefaultRockOptions()
+		o.BlockCache = -1
+		So(o.Check(), ShouldNotBeNil)
+		o = DefaultRockOptions()
+		o.WriteBuffer = -1
+		So(o.Check(), ShouldNotBeNil)
+
+		if !RocksdbEnabled {
+			_, err := NewRockStore("/tmp/uq.store.test.rocksdb", DefaultRockOptions())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "-tags rocksdb")
+		}
+	})
+}
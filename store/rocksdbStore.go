@@ -1,55 +1,218 @@
+//go:build rocksdb
+// +build rocksdb
+
 package store
 
-// to avoid cgo build, hide rocksdb now.
-/*
 import (
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+
 	"github.com/DanielMorsing/rocksdb"
 )
 
+// RocksdbEnabled is whether uq is built with rocksdb
+const RocksdbEnabled = true
+
+// RockStore is the rocksdb storage, it needs cgo and is built with the
+// rocksdb build tag
 type RockStore struct {
-	path string
-	db   *rocksdb.DB
-	ro   *rocksdb.ReadOptions
-	wo   *rocksdb.WriteOptions
-}
+	path   string
+	db     *rocksdb.DB
+	opts   *rocksdb.Options
+	cache  *rocksdb.Cache
+	ro     *rocksdb.ReadOptions
+	wo     *rocksdb.WriteOptions
+	swo    *rocksdb.WriteOptions
+	closed bool
 
-func NewRockStore(path string) (*RockStore, error) {
-	option := rocksdb.NewOptions()
-	// option.SetCache(rocksdb.NewLRUCache(3 << 30))
-	option.SetCreateIfMissing(true)
+	syncLock sync.Mutex
+	policy   SyncPolicy
+	ticker   *syncTicker
+	dirty    bool
+}
 
-	db, err := rocksdb.Open(path, option)
+// NewRockStore returns a new RockStore
+func NewRockStore(path string, o RockOptions) (*RockStore, error) {
+	if path == "" {
+		return nil, errors.New("rocksdb path is empty")
+	}
+	err := o.Check()
 	if err != nil {
 		return nil, err
 	}
 
-	ro := rocksdb.NewReadOptions()
-	wo := rocksdb.NewWriteOptions()
+	opts := rocksdb.NewOptions()
+	opts.SetCreateIfMissing(true)
+	var cache *rocksdb.Cache
+	if o.BlockCache > 0 {
+		cache = rocksdb.NewLRUCache(int(o.BlockCache))
+		opts.SetCache(cache)
+	}
+	if o.WriteBuffer > 0 {
+		opts.SetWriteBufferSize(int(o.WriteBuffer))
+	}
+	if o.Compression == "none" {
+		opts.SetCompression(rocksdb.NoCompression)
+	} else {
+		opts.SetCompression(rocksdb.SnappyCompression)
+	}
+
+	db, err := rocksdb.Open(path, opts)
+	if err != nil {
+		opts.Close()
+		if cache != nil {
+			cache.Close()
+		}
+		return nil, err
+	}
 
 	rs := new(RockStore)
 	rs.path = path
 	rs.db = db
-	rs.ro = ro
-	rs.wo = wo
+	rs.opts = opts
+	rs.cache = cache
+	rs.ro = rocksdb.NewReadOptions()
+	rs.wo = rocksdb.NewWriteOptions()
+	rs.swo = rocksdb.NewWriteOptions()
+	rs.swo.SetSync(true)
 
 	return rs, nil
 }
 
+// Set implements the Set interface
 func (r *RockStore) Set(key string, data []byte) error {
-	return r.db.Put(r.wo, []byte(key), data)
+	return r.db.Put(r.writeOptions(), []byte(key), data)
 }
 
+// Get implements the Get interface
 func (r *RockStore) Get(key string) ([]byte, error) {
-	return r.db.Get(r.ro, []byte(key))
+	data, err := r.db.Get(r.ro, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New(errNotExisted)
+	}
+	return data, nil
 }
 
+// Del implements the Del interface
 func (r *RockStore) Del(key string) error {
-	return r.db.Delete(r.wo, []byte(key))
+	return r.db.Delete(r.writeOptions(), []byte(key))
+}
+
+// MultiSet implements the MultiSet interface
+func (r *RockStore) MultiSet(keys []string, datas [][]byte) error {
+	if len(keys) != len(datas) {
+		return errors.New(errModeNotMatched)
+	}
+
+	batch := rocksdb.NewWriteBatch()
+	defer batch.Close()
+	for i, key := range keys {
+		batch.Put([]byte(key), datas[i])
+	}
+	return r.db.Write(r.writeOptions(), batch)
+}
+
+// MultiGet implements the MultiGet interface
+func (r *RockStore) MultiGet(keys []string) ([][]byte, error) {
+	snapshot := r.db.NewSnapshot()
+	defer r.db.ReleaseSnapshot(snapshot)
+	ro := rocksdb.NewReadOptions()
+	defer ro.Close()
+	ro.SetSnapshot(snapshot)
+
+	datas := make([][]byte, len(keys))
+	for i, key := range keys {
+		data, err := r.db.Get(ro, []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		datas[i] = data
+	}
+	return datas, nil
+}
+
+// DelRange implements the DelRange interface
+func (r *RockStore) DelRange(prefix string, start, end uint64) error {
+	return delRangeChunks(start, end, func(start, end uint64) error {
+		batch := rocksdb.NewWriteBatch()
+		defer batch.Close()
+		for id := start; id < end; id++ {
+			batch.Delete([]byte(prefix + strconv.FormatUint(id, 10)))
+		}
+		return r.db.Write(r.writeOptions(), batch)
+	})
+}
+
+// SetSyncPolicy implements the Syncer interface
+func (r *RockStore) SetSyncPolicy(p SyncPolicy) error {
+	r.syncLock.Lock()
+	ticker := r.ticker
+	r.policy = p
+	r.ticker = nil
+	r.syncLock.Unlock()
+
+	ticker.stop()
+	r.flush()
+
+	r.syncLock.Lock()
+	defer r.syncLock.Unlock()
+	r.ticker = startSyncTicker(p, r.flush)
+	return nil
+}
+
+// writeOptions returns the options of a write, it syncs if the policy is
+// always and marks the writes to be flushed otherwise
+func (r *RockStore) writeOptions() *rocksdb.WriteOptions {
+	r.syncLock.Lock()
+	defer r.syncLock.Unlock()
+	if r.policy == SyncAlways {
+		return r.swo
+	}
+	r.dirty = r.policy != SyncNever
+	return r.wo
+}
+
+// flush flushes the writes of rocksdb by a synced write
+func (r *RockStore) flush() {
+	r.syncLock.Lock()
+	dirty := r.dirty
+	r.dirty = false
+	r.syncLock.Unlock()
+	if !dirty {
+		return
+	}
+
+	err := r.db.Delete(r.swo, []byte(syncMarkerKey))
+	if err != nil {
+		log.Printf("rocksdb sync error: %s", err)
+	}
 }
 
+// Close implements the Close interface
 func (r *RockStore) Close() error {
+	if r.closed {
+		return errors.New(errClosed)
+	}
+	r.syncLock.Lock()
+	ticker := r.ticker
+	r.ticker = nil
+	r.syncLock.Unlock()
+	ticker.stop()
+	r.flush()
+
 	r.db.Close()
+	r.ro.Close()
+	r.wo.Close()
+	r.swo.Close()
+	r.opts.Close()
+	if r.cache != nil {
+		r.cache.Close()
+	}
+	r.closed = true
 	return nil
 }
-
-*/
@@ -0,0 +1,89 @@
+//go:build rocksdb
+// +build rocksdb
+
+package store
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	rdb      Storage
+	rockPath string
+)
+
+func init() {
+	rockPath = os.TempDir() + "/uq.store.test.rocksdb"
+}
+
+func TestNewRockStore(t *testing.T) {
+	Convey("Test New Rock Store", t, func() {
+		os.RemoveAll(rockPath)
+		rdb, err = NewRockStore(rockPath, DefaultRockOptions())
+		So(err, ShouldBeNil)
+		So(rdb, ShouldNotBeNil)
+
+		rdb2, err2 := NewRockStore("", DefaultRockOptions())
+		So(err2, ShouldNotBeNil)
+		So(rdb2, ShouldBeNil)
+
+		o := DefaultRockOptions()
+		o.Compression = "lzma"
+		rdb2, err2 = NewRockStore(rockPath+".bad", o)
+		So(err2, ShouldNotBeNil)
+		So(rdb2, ShouldBeNil)
+	})
+}
+
+func TestSetRock(t *testing.T) {
+	Convey("Test Rock Store Set", t, func() {
+		err = rdb.Set("foo", []byte("bar"))
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestGetRock(t *testing.T) {
+	Convey("Test Rock Store Get", t, func() {
+		data, err := rdb.Get("foo")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "bar")
+
+		_, err = rdb.Get("bar")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDelRock(t *testing.T) {
+	Convey("Test Rock Store Del", t, func() {
+		err = rdb.Del("foo")
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestBatchRock(t *testing.T) {
+	Convey("Test Rock Store Batch", t, func() {
+		checkBatch(rdb)
+	})
+}
+
+func TestSyncRock(t *testing.T) {
+	Convey("Test Rock Store Sync", t, func() {
+		checkSync(rdb)
+	})
+}
+
+func TestCloseRock(t *testing.T) {
+	Convey("Test Rock Store Close", t, func() {
+		err = rdb.Close()
+		So(err, ShouldBeNil)
+
+		err = rdb.Close()
+		So(err, ShouldNotBeNil)
+
+		err = os.RemoveAll(rockPath)
+		So(err, ShouldBeNil)
+	})
+}
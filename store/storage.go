@@ -3,6 +3,9 @@ package store
 const (
 	errNotExisted     string = "Data Not Existed"
 	errModeNotMatched string = "Storage Mode Not Matched"
+
+	// delRangeBatch is the most keys deleted in one write by DelRange
+	delRangeBatch uint64 = 1024
 )
 
 // Storage is the storage of uq
@@ -10,5 +13,28 @@ type Storage interface {
 	Set(key string, data []byte) error
 	Get(key string) ([]byte, error)
 	Del(key string) error
+	// MultiSet sets the pairs of keys and datas in one write
+	MultiSet(keys []string, datas [][]byte) error
+	// MultiGet gets the datas of keys, the data of a key not existed is nil
+	MultiGet(keys []string) ([][]byte, error)
+	// DelRange deletes the keys from prefix+start to prefix+(end-1)
+	DelRange(prefix string, start, end uint64) error
 	Close() error
 }
+
+// delRangeChunks calls del for the chunks of range from start to end-1,
+// so that a big range is never deleted in one batch
+func delRangeChunks(start, end uint64, del func(start, end uint64) error) error {
+	for start < end {
+		next := end
+		if end-start > delRangeBatch {
+			next = start + delRangeBatch
+		}
+		err := del(start, next)
+		if err != nil {
+			return err
+		}
+		start = next
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// checkBatch checks the batch operations of a storage
+func checkBatch(db Storage) {
+	keys := []string{"batch", "batch:head"}
+	datas := [][]byte{[]byte("topic"), []byte("0")}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, "batch:"+strconv.Itoa(i))
+		datas = append(datas, []byte(strconv.Itoa(i)))
+	}
+	err := db.MultiSet(keys, datas)
+	So(err, ShouldBeNil)
+	err = db.MultiSet(keys, datas[1:])
+	So(err, ShouldNotBeNil)
+
+	got, err := db.MultiGet([]string{"batch", "batch:3", "batch:10", "nobatch"})
+	So(err, ShouldBeNil)
+	So(len(got), ShouldEqual, 4)
+	So(string(got[0]), ShouldEqual, "topic")
+	So(string(got[1]), ShouldEqual, "3")
+	So(got[2], ShouldBeNil)
+	So(got[3], ShouldBeNil)
+
+	err = db.DelRange("batch:", 0, 5)
+	So(err, ShouldBeNil)
+	err = db.DelRange("nobatch:", 0, 5)
+	So(err, ShouldBeNil)
+	_, err = db.Get("batch:4")
+	So(err, ShouldNotBeNil)
+	data, err := db.Get("batch:5")
+	So(err, ShouldBeNil)
+	So(string(data), ShouldEqual, "5")
+	data, err = db.Get("batch:head")
+	So(err, ShouldBeNil)
+	So(string(data), ShouldEqual, "0")
+
+	err = db.DelRange("batch:", 5, 10)
+	So(err, ShouldBeNil)
+	got, err = db.MultiGet(keys[2:])
+	So(err, ShouldBeNil)
+	for _, data := range got {
+		So(data, ShouldBeNil)
+	}
+}
+
+func TestDelRangeChunks(t *testing.T) {
+	Convey("Test Del Range In Chunks", t, func() {
+		var chunks [][2]uint64
+		del := func(start, end uint64) error {
+			chunks = append(chunks, [2]uint64{start, end})
+			return nil
+		}
+		err := delRangeChunks(3, 3+2*delRangeBatch+1, del)
+		So(err, ShouldBeNil)
+		So(chunks, ShouldResemble, [][2]uint64{
+			{3, 3 + delRangeBatch},
+			{3 + delRangeBatch, 3 + 2*delRangeBatch},
+			{3 + 2*delRangeBatch, 4 + 2*delRangeBatch},
+		})
+
+		chunks = nil
+		err = delRangeChunks(5, 5, del)
+		So(err, ShouldBeNil)
+		So(len(chunks), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SyncPolicy is how the writes of a storage are flushed to disk. A positive
+// policy is the interval between two flushes, so that no more than the
+// writes in an interval are lost if the machine crashes.
+type SyncPolicy time.Duration
+
+const (
+	// SyncNever leaves the flushes to the operating system
+	SyncNever SyncPolicy = 0
+	// SyncAlways flushes every write before it returns
+	SyncAlways SyncPolicy = -1
+)
+
+// ParseSyncPolicy parses a sync policy, which is "always", "never" or an
+// interval like "100ms". A plain number is in milliseconds.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	}
+
+	var d time.Duration
+	if ms, err := strconv.ParseUint(s, 10, 32); err == nil {
+		d = time.Duration(ms) * time.Millisecond
+	} else if d, err = time.ParseDuration(s); err != nil {
+		return SyncNever, fmt.Errorf("bad sync policy %s", s)
+	}
+	if d <= 0 {
+		return SyncNever, fmt.Errorf("sync interval should be positive")
+	}
+	return SyncPolicy(d), nil
+}
+
+func (p SyncPolicy) String() string {
+	switch {
+	case p == SyncAlways:
+		return "always"
+	case p <= SyncNever:
+		return "never"
+	}
+	return time.Duration(p).String()
+}
+
+// Syncer is a storage which can flush its writes to disk
+type Syncer interface {
+	SetSyncPolicy(p SyncPolicy) error
+}
+
+// syncTicker calls flush at the interval of a sync policy until it is
+// stopped
+type syncTicker struct {
+	quit chan bool
+	done chan bool
+}
+
+func startSyncTicker(p SyncPolicy, flush func()) *syncTicker {
+	if p <= SyncNever {
+		return nil
+	}
+	st := &syncTicker{
+		quit: make(chan bool),
+		done: make(chan bool),
+	}
+	go func() {
+		defer close(st.done)
+		ticker := time.NewTicker(time.Duration(p))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flush()
+			case <-st.quit:
+				return
+			}
+		}
+	}()
+	return st
+}
+
+// stop stops the ticker and waits for the running flush
+func (st *syncTicker) stop() {
+	if st == nil {
+		return
+	}
+	close(st.quit)
+	<-st.done
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSyncPolicy(t *testing.T) {
+	Convey("Test Parse Sync Policy", t, func() {
+		p, err := ParseSyncPolicy("always")
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, SyncAlways)
+		So(p.String(), ShouldEqual, "always")
+
+		p, err = ParseSyncPolicy("never")
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, SyncNever)
+		So(p.String(), ShouldEqual, "never")
+
+		p, err = ParseSyncPolicy("100")
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, SyncPolicy(100*time.Millisecond))
+		p, err = ParseSyncPolicy("1s")
+		So(err, ShouldBeNil)
+		So(p.String(), ShouldEqual, "1s")
+
+		for _, s := range []string{"", "sometimes", "0", "0s", "-1s"} {
+			_, err = ParseSyncPolicy(s)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+// checkSync writes the storage under each sync policy
+func checkSync(db Storage) {
+	syncer, ok := db.(Syncer)
+	So(ok, ShouldBeTrue)
+	for i, p := range []SyncPolicy{SyncAlways, SyncPolicy(time.Millisecond), SyncNever} {
+		err := syncer.SetSyncPolicy(p)
+		So(err, ShouldBeNil)
+		err = db.MultiSet([]string{"sync", "sync:" + strconv.Itoa(i)}, [][]byte{[]byte(p.String()), []byte(p.String())})
+		So(err, ShouldBeNil)
+		time.Sleep(5 * time.Millisecond)
+		data, err := db.Get("sync")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, p.String())
+	}
+	err := syncer.SetSyncPolicy(SyncPolicy(time.Millisecond))
+	So(err, ShouldBeNil)
+	err = db.Set("sync", []byte("closed"))
+	So(err, ShouldBeNil)
+}
+
+func TestSyncLevel(t *testing.T) {
+	Convey("Test Level Store Sync", t, func() {
+		syncPath := os.TempDir() + "/uq.store.test.sync.db"
+		os.RemoveAll(syncPath)
+		db, err := NewLevelStore(syncPath)
+		So(err, ShouldBeNil)
+		checkSync(db)
+		err = db.Close()
+		So(err, ShouldBeNil)
+		os.RemoveAll(syncPath)
+	})
+}
+
+func TestSyncLog(t *testing.T) {
+	Convey("Test Log Store Sync", t, func() {
+		syncPath := os.TempDir() + "/uq.store.test.sync.log"
+		os.RemoveAll(syncPath)
+		db, err := newLogStore(syncPath, 64)
+		So(err, ShouldBeNil)
+		checkSync(db)
+		err = db.Close()
+		So(err, ShouldBeNil)
+		err = db.SetSyncPolicy(SyncAlways)
+		So(err, ShouldNotBeNil)
+
+		db, err = newLogStore(syncPath, 64)
+		So(err, ShouldBeNil)
+		data, err := db.Get("sync")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "closed")
+		data, err = db.Get("sync:1")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "1ms")
+		err = db.Close()
+		So(err, ShouldBeNil)
+		os.RemoveAll(syncPath)
+	})
+}
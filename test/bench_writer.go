@@ -100,7 +100,7 @@ func pubWorker(mq queue.MessageQueue, td time.Duration, data []byte, topic strin
 	var msgCount int64
 	endTime := time.Now().Add(td)
 	for {
-		err := mq.Push(topic, data)
+		_, err := mq.Push(topic, data)
 		if err != nil {
 			log.Printf("mq push error: %s\n", err)
 		}
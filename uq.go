@@ -3,11 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"path"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -19,16 +21,23 @@ import (
 )
 
 var (
-	ip        string
-	host      string
-	port      int
-	adminPort int
-	protocol  string
-	db        string
-	dir       string
-	logFile   string
-	etcd      string
-	cluster   string
+	ip         string
+	host       string
+	port       int
+	adminPort  int
+	protocol   string
+	db         string
+	dir        string
+	syncPolicy string
+	logFile    string
+	etcd       string
+	cluster    string
+
+	rockCache       int
+	rockWriteBuffer int
+	rockCompression string
+
+	fsckRepair bool
 )
 
 func init() {
@@ -36,12 +45,28 @@ func init() {
 	flag.StringVar(&host, "host", "0.0.0.0", "listen ip")
 	flag.IntVar(&port, "port", 8808, "listen port")
 	flag.IntVar(&adminPort, "admin-port", 8809, "admin listen port")
-	flag.StringVar(&protocol, "protocol", "redis", "frontend interface type [redis/mc/http]")
-	flag.StringVar(&db, "db", "goleveldb", "backend storage type [goleveldb/memdb]")
+	flag.StringVar(&protocol, "protocol", "redis", "frontend interface types [redis/mc/http], separated by comma, each with an optional port like redis:8808")
+	flag.StringVar(&db, "db", "goleveldb", "backend storage type [goleveldb/memdb/log/bolt/rocksdb]")
 	flag.StringVar(&dir, "dir", "./data", "backend storage path")
+	flag.StringVar(&syncPolicy, "sync", "never", "fsync policy of storage [always/never/interval like 100ms]")
 	flag.StringVar(&logFile, "log", "", "uq log path")
 	flag.StringVar(&etcd, "etcd", "", "etcd service location")
 	flag.StringVar(&cluster, "cluster", "uq", "cluster name in etcd")
+	flag.StringVar(&configFile, "config", "", "config file path, reloaded on SIGHUP")
+
+	def := store.DefaultRockOptions()
+	flag.IntVar(&rockCache, "rocksdb-cache", int(def.BlockCache>>20), "block cache size of rocksdb in MB")
+	flag.IntVar(&rockWriteBuffer, "rocksdb-write-buffer", int(def.WriteBuffer>>20), "write buffer size of rocksdb in MB")
+	flag.StringVar(&rockCompression, "rocksdb-compression", def.Compression, "block compression of rocksdb [snappy/none]")
+}
+
+// rockOptions returns the tuning of rocksdb given by flags
+func rockOptions() store.RockOptions {
+	return store.RockOptions{
+		BlockCache:  int64(rockCache) << 20,
+		WriteBuffer: int64(rockWriteBuffer) << 20,
+		Compression: rockCompression,
+	}
 }
 
 func belong(single string, team []string) bool {
@@ -53,18 +78,158 @@ func belong(single string, team []string) bool {
 	return false
 }
 
+// frontend is a protocol served by uq on a port
+type frontend struct {
+	protocol string
+	port     int
+}
+
+// parseFrontends parses the protocols like "redis:8808,http:8080". The
+// default port is used for the protocol without a port.
+func parseFrontends(protocols string, defaultPort int) ([]frontend, error) {
+	var frontends []frontend
+	ports := map[int]bool{adminPort: true}
+	for _, token := range strings.Split(protocols, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		f := frontend{protocol: token, port: defaultPort}
+		if i := strings.Index(token, ":"); i >= 0 {
+			p, err := strconv.Atoi(token[i+1:])
+			if err != nil || p <= 0 || p > 65535 {
+				return nil, fmt.Errorf("port of %s is invalid", token)
+			}
+			f.protocol = token[:i]
+			f.port = p
+		}
+		if !belong(f.protocol, []string{"redis", "mc", "http"}) {
+			return nil, fmt.Errorf("protocol %s is not supported", f.protocol)
+		}
+		for _, one := range frontends {
+			if one.protocol == f.protocol {
+				return nil, fmt.Errorf("protocol %s is duplicated", f.protocol)
+			}
+		}
+		if ports[f.port] {
+			return nil, fmt.Errorf("port %d of %s is already in use", f.port, f.protocol)
+		}
+		ports[f.port] = true
+		frontends = append(frontends, f)
+	}
+	if len(frontends) == 0 {
+		return nil, fmt.Errorf("no protocol is given")
+	}
+	return frontends, nil
+}
+
 func checkArgs() bool {
-	if !belong(db, []string{"goleveldb", "memdb"}) {
+	if !belong(db, []string{"goleveldb", "memdb", "log", "bolt", "rocksdb"}) {
 		fmt.Printf("db mode %s is not supported!\n", db)
 		return false
 	}
-	if !belong(protocol, []string{"redis", "mc", "http"}) {
-		fmt.Printf("protocol %s is not supported!\n", protocol)
+	if db == "rocksdb" {
+		if !store.RocksdbEnabled {
+			fmt.Printf("db mode rocksdb is not supported: uq is built without rocksdb, build it with -tags rocksdb!\n")
+			return false
+		}
+		err := rockOptions().Check()
+		if err != nil {
+			fmt.Printf("rocksdb options are not supported: %s!\n", err)
+			return false
+		}
+	}
+	_, err := store.ParseSyncPolicy(syncPolicy)
+	if err != nil {
+		fmt.Printf("sync policy %s is not supported: %s!\n", syncPolicy, err)
+		return false
+	}
+	_, err = parseFrontends(protocol, port)
+	if err != nil {
+		fmt.Printf("protocol %s is not supported: %s!\n", protocol, err)
+		return false
+	}
+	err = checkCommand()
+	if err != nil {
+		fmt.Printf("%s!\n", err)
 		return false
 	}
 	return true
 }
 
+// checkCommand checks the command given after flags
+func checkCommand() error {
+	switch flag.Arg(0) {
+	case "":
+		return nil
+	case "restore":
+		if flag.NArg() != 2 {
+			return fmt.Errorf("usage: uq [flags] restore FILE, - for stdin")
+		}
+		return nil
+	case "fsck":
+		fsckFlags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+		fsckFlags.BoolVar(&fsckRepair, "repair", false, "repair the problems found")
+		err := fsckFlags.Parse(flag.Args()[1:])
+		if err != nil || fsckFlags.NArg() > 0 {
+			return fmt.Errorf("usage: uq [flags] fsck [-repair]")
+		}
+		return nil
+	}
+	return fmt.Errorf("command %s is not supported", flag.Arg(0))
+}
+
+// fsckStore checks the storage of queue and repairs it if -repair is given,
+// the storage is closed after it
+func fsckStore(storage store.Storage) error {
+	defer storage.Close()
+	report, err := queue.Fsck(storage, fsckRepair)
+	if err != nil {
+		return err
+	}
+	for _, p := range report.Problems {
+		fmt.Printf("%s\n", p)
+	}
+	fmt.Printf("%d topics, %d lines, %d messages checked, %d problems found, %d not repaired\n",
+		report.Topics, report.Lines, report.Messages, len(report.Problems), report.Unrepaired())
+	return nil
+}
+
+// restoreQueue restores the queue in storage from a backup archive, the
+// storage is closed after it
+func restoreQueue(storage store.Storage, file string) error {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			storage.Close()
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	messageQueue, err := queue.NewUnitedQueue(storage, ip, port, nil, cluster)
+	if err != nil {
+		storage.Close()
+		return err
+	}
+	defer messageQueue.Close()
+	return messageQueue.Restore(r)
+}
+
+func newEntrance(f frontend, messageQueue queue.MessageQueue) (entry.Entrance, error) {
+	switch f.protocol {
+	case "http":
+		return entry.NewHTTPEntry(host, f.port, messageQueue)
+	case "mc":
+		return entry.NewMcEntry(host, f.port, messageQueue)
+	case "redis":
+		return entry.NewRedisEntry(host, f.port, messageQueue)
+	}
+	return nil, fmt.Errorf("protocol %s is not supported", f.protocol)
+}
+
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	defer func() {
@@ -73,11 +238,17 @@ func main() {
 
 	flag.Parse()
 
+	err := loadConfig()
+	if err != nil {
+		fmt.Printf("config load error: %s\n", err)
+		return
+	}
+
 	if !checkArgs() {
 		return
 	}
 
-	err := os.MkdirAll(dir, 0755)
+	err = os.MkdirAll(dir, 0755)
 	if err != nil {
 		fmt.Printf("mkdir %s error: %s\n", dir, err)
 		return
@@ -98,15 +269,22 @@ func main() {
 	fmt.Printf("uq started! 😄\n")
 
 	var storage store.Storage
-	// if db == "rocksdb" {
-	// 	dbpath := path.Clean(path.Join(dir, "uq.db"))
-	// 	log.Printf("dbpath: %s", dbpath)
-	// 	storage, err = store.NewRockStore(dbpath)
-	// } else if db == "goleveldb" {
-	if db == "goleveldb" {
+	if db == "rocksdb" {
+		dbpath := path.Clean(path.Join(dir, "uq.db"))
+		log.Printf("dbpath: %s", dbpath)
+		storage, err = store.NewRockStore(dbpath, rockOptions())
+	} else if db == "goleveldb" {
 		dbpath := path.Clean(path.Join(dir, "uq.db"))
 		log.Printf("dbpath: %s", dbpath)
 		storage, err = store.NewLevelStore(dbpath)
+	} else if db == "log" {
+		dbpath := path.Clean(path.Join(dir, "uq.wal"))
+		log.Printf("dbpath: %s", dbpath)
+		storage, err = store.NewLogStore(dbpath)
+	} else if db == "bolt" {
+		dbpath := path.Clean(path.Join(dir, "uq.bolt"))
+		log.Printf("dbpath: %s", dbpath)
+		storage, err = store.NewBoltStore(dbpath)
 	} else if db == "memdb" {
 		storage, err = store.NewMemStore()
 	} else {
@@ -117,6 +295,35 @@ func main() {
 		fmt.Printf("store init error: %s\n", err)
 		return
 	}
+	policy, _ := store.ParseSyncPolicy(syncPolicy)
+	if syncer, ok := storage.(store.Syncer); ok {
+		err = syncer.SetSyncPolicy(policy)
+		if err != nil {
+			fmt.Printf("store sync error: %s\n", err)
+			storage.Close()
+			return
+		}
+		log.Printf("sync policy: %s", policy)
+	} else if policy != store.SyncNever {
+		log.Printf("store %s does not take sync policy, %s is ignored", db, policy)
+	}
+
+	if flag.Arg(0) == "fsck" {
+		err = fsckStore(storage)
+		if err != nil {
+			fmt.Printf("queue fsck error: %s\n", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "restore" {
+		err = restoreQueue(storage, flag.Arg(1))
+		if err != nil {
+			fmt.Printf("queue restore error: %s\n", err)
+			return
+		}
+		fmt.Printf("queue restored from %s\n", flag.Arg(1))
+		return
+	}
 
 	var etcdServers []string
 	if etcd != "" {
@@ -141,57 +348,67 @@ func main() {
 		return
 	}
 
-	var entrance entry.Entrance
-	if protocol == "http" {
-		entrance, err = entry.NewHTTPEntry(host, port, messageQueue)
-	} else if protocol == "mc" {
-		entrance, err = entry.NewMcEntry(host, port, messageQueue)
-	} else if protocol == "redis" {
-		entrance, err = entry.NewRedisEntry(host, port, messageQueue)
-	} else {
-		fmt.Printf("protocol %s is not supported!\n", protocol)
-		return
+	// all the entrances share the message queue, which is closed only
+	// once after all of them are stopped
+	frontends, _ := parseFrontends(protocol, port)
+	entrances := make([]entry.Entrance, 0, len(frontends))
+	stopEntrances := func() {
+		for _, entrance := range entrances {
+			entrance.Stop()
+		}
+		log.Printf("entrances stoped.")
 	}
-	if err != nil {
-		fmt.Printf("entry init error: %s\n", err)
-		messageQueue.Close()
-		return
+	for _, f := range frontends {
+		entrance, err := newEntrance(f, messageQueue)
+		if err != nil {
+			fmt.Printf("entry %s init error: %s\n", f.protocol, err)
+			stopEntrances()
+			messageQueue.Close()
+			return
+		}
+		entrances = append(entrances, entrance)
 	}
 
-	stop := make(chan os.Signal)
+	stop := make(chan os.Signal, 1)
 	entryFailed := make(chan bool)
 	adminFailed := make(chan bool)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	signal.Notify(stop,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
 	var wg sync.WaitGroup
 
-	// start entrance server
-	go func(c chan bool) {
+	// start entrance servers
+	var failOnce sync.Once
+	for _, entrance := range entrances {
 		wg.Add(1)
-		defer wg.Done()
-		err := entrance.ListenAndServe()
-		if err != nil {
-			if !strings.Contains(err.Error(), "stopped") {
-				fmt.Printf("entry listen error: %s\n", err)
+		go func(entrance entry.Entrance, c chan bool) {
+			defer wg.Done()
+			err := entrance.ListenAndServe()
+			if err != nil {
+				if !strings.Contains(err.Error(), "stopped") {
+					fmt.Printf("entry listen error: %s\n", err)
+				}
+				failOnce.Do(func() { close(c) })
 			}
-			close(c)
-		}
-	}(entryFailed)
+		}(entrance, entryFailed)
+	}
 
 	var adminServer admin.Administrator
 	adminServer, err = admin.NewUnitedAdmin(host, adminPort, messageQueue)
 	if err != nil {
 		fmt.Printf("admin init error: %s\n", err)
-		entrance.Stop()
+		stopEntrances()
+		wg.Wait()
+		messageQueue.Close()
 		return
 	}
 
 	// start admin server
+	wg.Add(1)
 	go func(c chan bool) {
-		wg.Add(1)
 		defer wg.Done()
 		err := adminServer.ListenAndServe()
 		if err != nil {
@@ -202,17 +419,30 @@ func main() {
 		}
 	}(adminFailed)
 
-	select {
-	case <-stop:
-		// log.Printf("got signal: %v", signal)
-		adminServer.Stop()
-		log.Printf("admin server stoped.")
-		entrance.Stop()
-		log.Printf("entrance stoped.")
-	case <-entryFailed:
-		messageQueue.Close()
-	case <-adminFailed:
-		entrance.Stop()
+	for quit := false; !quit; {
+		select {
+		case <-reload:
+			err := reloadConfig()
+			if err != nil {
+				log.Printf("config reload error: %s", err)
+			} else {
+				log.Printf("config reloaded.")
+			}
+		case <-stop:
+			// log.Printf("got signal: %v", signal)
+			adminServer.Stop()
+			log.Printf("admin server stoped.")
+			stopEntrances()
+			quit = true
+		case <-entryFailed:
+			adminServer.Stop()
+			stopEntrances()
+			quit = true
+		case <-adminFailed:
+			stopEntrances()
+			quit = true
+		}
 	}
 	wg.Wait()
+	messageQueue.Close()
 }
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"testing"
 
+	"github.com/buaazp/uq/store"
+
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -11,8 +14,59 @@ func TestArgs(t *testing.T) {
 		So(checkArgs(), ShouldEqual, true)
 		db = "mysql"
 		So(checkArgs(), ShouldEqual, false)
+		db = "bolt"
+		So(checkArgs(), ShouldEqual, true)
+		db = "rocksdb"
+		So(checkArgs(), ShouldEqual, store.RocksdbEnabled)
+		rockCompression = "lzma"
+		So(checkArgs(), ShouldEqual, false)
+		rockCompression = "snappy"
 		db = "memdb"
 		protocol = "http2"
 		So(checkArgs(), ShouldEqual, false)
+		protocol = "redis"
+
+		flag.CommandLine.Parse([]string{"restore", "uq.backup"})
+		So(checkArgs(), ShouldEqual, true)
+		flag.CommandLine.Parse([]string{"restore"})
+		So(checkArgs(), ShouldEqual, false)
+		flag.CommandLine.Parse([]string{"backup", "uq.backup"})
+		So(checkArgs(), ShouldEqual, false)
+		flag.CommandLine.Parse([]string{"fsck"})
+		So(checkArgs(), ShouldEqual, true)
+		So(fsckRepair, ShouldEqual, false)
+		flag.CommandLine.Parse([]string{"fsck", "-repair"})
+		So(checkArgs(), ShouldEqual, true)
+		So(fsckRepair, ShouldEqual, true)
+		flag.CommandLine.Parse([]string{"fsck", "all"})
+		So(checkArgs(), ShouldEqual, false)
+		flag.CommandLine.Parse(nil)
+	})
+}
+
+func TestParseFrontends(t *testing.T) {
+	Convey("Test Parse Frontends", t, func() {
+		frontends, err := parseFrontends("redis", 8808)
+		So(err, ShouldBeNil)
+		So(frontends, ShouldResemble, []frontend{{"redis", 8808}})
+
+		frontends, err = parseFrontends("redis, mc:11211,http:8080", 8808)
+		So(err, ShouldBeNil)
+		So(frontends, ShouldResemble, []frontend{
+			{"redis", 8808},
+			{"mc", 11211},
+			{"http", 8080},
+		})
+
+		_, err = parseFrontends("redis,http", 8808)
+		So(err, ShouldNotBeNil)
+		_, err = parseFrontends("redis,redis:8080", 8808)
+		So(err, ShouldNotBeNil)
+		_, err = parseFrontends("http:8809", 8808)
+		So(err, ShouldNotBeNil)
+		_, err = parseFrontends("mc:abc", 8808)
+		So(err, ShouldNotBeNil)
+		_, err = parseFrontends(",", 8808)
+		So(err, ShouldNotBeNil)
 	})
 }
@@ -68,6 +68,12 @@ func NewError(errorCode int, cause string) *Error {
 	}
 }
 
+// IsErrNone returns whether the err means there is no message
+func IsErrNone(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.ErrorCode == ErrNone
+}
+
 // Only for error interface
 func (e Error) Error() string {
 	return ItoaQuick(e.ErrorCode) + " " + e.Message + " (" + e.Cause + ")"
@@ -19,3 +19,10 @@ func TestError(t *testing.T) {
 		)
 	})
 }
+
+func TestIsErrNone(t *testing.T) {
+	Convey("Test IsErrNone", t, func() {
+		So(IsErrNone(NewError(ErrNone, `test`)), ShouldBeTrue)
+		So(IsErrNone(NewError(ErrInternalError, `test`)), ShouldBeFalse)
+	})
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"errors"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -63,3 +64,46 @@ func (sl *StopListener) Accept() (net.Conn, error) {
 func (sl *StopListener) Stop() {
 	close(sl.stop)
 }
+
+// StopGuard keeps the stop listener of a server, so that the server can be
+// stopped safely before, while or after it starts listening
+type StopGuard struct {
+	mu      sync.Mutex
+	sl      *StopListener
+	stopped bool
+}
+
+// Listen listens on addr and returns the stop listener, it fails if the
+// guard is stopped already
+func (g *StopGuard) Listen(addr string) (*StopListener, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return nil, errStopped
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sl, err := NewStopListener(l)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	g.sl = sl
+	return sl, nil
+}
+
+// Stop stops the listener if it is listening, and the later Listen fails
+func (g *StopGuard) Stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return
+	}
+	g.stopped = true
+	if g.sl != nil {
+		g.sl.Stop()
+	}
+}
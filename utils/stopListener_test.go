@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStopGuard(t *testing.T) {
+	Convey("Test Stop Guard", t, func() {
+		// a guard can be stopped before it listens
+		var g StopGuard
+		g.Stop()
+		_, err := g.Listen("127.0.0.1:0")
+		So(err, ShouldNotBeNil)
+		g.Stop()
+
+		var g2 StopGuard
+		sl, err := g2.Listen("127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer sl.Close()
+		g2.Stop()
+		g2.Stop()
+		_, err = sl.Accept()
+		So(err, ShouldNotBeNil)
+	})
+}
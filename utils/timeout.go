@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+var errNegativeTimeout = errors.New("timeout is negative")
+
+// ParseTimeout parses a timeout string. A plain number is taken as seconds,
+// otherwise it should be a duration string like "500ms" or "5s".
+func ParseTimeout(str string) (time.Duration, error) {
+	var timeout time.Duration
+	seconds, err := strconv.ParseUint(str, 10, 0)
+	if err == nil {
+		timeout = time.Duration(seconds) * time.Second
+	} else {
+		timeout, err = time.ParseDuration(str)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if timeout < 0 {
+		return 0, errNegativeTimeout
+	}
+	return timeout, nil
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTimeout(t *testing.T) {
+	Convey("Test ParseTimeout", t, func() {
+		timeout, err := ParseTimeout("5")
+		So(err, ShouldBeNil)
+		So(timeout, ShouldEqual, 5*time.Second)
+
+		timeout, err = ParseTimeout("500ms")
+		So(err, ShouldBeNil)
+		So(timeout, ShouldEqual, 500*time.Millisecond)
+
+		_, err = ParseTimeout("-1s")
+		So(err, ShouldNotBeNil)
+
+		_, err = ParseTimeout("abcd")
+		So(err, ShouldNotBeNil)
+	})
+}